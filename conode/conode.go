@@ -0,0 +1,112 @@
+// Conode runs a server of the collective authority with all registered
+// services, including RandHound.
+//
+// It has three subcommands:
+//
+//	conode setup -address tcp://1.2.3.4:7770 [-desc name] [-private private.toml] [-public public.toml]
+//	conode server [-config private.toml]
+//	conode check -group group.toml [-timeout 10s]
+//
+// setup creates a new keypair and writes the private configuration of the
+// server and its public ServerIdentityToml. Putting the public files of all
+// servers one after the other gives the group file read by check.
+// server starts the server described by the private configuration.
+// check pings every server of the group file.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet/app"
+
+	// Register the services
+	_ "mobilehound/onet/status"
+	_ "mobilehound/randhound"
+)
+
+const (
+	// DefaultPrivate is the default name of the private configuration
+	DefaultPrivate = "private.toml"
+	// DefaultPublic is the default name of the public configuration
+	DefaultPublic = "public.toml"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+	var err error
+	switch os.Args[1] {
+	case "setup":
+		err = setup(args)
+	case "server":
+		err = server(args)
+	case "check":
+		err = check(args)
+	default:
+		usage()
+	}
+	log.ErrFatal(err)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: conode setup|server|check [options]")
+	fmt.Fprintln(os.Stderr, "Use 'conode <command> -h' for the options of a command.")
+	os.Exit(1)
+}
+
+// newFlagSet returns the flags of a subcommand, with the debug-level.
+func newFlagSet(name string) (*flag.FlagSet, *int) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	debug := fs.Int("debug", 0, "debug-level")
+	return fs, debug
+}
+
+func setup(args []string) error {
+	fs, debug := newFlagSet("setup")
+	address := fs.String("address", "", "address of the server, e.g. tcp://1.2.3.4:7770")
+	desc := fs.String("desc", "", "description of the server")
+	private := fs.String("private", DefaultPrivate, "file for the private configuration")
+	public := fs.String("public", DefaultPublic, "file for the public configuration")
+	fs.Parse(args)
+	log.SetDebugVisible(*debug)
+
+	if *address == "" {
+		*address = app.Input("tcp://127.0.0.1:7770", "Address of the server")
+	}
+	hc, err := app.Setup(network.Address(*address), *desc, *private, *public)
+	if err != nil {
+		return err
+	}
+	log.Info("Wrote private configuration to", *private)
+	log.Info("Wrote public configuration to", *public)
+	log.Info("Public key:", hc.Public)
+	return nil
+}
+
+func server(args []string) error {
+	fs, debug := newFlagSet("server")
+	config := fs.String("config", DefaultPrivate, "private configuration of the server")
+	fs.Parse(args)
+	log.SetDebugVisible(*debug)
+	return app.RunServer(*config)
+}
+
+func check(args []string) error {
+	fs, debug := newFlagSet("check")
+	group := fs.String("group", "", "group file with the servers to check")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for each server")
+	fs.Parse(args)
+	log.SetDebugVisible(*debug)
+	if *group == "" {
+		return errors.New("Please give a group file with -group")
+	}
+	return app.CheckGroup(*group, *timeout)
+}
@@ -0,0 +1,198 @@
+// Package mobile exposes RandHound through an API that gomobile can bind. It
+// only uses strings, byte slices, ints, errors and simple structs and
+// interfaces, so that the same functionality is available from Java and
+// Objective-C as from Go.
+//
+// Keys are base64-encoded like in the TOML files of onet. A roster is given as
+// a TOML string of the form
+//
+//	[[List]]
+//	  Public = "base64 public key"
+//	  Address = "tcp://127.0.0.1:7000"
+//
+// with one entry per server. Servers with a "tls://" address authenticate each
+// other with their keys. The websocket of a server listens on the port
+// following the one of its address.
+package mobile
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"mobilehound/crypto"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/randhound"
+	"mobilehound/v0-config"
+)
+
+// KeyPair holds a base64-encoded private and public key.
+type KeyPair struct {
+	Private string
+	Public  string
+}
+
+// NewKeyPair creates a fresh key pair for a server.
+func NewKeyPair() (*KeyPair, error) {
+	kp := config.NewKeyPair(network.Suite)
+	var priv, pub bytes.Buffer
+	if err := crypto.Write64Scalar(network.Suite, &priv, kp.Secret); err != nil {
+		return nil, err
+	}
+	if err := crypto.Write64Point(network.Suite, &pub, kp.Public); err != nil {
+		return nil, err
+	}
+	return &KeyPair{Private: priv.String(), Public: pub.String()}, nil
+}
+
+// Progress receives progress events of a RandHound run. Phase is 1 while the
+// client collects the commitments of the servers and 2 while it collects the
+// decrypted shares; received and expected count the replies of that phase.
+// Implementations must return quickly.
+type Progress interface {
+	OnProgress(phase int, received int, expected int)
+}
+
+// Result is the outcome of a RandHound run.
+type Result struct {
+	Random     []byte // Collective randomness
+	Transcript []byte // JSON encoding of the protocol transcript
+}
+
+// startTimeout is how long StartServer waits for the server to listen.
+var startTimeout = 10 * time.Second
+
+// Server is a running RandHound server.
+type Server struct {
+	server *onet.Server
+	roster *onet.Roster
+}
+
+// StartServer starts a server with the given private key. The roster has to
+// contain an entry with the corresponding public key, whose address is used
+// to listen for connections.
+func StartServer(private string, roster string) (*Server, error) {
+	priv, err := crypto.Read64Scalar(network.Suite, strings.NewReader(private))
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseRoster(roster)
+	if err != nil {
+		return nil, err
+	}
+	pub := network.Suite.Point().Mul(nil, priv)
+	var si *network.ServerIdentity
+	for _, s := range r.List {
+		if s.Public.Equal(pub) {
+			si = s
+		}
+	}
+	if si == nil {
+		return nil, errors.New("Public key not part of the roster")
+	}
+
+	var router *network.Router
+	if si.Address.ConnType() == network.TLS {
+		router, err = network.NewTLSRouter(si, priv)
+	} else {
+		router, err = network.NewTCPRouterWithKey(si, priv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	server := onet.NewServer(router, priv)
+	go server.Start()
+	deadline := time.Now().Add(startTimeout)
+	for !server.Listening() {
+		if time.Now().After(deadline) {
+			server.Close()
+			return nil, errors.New("Server didn't start listening in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return &Server{server: server, roster: r}, nil
+}
+
+// Address returns the address the server listens on.
+func (s *Server) Address() string {
+	return string(s.server.Address())
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// Random runs RandHound with this server as the client on the roster the
+// server was started with. The servers are split into the given number of
+// groups; faulty is the number of servers that may fail. Progress may be nil.
+func (s *Server) Random(groups int, faulty int, purpose string, progress Progress) (*Result, error) {
+	service, ok := s.server.Service(randhound.ServiceName).(*randhound.Service)
+	if !ok {
+		return nil, errors.New("RandHound service not available")
+	}
+	var f randhound.ProgressFunc
+	if progress != nil {
+		f = progress.OnProgress
+	}
+	random, tb, err := service.Generate(s.roster, groups, faulty, purpose, f)
+	if err != nil {
+		return nil, err
+	}
+	t, err := randhound.TranscriptFromBinary(network.Suite, tb)
+	if err != nil {
+		return nil, err
+	}
+	tj, err := t.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Random: random, Transcript: tj}, nil
+}
+
+// Verify checks random against a transcript. The transcript can be given in
+// its JSON or binary encoding. It returns nil if the randomness is valid.
+func Verify(random []byte, transcript []byte) error {
+	var t *randhound.Transcript
+	var err error
+	if len(transcript) > 0 && transcript[0] == '{' {
+		t, err = randhound.TranscriptFromJSON(network.Suite, transcript)
+	} else {
+		t, err = randhound.TranscriptFromBinary(network.Suite, transcript)
+	}
+	if err != nil {
+		return err
+	}
+	return randhound.VerifyTranscript(network.Suite, random, t)
+}
+
+// parseRoster reads a roster from its TOML representation.
+func parseRoster(s string) (*onet.Roster, error) {
+	var rt struct {
+		List []*network.ServerIdentityToml
+	}
+	if _, err := toml.Decode(s, &rt); err != nil {
+		return nil, err
+	}
+	if len(rt.List) == 0 {
+		return nil, errors.New("Empty roster")
+	}
+	list := make([]*network.ServerIdentity, len(rt.List))
+	for i, st := range rt.List {
+		pub, err := crypto.Read64Point(network.Suite, strings.NewReader(st.Public))
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range append([]network.Address{st.Address}, st.AltAddresses...) {
+			if !addr.Valid() {
+				return nil, errors.New("Invalid address " + string(addr))
+			}
+		}
+		list[i] = network.NewServerIdentity(pub, st.Address)
+		list[i].AltAddresses = st.AltAddresses
+	}
+	return onet.NewRoster(list), nil
+}
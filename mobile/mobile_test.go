@@ -0,0 +1,85 @@
+package mobile
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type countProgress struct {
+	sync.Mutex
+	events map[int]int
+}
+
+func (p *countProgress) OnProgress(phase int, received int, expected int) {
+	p.Lock()
+	defer p.Unlock()
+	p.events[phase]++
+}
+
+func TestRandom(t *testing.T) {
+	nodes := 5
+
+	keys := make([]*KeyPair, nodes)
+	roster := ""
+	for i := range keys {
+		var err error
+		if keys[i], err = NewKeyPair(); err != nil {
+			t.Fatal(err)
+		}
+		roster += fmt.Sprintf("[[List]]\n  Public = %q\n  Address = \"tcp://127.0.0.1:%d\"\n",
+			keys[i].Public, freePort(t))
+	}
+
+	servers := make([]*Server, nodes)
+	for i, kp := range keys {
+		var err error
+		if servers[i], err = StartServer(kp.Private, roster); err != nil {
+			t.Fatal("Couldn't start server:", err)
+		}
+		defer servers[i].Close()
+	}
+
+	progress := &countProgress{events: make(map[int]int)}
+	result, err := servers[0].Random(1, 1, "mobile test", progress)
+	if err != nil {
+		t.Fatal("Couldn't run RandHound:", err)
+	}
+	if err := Verify(result.Random, result.Transcript); err != nil {
+		t.Fatal("Verification failed:", err)
+	}
+	progress.Lock()
+	if progress.events[1] == 0 || progress.events[2] == 0 {
+		t.Fatal("Missing progress events:", progress.events)
+	}
+	progress.Unlock()
+
+	result.Random[0] ^= 0xff
+	if err := Verify(result.Random, result.Transcript); err == nil {
+		t.Fatal("Modified randomness should not verify")
+	}
+
+	if _, err := StartServer(keys[0].Private, "[[List]]\n  Public = \"\"\n"); err == nil {
+		t.Fatal("Invalid roster should be rejected")
+	}
+}
+
+// freePort returns a port that is free on localhost together with the
+// following port, which is needed for the websocket.
+func freePort(t *testing.T) int {
+	for {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, p, _ := net.SplitHostPort(l.Addr().String())
+		l.Close()
+		port, _ := strconv.Atoi(p)
+		if l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port+1)); err == nil {
+			l.Close()
+			return port
+		}
+	}
+}
@@ -14,8 +14,7 @@ type ConnType string
 // Address contains the ConnType and the actual network address. It is used to connect
 // to a remote host with a Conn and to listen by a Listener.
 // A network address holds an IP address and the port number joined
-// by a colon.
-// It doesn't support IPv6 yet.
+// by a colon. IPv6 addresses are written in brackets, ex. tcp://[::1]:2000.
 type Address string
 
 var lookupHost = net.LookupHost
@@ -29,6 +28,9 @@ const (
 	PURB = "purb"
 	// Local is a channel based connection type.
 	Local = "local"
+	// Relay is a websocket connection through a relay server to a node that
+	// can't accept incoming connections.
+	Relay = "relay"
 	// InvalidConnType is an invalid connection type.
 	InvalidConnType = "wrong"
 )
@@ -41,7 +43,7 @@ const typeAddressSep = "://"
 // it returns InvalidConnType.
 func connType(t string) ConnType {
 	ct := ConnType(t)
-	types := []ConnType{PlainTCP, TLS, PURB, Local}
+	types := []ConnType{PlainTCP, TLS, PURB, Local, Relay}
 	for _, t := range types {
 		if t == ct {
 			return ct
@@ -68,18 +70,41 @@ func (a Address) IsHostname() bool {
 	// validHostname(host) would be enough with the current implementation.
 	// However, if we will include IDNs as valid hostnames, an IP address in the form
 	// *.*.*.* would be a valid hostname too. This is why ParseIP is used as well.
-	return validHostname(host) && net.ParseIP(host) == nil
+	return validHostname(host) && parseIP(host) == nil
 }
 
 // NetworkAddress returns the network address part of the address, which is
-// the host and the port joined by a colon.
+// the host and the port joined by a colon. For a Relay address, it is the
+// network address of the relay.
 // It returns an empty string the address is not valid
 func (a Address) NetworkAddress() string {
 	if !a.Valid() {
 		return ""
 	}
 	vals := strings.Split(string(a), typeAddressSep)
-	return vals[1]
+	na, _ := splitRelay(vals[1])
+	return na
+}
+
+// RelayID returns the identifier of the node behind the relay of a Relay
+// address, ex: "relay://10.0.0.4:2000/abcd" => "abcd".
+// It returns an empty string if the address is not a valid Relay address.
+func (a Address) RelayID() string {
+	if a.ConnType() != Relay {
+		return ""
+	}
+	vals := strings.Split(string(a), typeAddressSep)
+	_, id := splitRelay(vals[1])
+	return id
+}
+
+// splitRelay splits the network part of an address at the first slash into
+// the network address and the relay identifier.
+func splitRelay(s string) (string, string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
 }
 
 // NetworkAddressResolved returns the network address of the address, but resolved.
@@ -95,20 +120,16 @@ func (a Address) NetworkAddressResolved() string {
 }
 
 // Resolve returns the IP address associated to the hostname that represents the address a.
-// If a is defined by an IP address (*.*.*.*) or if the hostname is not valid, the empty string
-// is returned
+// If a is defined by an IP address (*.*.*.* or an IPv6 address), it is returned without
+// brackets. If the hostname is not valid, the empty string is returned
 func (a Address) Resolve() string {
 	if !a.Valid() {
 		return ""
 	}
 	host := a.Host()
-	// Ipv6 not handled properly yet
-	if host == "[::]" {
-		return "::"
-	}
 	// If the address is defined by an IP address, return it
-	if net.ParseIP(host) != nil {
-		return host
+	if ip := strings.Trim(host, "[]"); parseIP(ip) != nil {
+		return ip
 	}
 
 	if !a.IsHostname() {
@@ -123,6 +144,15 @@ func (a Address) Resolve() string {
 	return ipAddress[0]
 }
 
+// parseIP is like net.ParseIP but also accepts IPv6 addresses with a zone,
+// ex: "fe80::1%eth0".
+func parseIP(s string) net.IP {
+	if i := strings.LastIndex(s, "%"); i > 0 && i < len(s)-1 && strings.Contains(s, ":") {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
 // validHostname returns true if the hostname is well formed or false otherwise.
 // A hostname is well formed if the following conditions are met:
 //	- each label contains from 1 to 63 characters
@@ -138,6 +168,9 @@ func (a Address) Resolve() string {
 // This method assumes that only the host part is passed as parameter
 // This function is integrated in the Valid() function
 func validHostname(s string) bool {
+	if s == "" {
+		return false
+	}
 	s = strings.ToLower(s)
 
 	maxLength := 253
@@ -168,17 +201,28 @@ func validHostname(s string) bool {
 // NetworkAddress must contain the IP address + Port number.
 // The IP address is validated by net.ParseIP & the port must be included in the
 // range [0;65536].
-// Ex. tls:192.168.1.10:5678
+// A Relay address additionally contains the hex-encoded public key of the node
+// behind the relay, separated from the NetworkAddress by a slash.
+// Ex. tls:192.168.1.10:5678, relay://192.168.1.10:5678/8a3f...
 func (a Address) Valid() bool {
 	vals := strings.Split(string(a), typeAddressSep)
 	if len(vals) != 2 {
 		return false
 	}
-	if connType(vals[0]) == InvalidConnType {
+	ct := connType(vals[0])
+	if ct == InvalidConnType {
+		return false
+	}
+	na, id := splitRelay(vals[1])
+	if ct == Relay {
+		if valid, _ := regexp.MatchString("^[0-9a-f]+$", id); !valid {
+			return false
+		}
+	} else if na != vals[1] {
 		return false
 	}
 
-	ip, port, e := net.SplitHostPort(vals[1])
+	ip, port, e := net.SplitHostPort(na)
 	if e != nil {
 		return false
 	}
@@ -188,7 +232,7 @@ func (a Address) Valid() bool {
 		return false
 	}
 
-	if net.ParseIP(ip) == nil {
+	if parseIP(ip) == nil {
 		// if the Host is NOT in the form of *.*.*.* , check whether it has a valid DNS name
 		// This includes "localhost", which is NOT recognized by net.ParseIP
 		return validHostname(ip)
@@ -201,8 +245,9 @@ func (a Address) String() string {
 	return string(a)
 }
 
-// Host returns the host part of the address.
-// ex: "tcp://127.0.0.1:2000" => "127.0.0.1"
+// Host returns the host part of the address. IPv6 addresses are returned
+// in brackets.
+// ex: "tcp://127.0.0.1:2000" => "127.0.0.1", "tcp://[::1]:2000" => "[::1]"
 // In case of an error, it returns an empty string.
 func (a Address) Host() string {
 	na := a.NetworkAddress()
@@ -213,9 +258,9 @@ func (a Address) Host() string {
 	if e != nil {
 		return ""
 	}
-	// IPv6 unspecified address has to be in brackets.
-	if h == "::" {
-		h = "[::]"
+	// IPv6 addresses have to be in brackets.
+	if strings.Contains(h, ":") {
+		h = "[" + h + "]"
 	}
 	return h
 }
@@ -239,12 +284,12 @@ func (a Address) Port() string {
 // Public returns true if the address is a public and valid one
 // or false otherwise.
 // Specifically it checks if it is a private address by checking
-// 192.168.**,10.***,127.***,172.16-31.**,169.254.**,^::1,^fd.{0,2}:
+// 192.168.**,10.***,127.***,172.16-31.**,169.254.**,^::1,^fd.{0,2}:,^fc.{0,2}:,^fe80:
 func (a Address) Public() bool {
 	private, err := regexp.MatchString("(^127\\.)|(^10\\.)|"+
 		"(^172\\.1[6-9]\\.)|(^172\\.2[0-9]\\.)|"+
 		"(^172\\.3[0-1]\\.)|(^192\\.168\\.)|(^169\\.254)|"+
-		"(^\\[::1\\])|(^\\[fd.{0,2}:)", a.NetworkAddressResolved())
+		"(^\\[::1\\])|(^\\[f[cd].{0,2}:)|(^\\[fe80:)", strings.ToLower(a.NetworkAddressResolved()))
 	if err != nil {
 		return false
 	}
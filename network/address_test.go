@@ -18,6 +18,7 @@ func TestConnType(t *testing.T) {
 		{"tcp", PlainTCP},
 		{"tls", TLS},
 		{"purb", PURB},
+		{"relay", Relay},
 		{"tcp4", InvalidConnType},
 		{"_tls", InvalidConnType},
 	}
@@ -72,6 +73,11 @@ func TestAddress(t *testing.T) {
 		{"tlsx10.0.0.4:2000", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tls:10.0.0.4x2000", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tlsx10.0.0.4x2000", false, InvalidConnType, "", "", "", false, "", ""},
+		{"relay://10.0.0.4:2000/0a1b", true, Relay, "10.0.0.4:2000", "10.0.0.4", "2000", false, "10.0.0.4", "10.0.0.4:2000"},
+		{"relay://10.0.0.4:2000", false, InvalidConnType, "", "", "", false, "", ""},
+		{"relay://10.0.0.4:2000/", false, InvalidConnType, "", "", "", false, "", ""},
+		{"relay://10.0.0.4:2000/xyz", false, InvalidConnType, "", "", "", false, "", ""},
+		{"tcp://10.0.0.4:2000/0a1b", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tlxblurdie", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tls://blublublu", false, InvalidConnType, "", "", "", false, "", ""},
 		// dummy values for the IP addresses, defined by dummyResolver
@@ -83,6 +89,13 @@ func TestAddress(t *testing.T) {
 		{"tcp://ipv6.epfl.ch:8080", true, PlainTCP, "ipv6.epfl.ch:8080", "ipv6.epfl.ch", "8080", true, "2001:620:618:10f:1:80b2:f08:1", "[2001:620:618:10f:1:80b2:f08:1]:8080"},
 		{"tcp://ipv6.locala:80", true, PlainTCP, "ipv6.locala:80", "ipv6.locala", "80", false, "fd::1", "[fd::1]:80"},
 		{"tcp://ipv6.localb:80", true, PlainTCP, "ipv6.localb:80", "ipv6.localb", "80", false, "fda::1", "[fda::1]:80"},
+		{"tcp://[::1]:2000", true, PlainTCP, "[::1]:2000", "[::1]", "2000", false, "::1", "[::1]:2000"},
+		{"tcp://[2001:db8::1]:80", true, PlainTCP, "[2001:db8::1]:80", "[2001:db8::1]", "80", true, "2001:db8::1", "[2001:db8::1]:80"},
+		{"tcp://[fe80::1%eth0]:80", true, PlainTCP, "[fe80::1%eth0]:80", "[fe80::1%eth0]", "80", false, "fe80::1%eth0", "[fe80::1%eth0]:80"},
+		{"tcp://[FC00::1]:80", true, PlainTCP, "[FC00::1]:80", "[FC00::1]", "80", false, "FC00::1", "[FC00::1]:80"},
+		{"tcp://::1:2000", false, InvalidConnType, "", "", "", false, "", ""},
+		{"tcp://[::1%]:2000", false, InvalidConnType, "", "", "", false, "", ""},
+		{"tcp://:2000", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tcp://ipv6.localc:80", true, PlainTCP, "ipv6.localc:80", "ipv6.localc", "80", false, "fda9::1", "[fda9::1]:80"},
 	}
 
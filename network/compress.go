@@ -0,0 +1,203 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"mobilehound/v0-log"
+)
+
+// Compression of the messages on a TCPConn is negotiated after the dialing
+// Router sent its ServerIdentity: it sends a Capabilities message listing the
+// algorithms it supports, and the listening Router answers with its own
+// Capabilities. Each side only compresses once it received the Capabilities
+// of the other side, so peers without compression never see a compressed
+// message and just fail to dispatch the Capabilities message. A compressed
+// message is flagged with the highest bit of its size.
+
+// CompressionFlate is the name of the DEFLATE compression.
+const CompressionFlate = "flate"
+
+// compressedFlag marks a compressed message in the size of a packet.
+const compressedFlag Size = 1 << 31
+
+// CompressThreshold is the minimal size of a marshaled message to be
+// compressed. Smaller messages are sent as they are.
+var CompressThreshold = 256
+
+func init() {
+	CapabilitiesType = RegisterMessage(Capabilities{})
+}
+
+// Capabilities announces the optional features a Router supports on a
+// connection.
+type Capabilities struct {
+	Compression []string
+	// Reply is true for the answer of the listening Router.
+	Reply bool
+}
+
+// CapabilitiesType can be used to recognise a Capabilities-message.
+var CapabilitiesType MessageTypeID
+
+// compressor is implemented by the connections that can compress messages.
+type compressor interface {
+	setCompression(enable bool)
+}
+
+// SetCompression enables or disables the compression of the messages on the
+// connections of this router. It should be called before the router is used.
+func (r *Router) SetCompression(enable bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.compression = enable
+}
+
+// compressionEnabled returns true if this router compresses messages.
+func (r *Router) compressionEnabled() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.compression
+}
+
+// capabilities returns what this router supports.
+func (r *Router) capabilities(reply bool) *Capabilities {
+	caps := &Capabilities{Reply: reply}
+	if r.compressionEnabled() {
+		caps.Compression = []string{CompressionFlate}
+	}
+	return caps
+}
+
+// announceCapabilities sends our capabilities on a freshly dialed connection.
+func (r *Router) announceCapabilities(c Conn) error {
+	if _, ok := c.(compressor); !ok || !r.compressionEnabled() {
+		return nil
+	}
+	return c.Send(r.capabilities(false))
+}
+
+// handleCapabilities enables the features both sides support on c and
+// answers if the remote party dialed c.
+func (r *Router) handleCapabilities(c Conn, caps *Capabilities) {
+	comp, ok := c.(compressor)
+	if !ok || !r.compressionEnabled() {
+		return
+	}
+	for _, name := range caps.Compression {
+		if name == CompressionFlate {
+			comp.setCompression(true)
+		}
+	}
+	if !caps.Reply {
+		if err := c.Send(r.capabilities(true)); err != nil {
+			log.Lvl3(r.address, "couldn't answer capabilities:", err)
+		}
+	}
+}
+
+// setCompression enables or disables the compression of sent messages.
+func (c *TCPConn) setCompression(enable bool) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	c.compress = enable
+}
+
+// TxRaw returns how many bytes this connection has written before
+// compression.
+func (c *TCPConn) TxRaw() uint64 {
+	return c.raw.Tx()
+}
+
+// RxRaw returns how many bytes this connection has read after
+// decompression.
+func (c *TCPConn) RxRaw() uint64 {
+	return c.raw.Rx()
+}
+
+// rawCounter is implemented by the connections that count the bytes
+// before compression separately.
+type rawCounter interface {
+	TxRaw() uint64
+	RxRaw() uint64
+}
+
+// txRaw returns the bytes written by c before compression.
+func txRaw(c Conn) uint64 {
+	if rc, ok := c.(rawCounter); ok {
+		return rc.TxRaw()
+	}
+	return c.Tx()
+}
+
+// rxRaw returns the bytes read by c after decompression.
+func rxRaw(c Conn) uint64 {
+	if rc, ok := c.(rawCounter); ok {
+		return rc.RxRaw()
+	}
+	return c.Rx()
+}
+
+// TxRaw returns the Tx for all connections managed by this router before
+// compression. It is equal to Tx if no message is compressed.
+func (r *Router) TxRaw() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	var tx uint64
+	for _, arr := range r.connections {
+		for _, c := range arr {
+			tx += txRaw(c)
+		}
+	}
+	tx += r.rawTraffic.Tx()
+	return tx
+}
+
+// RxRaw returns the Rx for all connections managed by this router after
+// decompression. It is equal to Rx if no message is compressed.
+func (r *Router) RxRaw() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	var rx uint64
+	for _, arr := range r.connections {
+		for _, c := range arr {
+			rx += rxRaw(c)
+		}
+	}
+	rx += r.rawTraffic.Rx()
+	return rx
+}
+
+// deflate compresses b.
+func deflate(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate decompresses b. It fails if the result is bigger than
+// MaxPacketSize.
+func inflate(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxPacketSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if Size(len(out)) > MaxPacketSize {
+		return nil, errors.New("Decompressed packet too big")
+	}
+	return out, nil
+}
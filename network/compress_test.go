@@ -0,0 +1,84 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bigMsgProc struct {
+	relay chan *BigMsg
+}
+
+func (p *bigMsgProc) Process(env *Envelope) {
+	p.relay <- env.Msg.(*BigMsg)
+}
+
+func TestRouterCompression(t *testing.T) {
+	router1, err := NewTestRouterTCP(2040)
+	require.Nil(t, err)
+	router2, err := NewTestRouterTCP(2041)
+	require.Nil(t, err)
+	router1.SetCompression(true)
+	router2.SetCompression(true)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+
+	proc := &bigMsgProc{make(chan *BigMsg)}
+	bigMsgType := MessageType(&BigMsg{})
+	router1.RegisterProcessor(proc, bigMsgType)
+	router2.RegisterProcessor(proc, bigMsgType)
+	msg := &BigMsg{Array: bytes.Repeat([]byte("RandHound"), 10000)}
+
+	// Wait for the negotiation to finish on both sides
+	require.Nil(t, sendrcvProc(router1, router2))
+	require.Nil(t, sendrcvProc(router2, router1))
+	time.Sleep(100 * time.Millisecond)
+
+	require.Nil(t, router1.Send(router2.ServerIdentity, msg))
+	require.Equal(t, msg.Array, (<-proc.relay).Array)
+	require.Nil(t, router2.Send(router1.ServerIdentity, msg))
+	require.Equal(t, msg.Array, (<-proc.relay).Array)
+
+	require.True(t, router1.Tx() < router1.TxRaw()/10)
+	require.True(t, router2.Rx() < router2.RxRaw()/10)
+	require.Equal(t, router1.TxRaw(), router2.RxRaw())
+	require.Equal(t, router1.Tx(), router2.Rx())
+}
+
+// A router without compression still talks to one with compression.
+func TestRouterCompressionInterop(t *testing.T) {
+	router1, err := NewTestRouterTCP(2042)
+	require.Nil(t, err)
+	router2, err := NewTestRouterTCP(2043)
+	require.Nil(t, err)
+	router1.SetCompression(true)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+
+	proc := &bigMsgProc{make(chan *BigMsg)}
+	bigMsgType := MessageType(&BigMsg{})
+	router1.RegisterProcessor(proc, bigMsgType)
+	router2.RegisterProcessor(proc, bigMsgType)
+	msg := &BigMsg{Array: bytes.Repeat([]byte("RandHound"), 10000)}
+
+	require.Nil(t, router1.Send(router2.ServerIdentity, msg))
+	require.Equal(t, msg.Array, (<-proc.relay).Array)
+	require.Nil(t, router2.Send(router1.ServerIdentity, msg))
+	require.Equal(t, msg.Array, (<-proc.relay).Array)
+	require.Equal(t, router1.Tx(), router1.TxRaw())
+	require.Equal(t, router2.Tx(), router2.TxRaw())
+}
+
+func TestInflateLimit(t *testing.T) {
+	z, err := deflate(make([]byte, MaxPacketSize+1))
+	require.Nil(t, err)
+	_, err = inflate(z)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// HappyEyeballsDelay is how long a Host waits for a connection attempt to one
+// address of a ServerIdentity before it tries the next address in parallel.
+var HappyEyeballsDelay = 250 * time.Millisecond
+
+// dialAny connects to the given addresses in order, starting the next
+// attempt if the previous one failed or didn't succeed within
+// HappyEyeballsDelay. It returns the first connection that succeeds and
+// closes the others.
+func dialAny(addrs []Address, dial func(Address) (Conn, error)) (Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("No address to connect to")
+	}
+	if len(addrs) == 1 {
+		return dial(addrs[0])
+	}
+
+	type result struct {
+		c   Conn
+		err error
+	}
+	results := make(chan result, len(addrs))
+	var next, pending int
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			c, err := dial(addr)
+			results <- result{c, err}
+		}()
+	}
+
+	var errs []string
+	start()
+	for pending > 0 {
+		var delay <-chan time.Time
+		if next < len(addrs) {
+			delay = time.After(HappyEyeballsDelay)
+		}
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close the connections that succeed too late
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.err == nil {
+							late.c.Close()
+						}
+					}
+				}(pending)
+				return res.c, nil
+			}
+			errs = append(errs, res.err.Error())
+			if next < len(addrs) {
+				start()
+			}
+		case <-delay:
+			start()
+		}
+	}
+	return nil, errors.New("Couldn't connect to any address: " + strings.Join(errs, "; "))
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPHostAltAddresses(t *testing.T) {
+	router1, err := NewTestRouterTCP(2050)
+	require.Nil(t, err)
+	router2, err := NewTestRouterTCP(2051)
+	require.Nil(t, err)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+
+	// The first address doesn't answer, the second one does
+	si := *router2.ServerIdentity
+	si.Address = NewTCPAddress("127.0.0.1:2052")
+	si.AltAddresses = []Address{router2.ServerIdentity.Address}
+	c, err := router1.host.Connect(&si)
+	require.Nil(t, err)
+	require.Equal(t, router2.ServerIdentity.Address, c.Remote())
+	c.Close()
+
+	si.AltAddresses = []Address{NewLocalAddress("127.0.0.1:2051")}
+	_, err = router1.host.Connect(&si)
+	require.NotNil(t, err)
+}
+
+// closeConn is a Conn that can only be closed.
+type closeConn struct {
+	Conn
+}
+
+func (c *closeConn) Close() error {
+	return nil
+}
+
+func TestDialAny(t *testing.T) {
+	addrs := []Address{NewTCPAddress("127.0.0.1:1"), NewTCPAddress("127.0.0.1:2"),
+		NewTCPAddress("127.0.0.1:3")}
+	conns := make(chan Address, len(addrs))
+	dial := func(addr Address) (Conn, error) {
+		switch addr {
+		case addrs[0]:
+			// Hangs longer than the delay
+			time.Sleep(4 * HappyEyeballsDelay)
+		case addrs[1]:
+			return nil, errors.New("refused")
+		}
+		conns <- addr
+		return &closeConn{}, nil
+	}
+
+	start := time.Now()
+	c, err := dialAny(addrs, dial)
+	require.Nil(t, err)
+	require.NotNil(t, c)
+	require.Equal(t, addrs[2], <-conns)
+	require.True(t, time.Now().Sub(start) < 3*HappyEyeballsDelay)
+
+	_, err = dialAny(addrs[1:2], dial)
+	require.NotNil(t, err)
+	_, err = dialAny(nil, dial)
+	require.NotNil(t, err)
+}
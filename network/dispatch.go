@@ -2,6 +2,7 @@ package network
 
 import (
 	"errors"
+	"hash/fnv"
 	"sync"
 )
 
@@ -11,10 +12,12 @@ import (
 // type must register itself to the dispatcher using `RegisterProcessor()`.
 // The network layer calls `Dispatch()` each time it receives a message, so
 // the dispatcher is able to dispatch correctly to the corresponding Processor.
-// Two Dispatchers are available:
+// Three Dispatchers are available:
 //   * BlockingDispatcher - waits for the return of the Processor before taking
 //     another message
 //   * RoutineDispatcher - starts every Processor in a go-routine
+//   * PoolDispatcher - processes the messages of different senders in
+//     parallel on a bounded number of go-routines
 type Dispatcher interface {
 	// RegisterProcessor is called by a Processor so it can receive all messages
 	// of type msgType. If given multiple msgType, the same processor will be
@@ -117,6 +120,104 @@ func (d *RoutineDispatcher) Dispatch(packet *Envelope) error {
 	return nil
 }
 
+// PoolDispatcher dispatches messages to the Processors on a fixed number of
+// workers. The messages with the same key - by default the same sender - are
+// always handled by the same worker and thus processed in the order they
+// arrived, while messages with different keys can be processed in parallel.
+// Every worker has a queue of bounded size: once it is full, Dispatch blocks
+// until the worker catches up, which slows down the reading of the
+// connection that calls it.
+type PoolDispatcher struct {
+	*BlockingDispatcher
+	workers []*poolWorker
+	key     func(*Envelope) []byte
+}
+
+// poolWorker processes the messages of one queue in order.
+type poolWorker struct {
+	queue chan poolJob
+	// pending counts the messages for this worker not yet processed. The
+	// go-routine of the worker only runs while pending > 0.
+	pending int
+}
+
+type poolJob struct {
+	p   Processor
+	env *Envelope
+}
+
+// NewPoolDispatcher returns a PoolDispatcher with the given number of
+// workers, each having a queue of queueSize messages.
+func NewPoolDispatcher(workers, queueSize int) *PoolDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &PoolDispatcher{
+		BlockingDispatcher: NewBlockingDispatcher(),
+		workers:            make([]*poolWorker, workers),
+		key:                SenderKey,
+	}
+	for i := range d.workers {
+		d.workers[i] = &poolWorker{queue: make(chan poolJob, queueSize)}
+	}
+	return d
+}
+
+// SenderKey returns the ID of the sender of env. It is the default key of
+// the PoolDispatcher.
+func SenderKey(env *Envelope) []byte {
+	if env.ServerIdentity == nil {
+		return nil
+	}
+	return env.ServerIdentity.ID[:]
+}
+
+// SetKey sets the function returning the key of a message. Messages with the
+// same key are processed in order. It must be called before the first
+// message is dispatched.
+func (d *PoolDispatcher) SetKey(key func(*Envelope) []byte) {
+	d.Lock()
+	defer d.Unlock()
+	d.key = key
+}
+
+// Dispatch implements the Dispatcher interface. It queues the packet for the
+// worker of its key and only blocks if that queue is full.
+func (d *PoolDispatcher) Dispatch(packet *Envelope) error {
+	d.Lock()
+	p := d.procs[packet.MsgType]
+	if p == nil {
+		d.Unlock()
+		return errors.New("No Processor attached to this message type " + packet.MsgType.String())
+	}
+	h := fnv.New32a()
+	h.Write(d.key(packet))
+	w := d.workers[int(h.Sum32()%uint32(len(d.workers)))]
+	w.pending++
+	if w.pending == 1 {
+		go d.work(w)
+	}
+	d.Unlock()
+
+	w.queue <- poolJob{p, packet}
+	return nil
+}
+
+// work processes the messages of w until there are none left.
+func (d *PoolDispatcher) work(w *poolWorker) {
+	for {
+		job := <-w.queue
+		job.p.Process(job.env)
+		d.Lock()
+		w.pending--
+		if w.pending == 0 {
+			d.Unlock()
+			return
+		}
+		d.Unlock()
+	}
+}
+
 type defaultProcessor struct {
 	fn func(*Envelope)
 }
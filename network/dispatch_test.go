@@ -1,6 +1,7 @@
 package network
 
 import (
+	"hash/fnv"
 	"testing"
 	"time"
 
@@ -106,3 +107,88 @@ func TestDefaultProcessor(t *testing.T) {
 		t.Error("no ack received...")
 	}
 }
+
+type blockingProcessor struct {
+	envChan chan int
+	release chan bool
+}
+
+func (bp *blockingProcessor) Process(env *Envelope) {
+	if env.ServerIdentity.Address == "tcp://127.0.0.1:2000" {
+		<-bp.release
+	}
+	bp.envChan <- env.Msg.(basicMessage).Value
+}
+
+func TestPoolDispatcher(t *testing.T) {
+	dispatcher := NewPoolDispatcher(4, 10)
+	processor := &blockingProcessor{make(chan int, 20), make(chan bool)}
+	err := dispatcher.Dispatch(&Envelope{
+		Msg:     basicMessage{10},
+		MsgType: basicMessageType})
+	assert.NotNil(t, err)
+	dispatcher.RegisterProcessor(processor, basicMessageType)
+
+	// Find a second sender that is not handled by the worker of the first
+	slow := &ServerIdentity{Address: "tcp://127.0.0.1:2000"}
+	fast := &ServerIdentity{Address: "tcp://127.0.0.1:2001"}
+	key := func(si *ServerIdentity) uint32 {
+		h := fnv.New32a()
+		h.Write(SenderKey(&Envelope{ServerIdentity: si}))
+		return h.Sum32() % 4
+	}
+	for i := 0; key(fast) == key(slow); i++ {
+		fast = &ServerIdentity{Address: "tcp://127.0.0.1:2001"}
+		fast.ID[0] = byte(i)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, dispatcher.Dispatch(&Envelope{ServerIdentity: slow,
+			Msg: basicMessage{i}, MsgType: basicMessageType}))
+	}
+	// The slow sender doesn't hold back the fast one
+	for i := 10; i < 13; i++ {
+		assert.Nil(t, dispatcher.Dispatch(&Envelope{ServerIdentity: fast,
+			Msg: basicMessage{i}, MsgType: basicMessageType}))
+	}
+	for i := 10; i < 13; i++ {
+		select {
+		case v := <-processor.envChan:
+			assert.Equal(t, i, v)
+		case <-time.After(time.Second):
+			t.Fatal("Fast sender has been blocked")
+		}
+	}
+	// And the messages of the slow one stay in order
+	for i := 0; i < 3; i++ {
+		processor.release <- true
+		assert.Equal(t, i, <-processor.envChan)
+	}
+}
+
+func TestPoolDispatcherBackpressure(t *testing.T) {
+	dispatcher := NewPoolDispatcher(1, 1)
+	processor := &blockingProcessor{make(chan int, 20), make(chan bool)}
+	dispatcher.RegisterProcessor(processor, basicMessageType)
+	si := &ServerIdentity{Address: "tcp://127.0.0.1:2000"}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 3; i++ {
+			dispatcher.Dispatch(&Envelope{ServerIdentity: si,
+				Msg: basicMessage{i}, MsgType: basicMessageType})
+		}
+		done <- true
+	}()
+	// One message is processed, one waits in the queue, the third blocks
+	select {
+	case <-done:
+		t.Fatal("Dispatch should block on a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+	for i := 0; i < 3; i++ {
+		processor.release <- true
+		assert.Equal(t, i, <-processor.envChan)
+	}
+	<-done
+}
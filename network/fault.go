@@ -0,0 +1,232 @@
+package network
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LinkFaults describes how the messages sent over a link from one local
+// address to another are perturbed. The zero value delivers every message
+// right away.
+type LinkFaults struct {
+	// Latency delays every message.
+	Latency time.Duration
+	// Jitter adds a random delay between 0 and Jitter to every message.
+	Jitter time.Duration
+	// Drop is the probability that a message is lost.
+	Drop float64
+	// Bandwidth limits the link to that many bytes per second. 0 means
+	// unlimited.
+	Bandwidth int
+	// Reorder is the probability that a message is delayed a bit more and
+	// can be overtaken by later messages. Other messages keep their order.
+	Reorder float64
+}
+
+// link is the direction from one address to another.
+type link struct {
+	from Address
+	to   Address
+}
+
+// pendingMsg is a message waiting to be delivered on a link.
+type pendingMsg struct {
+	to  endpoint
+	msg []byte
+	at  time.Time
+}
+
+// linkState holds the messages in flight on a link.
+type linkState struct {
+	// busy is the time until which the bandwidth of the link is used.
+	busy time.Time
+	// last is the delivery time of the last message that keeps its order.
+	last time.Time
+	// queue holds the messages in order of delivery.
+	queue []pendingMsg
+	// running is true while a go routine delivers the queue.
+	running bool
+	// rand takes the random decisions of the link.
+	rand *rand.Rand
+}
+
+// FaultInjector perturbs the delivery of messages between the connections
+// of a LocalManager, see LocalManager.SetFaults. It is driven from tests to
+// simulate slow or lossy links and network partitions. The random decisions
+// of every link are taken from a source derived from the given seed and the
+// addresses of the link, so a test sending the same messages in the same
+// order over a link sees the same faults, whatever happens on other links.
+type FaultInjector struct {
+	seed     int64
+	defaults LinkFaults
+	links    map[link]LinkFaults
+	state    map[link]*linkState
+	// partition maps an address to its group. Unlisted addresses are in
+	// group 0.
+	partition map[Address]int
+	sync.Mutex
+}
+
+// NewFaultInjector returns a FaultInjector that doesn't perturb anything
+// until it is configured.
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		seed:      seed,
+		links:     make(map[link]LinkFaults),
+		state:     make(map[link]*linkState),
+		partition: make(map[Address]int),
+	}
+}
+
+// SetDefault sets the faults of all links that have no faults of their own.
+func (f *FaultInjector) SetDefault(faults LinkFaults) {
+	f.Lock()
+	defer f.Unlock()
+	f.defaults = faults
+}
+
+// SetLink sets the faults of the messages sent from one address to another.
+// The other direction is not changed.
+func (f *FaultInjector) SetLink(from, to Address, faults LinkFaults) {
+	f.Lock()
+	defer f.Unlock()
+	f.links[link{from, to}] = faults
+}
+
+// ResetLink makes the link from one address to another use the default
+// faults again.
+func (f *FaultInjector) ResetLink(from, to Address) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.links, link{from, to})
+}
+
+// Partition splits the network into the given groups and all the addresses
+// that are not listed. Messages between different groups are dropped and no
+// new connections can be made between them. It replaces an earlier
+// partition.
+func (f *FaultInjector) Partition(groups ...[]Address) {
+	f.Lock()
+	defer f.Unlock()
+	f.partition = make(map[Address]int)
+	for i, g := range groups {
+		for _, addr := range g {
+			f.partition[addr] = i + 1
+		}
+	}
+}
+
+// Heal removes the partition.
+func (f *FaultInjector) Heal() {
+	f.Partition()
+}
+
+// Partitioned returns true if a and b are in different groups of the
+// partition.
+func (f *FaultInjector) Partitioned(a, b Address) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.partition[a] != f.partition[b]
+}
+
+// send hands msg to the delivery of the link. It is called by the
+// LocalManager for every message.
+func (f *FaultInjector) send(lm *LocalManager, from Address, to endpoint, msg []byte) {
+	l := link{from, to.addr}
+	f.Lock()
+	if f.partition[l.from] != f.partition[l.to] {
+		f.Unlock()
+		return
+	}
+	faults, ok := f.links[l]
+	if !ok {
+		faults = f.defaults
+	}
+	if faults == (LinkFaults{}) {
+		f.Unlock()
+		lm.deliver(to, msg)
+		return
+	}
+	st, ok := f.state[l]
+	if !ok {
+		st = &linkState{rand: rand.New(rand.NewSource(f.linkSeed(l)))}
+		f.state[l] = st
+	}
+	if faults.Drop > 0 && st.rand.Float64() < faults.Drop {
+		f.Unlock()
+		return
+	}
+
+	now := time.Now()
+	at := now
+	if faults.Bandwidth > 0 {
+		if st.busy.After(at) {
+			at = st.busy
+		}
+		at = at.Add(time.Duration(len(msg)) * time.Second / time.Duration(faults.Bandwidth))
+		st.busy = at
+	}
+	at = at.Add(faults.Latency)
+	if faults.Jitter > 0 {
+		at = at.Add(time.Duration(st.rand.Int63n(int64(faults.Jitter))))
+	}
+
+	if faults.Reorder > 0 && st.rand.Float64() < faults.Reorder {
+		extra := faults.Latency + faults.Jitter + time.Millisecond
+		at = at.Add(time.Duration(st.rand.Int63n(int64(extra))))
+		f.Unlock()
+		time.AfterFunc(at.Sub(now), func() {
+			f.deliver(lm, l, to, msg)
+		})
+		return
+	}
+	if at.Before(st.last) {
+		at = st.last
+	}
+	st.last = at
+	st.queue = append(st.queue, pendingMsg{to, msg, at})
+	if !st.running {
+		st.running = true
+		go f.deliverLink(lm, l, st)
+	}
+	f.Unlock()
+}
+
+// deliverLink delivers the messages of a link in order, each at its time.
+func (f *FaultInjector) deliverLink(lm *LocalManager, l link, st *linkState) {
+	for {
+		f.Lock()
+		if len(st.queue) == 0 {
+			st.running = false
+			f.Unlock()
+			return
+		}
+		p := st.queue[0]
+		st.queue = st.queue[1:]
+		f.Unlock()
+
+		time.Sleep(p.at.Sub(time.Now()))
+		f.deliver(lm, l, p.to, p.msg)
+	}
+}
+
+// deliver hands a delayed message to the LocalManager, unless the link has
+// been partitioned since it was sent. The connection might be closed in the
+// meantime, too.
+func (f *FaultInjector) deliver(lm *LocalManager, l link, to endpoint, msg []byte) {
+	if f.Partitioned(l.from, l.to) {
+		return
+	}
+	lm.deliver(to, msg)
+}
+
+// linkSeed derives the seed of the random decisions of a link.
+func (f *FaultInjector) linkSeed(l link) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(l.from))
+	h.Write([]byte{0})
+	h.Write([]byte(l.to))
+	return f.seed ^ int64(h.Sum64())
+}
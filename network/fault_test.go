@@ -0,0 +1,174 @@
+package network
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bufferedMsgProc keeps the received SimpleMessages.
+type bufferedMsgProc struct {
+	relay chan int
+}
+
+func (p *bufferedMsgProc) Process(env *Envelope) {
+	p.relay <- env.Msg.(*SimpleMessage).I
+}
+
+// newFaultyRouters returns two started local routers talking through f.
+func newFaultyRouters(t *testing.T, f *FaultInjector) (*Router, *Router, *bufferedMsgProc) {
+	lm := NewLocalManager()
+	lm.SetFaults(f)
+	var routers []*Router
+	for i := 0; i < 2; i++ {
+		addr := NewLocalAddress("127.0.0.1:" + strconv.Itoa(2050+i))
+		r, err := NewLocalRouterWithManager(lm, NewTestServerIdentity(addr))
+		require.Nil(t, err)
+		go r.Start()
+		routers = append(routers, r)
+	}
+	proc := &bufferedMsgProc{make(chan int, 100)}
+	routers[1].RegisterProcessor(proc, SimpleMessageType)
+	return routers[0], routers[1], proc
+}
+
+// receiveAll returns the messages arriving until nothing comes for wait.
+func receiveAll(proc *bufferedMsgProc, wait time.Duration) []int {
+	var got []int
+	for {
+		select {
+		case i := <-proc.relay:
+			got = append(got, i)
+		case <-time.After(wait):
+			return got
+		}
+	}
+}
+
+func TestFaultLatency(t *testing.T) {
+	f := NewFaultInjector(1)
+	r1, r2, proc := newFaultyRouters(t, f)
+	defer r1.Stop()
+	defer r2.Stop()
+
+	f.SetLink(r1.ServerIdentity.Address, r2.ServerIdentity.Address,
+		LinkFaults{Latency: 100 * time.Millisecond})
+	start := time.Now()
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{1}))
+	require.Equal(t, 1, <-proc.relay)
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestFaultBandwidth(t *testing.T) {
+	f := NewFaultInjector(1)
+	r1, r2, _ := newFaultyRouters(t, f)
+	defer r1.Stop()
+	defer r2.Stop()
+	proc := &bigMsgProc{make(chan *BigMsg, 5)}
+	r2.RegisterProcessor(proc, MessageType(&BigMsg{}))
+
+	f.SetDefault(LinkFaults{Bandwidth: 20000})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.Nil(t, r1.Send(r2.ServerIdentity, &BigMsg{Array: make([]byte, 2000)}))
+	}
+	for i := 0; i < 5; i++ {
+		<-proc.relay
+	}
+	require.True(t, time.Since(start) >= 500*time.Millisecond)
+}
+
+func TestFaultOrder(t *testing.T) {
+	f := NewFaultInjector(1)
+	r1, r2, proc := newFaultyRouters(t, f)
+	defer r1.Stop()
+	defer r2.Stop()
+
+	// Jitter alone keeps the order of the messages
+	f.SetDefault(LinkFaults{Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{i}))
+	}
+	got := receiveAll(proc, 200*time.Millisecond)
+	require.Equal(t, 20, len(got))
+	for i := range got {
+		require.Equal(t, i, got[i])
+	}
+
+	f.SetDefault(LinkFaults{Latency: 10 * time.Millisecond, Reorder: 0.5})
+	for i := 0; i < 20; i++ {
+		require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{i}))
+	}
+	got = receiveAll(proc, 200*time.Millisecond)
+	require.Equal(t, 20, len(got))
+	var reordered bool
+	for i := range got {
+		if got[i] != i {
+			reordered = true
+		}
+	}
+	require.True(t, reordered)
+}
+
+func TestFaultDropSeeded(t *testing.T) {
+	run := func(seed int64, back bool) []int {
+		f := NewFaultInjector(seed)
+		r1, r2, proc := newFaultyRouters(t, f)
+		defer r1.Stop()
+		defer r2.Stop()
+		r1.RegisterProcessor(&bufferedMsgProc{make(chan int, 100)}, SimpleMessageType)
+		// Connect before dropping anything
+		require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{-1}))
+		require.Equal(t, -1, <-proc.relay)
+
+		f.SetDefault(LinkFaults{Drop: 0.5})
+		for i := 0; i < 50; i++ {
+			require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{i}))
+			if back {
+				require.Nil(t, r2.Send(r1.ServerIdentity, &SimpleMessage{i}))
+			}
+		}
+		return receiveAll(proc, 200*time.Millisecond)
+	}
+	got := run(42, false)
+	require.True(t, len(got) > 0)
+	require.True(t, len(got) < 50)
+	require.Equal(t, got, run(42, false))
+	// Messages on other links don't change the faults of a link
+	require.Equal(t, got, run(42, true))
+}
+
+func TestFaultPartition(t *testing.T) {
+	f := NewFaultInjector(1)
+	r1, r2, proc := newFaultyRouters(t, f)
+	defer r1.Stop()
+	defer r2.Stop()
+	a1, a2 := r1.ServerIdentity.Address, r2.ServerIdentity.Address
+
+	f.Partition([]Address{a1}, []Address{a2})
+	require.True(t, f.Partitioned(a1, a2))
+	require.NotNil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{1}))
+
+	f.Heal()
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{2}))
+	require.Equal(t, 2, <-proc.relay)
+
+	// Established connections lose their messages
+	f.Partition([]Address{a1})
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{3}))
+	require.Equal(t, 0, len(receiveAll(proc, 100*time.Millisecond)))
+
+	f.Heal()
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{4}))
+	require.Equal(t, 4, <-proc.relay)
+
+	// Messages in flight are lost if the partition starts before they arrive
+	f.SetDefault(LinkFaults{Latency: 50 * time.Millisecond})
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{5}))
+	f.SetDefault(LinkFaults{Latency: 50 * time.Millisecond, Reorder: 1})
+	require.Nil(t, r1.Send(r2.ServerIdentity, &SimpleMessage{6}))
+	f.Partition([]Address{a1})
+	require.Equal(t, 0, len(receiveAll(proc, 200*time.Millisecond)))
+}
@@ -38,6 +38,9 @@ type LocalManager struct {
 
 	// connection-counter for giving unique IDs to each connection.
 	counter uint64
+
+	// faults perturbs the delivery of the messages if it is set.
+	faults *FaultInjector
 }
 
 // NewLocalManager returns a fresh new manager that can be used by LocalConn,
@@ -93,6 +96,15 @@ func (lm *LocalManager) unsetListening(addr Address) {
 	delete(lm.listening, addr)
 }
 
+// SetFaults makes the messages between the connections of this manager go
+// through the given FaultInjector. A nil FaultInjector delivers all messages
+// right away.
+func (lm *LocalManager) SetFaults(f *FaultInjector) {
+	lm.Lock()
+	defer lm.Unlock()
+	lm.faults = f
+}
+
 // connect checks if the remote address is listening. Then it creates
 // the two connections, and launches the listening function in a go routine.
 // It returns the outgoing connection, or nil followed by an error, if any.
@@ -104,6 +116,9 @@ func (lm *LocalManager) connect(local, remote Address) (*LocalConn, error) {
 	if !ok {
 		return nil, fmt.Errorf("%s can't connect to %s: it's not listening", local, remote)
 	}
+	if lm.faults != nil && lm.faults.Partitioned(local, remote) {
+		return nil, fmt.Errorf("%s can't connect to %s: partitioned", local, remote)
+	}
 
 	outEndpoint := endpoint{local, lm.counter}
 	lm.counter++
@@ -122,10 +137,29 @@ func (lm *LocalManager) connect(local, remote Address) (*LocalConn, error) {
 	return outgoing, nil
 }
 
-// send gets the connection denoted by this endpoint and calls queueMsg
+// send passes the packet from the connection at the local address to the
+// connection denoted by the remote endpoint, through the FaultInjector if
+// there is one.
+// It returns ErrClosed if it does not find the connection.
+func (lm *LocalManager) send(local Address, e endpoint, msg []byte) error {
+	lm.Lock()
+	_, ok := lm.conns[e]
+	faults := lm.faults
+	lm.Unlock()
+	if !ok {
+		return ErrClosed
+	}
+	if faults != nil {
+		faults.send(lm, local, e, msg)
+		return nil
+	}
+	return lm.deliver(e, msg)
+}
+
+// deliver gets the connection denoted by this endpoint and calls queueMsg
 // with the packet as argument to it.
 // It returns ErrClosed if it does not find the connection.
-func (lm *LocalManager) send(e endpoint, msg []byte) error {
+func (lm *LocalManager) deliver(e endpoint, msg []byte) error {
 	lm.Lock()
 	defer lm.Unlock()
 	q, ok := lm.conns[e]
@@ -252,7 +286,7 @@ func (lc *LocalConn) Send(msg Message) error {
 		return err
 	}
 	lc.updateTx(uint64(len(buff)))
-	return lc.manager.send(lc.remote, buff)
+	return lc.manager.send(lc.local.addr, lc.remote, buff)
 }
 
 // Receive takes a context (that is not used) and waits for a packet to
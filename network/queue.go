@@ -0,0 +1,236 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"mobilehound/v0-log"
+)
+
+// ErrQueueFull is returned by Router.Send if the send queue of the
+// destination is full and the DropNewest policy is used.
+var ErrQueueFull = errors.New("Send queue full")
+
+// DropPolicy decides which message is dropped when a send queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest refuses to queue the message that is sent.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the message that is queued the longest.
+	DropOldest
+)
+
+// QueueConfig configures the per-peer send queues of a Router. With queueing
+// enabled, Router.Send only queues the message and returns. A go routine per
+// peer writes the queued messages and reconnects with an exponential backoff
+// if the connection fails.
+type QueueConfig struct {
+	// Size is the maximum number of messages queued per peer. A size of 0
+	// disables queueing: Send writes the message synchronously.
+	Size int
+	// Drop tells which message to drop if a queue is full.
+	Drop DropPolicy
+	// Retransmit keeps the messages that were queued but not written when a
+	// connection fails and sends them after reconnecting. Without it, they
+	// are dropped.
+	Retransmit bool
+	// MinBackoff is the time to wait before the first reconnection, it is
+	// doubled after every failure up to MaxBackoff. They default to
+	// DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultMinBackoff is the default of QueueConfig.MinBackoff.
+const DefaultMinBackoff = 100 * time.Millisecond
+
+// DefaultMaxBackoff is the default of QueueConfig.MaxBackoff.
+const DefaultMaxBackoff = 10 * time.Second
+
+// sendQueue holds the messages waiting to be sent to one peer.
+type sendQueue struct {
+	si   *ServerIdentity
+	msgs []Message
+	// running is true while a go routine drains the queue.
+	running bool
+	// backoff is the time to wait after the next failure.
+	backoff time.Duration
+	// retry is the earliest time for the next connection attempt.
+	retry time.Time
+}
+
+// routerQueues holds the send queues of a Router.
+type routerQueues struct {
+	config  QueueConfig
+	queues  map[ServerIdentityID]*sendQueue
+	dropped uint64
+	// quit is closed when the router stops.
+	quit chan bool
+	wg   sync.WaitGroup
+	sync.Mutex
+}
+
+func newRouterQueues() *routerQueues {
+	return &routerQueues{
+		queues: make(map[ServerIdentityID]*sendQueue),
+		quit:   make(chan bool),
+	}
+}
+
+// SetQueueConfig enables or disables the per-peer send queues. It should be
+// called before the router is used.
+func (r *Router) SetQueueConfig(config QueueConfig) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = DefaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	r.queues.Lock()
+	defer r.queues.Unlock()
+	r.queues.config = config
+}
+
+// QueueDepth returns the number of messages waiting in the send queues.
+func (r *Router) QueueDepth() int {
+	r.queues.Lock()
+	defer r.queues.Unlock()
+	var depth int
+	for _, q := range r.queues.queues {
+		depth += len(q.msgs)
+	}
+	return depth
+}
+
+// Dropped returns the number of messages dropped from the send queues.
+func (r *Router) Dropped() uint64 {
+	r.queues.Lock()
+	defer r.queues.Unlock()
+	return r.queues.dropped
+}
+
+// queueing returns true if Send has to queue the messages.
+func (r *Router) queueing() bool {
+	r.queues.Lock()
+	defer r.queues.Unlock()
+	return r.queues.config.Size > 0
+}
+
+// enqueue adds msg to the queue of si and makes sure the queue is drained.
+func (r *Router) enqueue(si *ServerIdentity, msg Message) error {
+	rq := r.queues
+	rq.Lock()
+	defer rq.Unlock()
+	select {
+	case <-rq.quit:
+		return ErrClosed
+	default:
+	}
+	q, ok := rq.queues[si.ID]
+	if !ok {
+		q = &sendQueue{si: si, backoff: rq.config.MinBackoff}
+		rq.queues[si.ID] = q
+	}
+	if len(q.msgs) >= rq.config.Size {
+		rq.dropped++
+		if rq.config.Drop == DropNewest {
+			return ErrQueueFull
+		}
+		q.msgs = q.msgs[1:]
+	}
+	q.msgs = append(q.msgs, msg)
+	if !q.running {
+		q.running = true
+		rq.wg.Add(1)
+		go r.drain(q)
+	}
+	return nil
+}
+
+// drain writes the messages of q until it is empty or the router stops.
+func (r *Router) drain(q *sendQueue) {
+	rq := r.queues
+	defer rq.wg.Done()
+	for {
+		rq.Lock()
+		if len(q.msgs) == 0 {
+			q.running = false
+			rq.Unlock()
+			return
+		}
+		wait := q.retry.Sub(time.Now())
+		rq.Unlock()
+		if wait > 0 {
+			select {
+			case <-rq.quit:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		rq.Lock()
+		msg := q.msgs[0]
+		q.msgs = q.msgs[1:]
+		rq.Unlock()
+
+		err := r.sendNow(q.si, msg)
+
+		rq.Lock()
+		if err == nil {
+			q.backoff = rq.config.MinBackoff
+		} else {
+			log.Lvl2(r.address, "couldn't send to", q.si.Address, ":", err, "- retrying in", q.backoff)
+			if rq.config.Retransmit && len(q.msgs) < rq.config.Size {
+				q.msgs = append([]Message{msg}, q.msgs...)
+			} else if rq.config.Retransmit {
+				rq.dropped++
+			} else {
+				rq.dropped += uint64(1 + len(q.msgs))
+				q.msgs = nil
+			}
+			q.retry = time.Now().Add(q.backoff)
+			q.backoff *= 2
+			if q.backoff > rq.config.MaxBackoff {
+				q.backoff = rq.config.MaxBackoff
+			}
+		}
+		rq.Unlock()
+		if r.Closed() {
+			return
+		}
+	}
+}
+
+// sendNow writes msg to an existing or a new connection to si. If the
+// connection fails, it is closed so that the next attempt reconnects.
+func (r *Router) sendNow(si *ServerIdentity, msg Message) error {
+	c := r.connection(si.ID)
+	if c == nil {
+		var err error
+		if c, err = r.connect(si); err != nil {
+			return err
+		}
+	}
+	if err := c.Send(msg); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// stop makes the draining go routines return and waits for them.
+func (rq *routerQueues) stop() {
+	rq.Lock()
+	select {
+	case <-rq.quit:
+	default:
+		close(rq.quit)
+	}
+	rq.Unlock()
+	rq.wg.Wait()
+}
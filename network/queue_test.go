@@ -0,0 +1,93 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Messages queued while the peer is down are delivered once it is back.
+func TestRouterQueueRetransmit(t *testing.T) {
+	router1, err := NewTestRouterTCP(2030)
+	require.Nil(t, err)
+	router1.SetQueueConfig(QueueConfig{
+		Size:       10,
+		Retransmit: true,
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 100 * time.Millisecond,
+	})
+	router2, err := NewTestRouterTCP(2031)
+	require.Nil(t, err)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+
+	proc := newSimpleMessageProc(t)
+	router2.RegisterProcessor(proc, SimpleMessageType)
+	require.Nil(t, router1.Send(router2.ServerIdentity, &SimpleMessage{1}))
+	require.Equal(t, 1, (<-proc.relay).I)
+	require.Nil(t, router2.Stop())
+
+	// The peer is down, Send only queues
+	for i := 2; i <= 4; i++ {
+		require.Nil(t, router1.Send(router2.ServerIdentity, &SimpleMessage{i}))
+	}
+	time.Sleep(200 * time.Millisecond)
+	require.NotEqual(t, 0, router1.QueueDepth())
+
+	// Same identity on a fresh host
+	h, err := NewTestTCPHost(2031)
+	require.Nil(t, err)
+	router3 := NewRouter(router2.ServerIdentity, h)
+	proc = newSimpleMessageProc(t)
+	router3.RegisterProcessor(proc, SimpleMessageType)
+	go router3.Start()
+	defer router3.Stop()
+	for i := 2; i <= 4; i++ {
+		select {
+		case msg := <-proc.relay:
+			require.Equal(t, i, msg.I)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Didn't receive queued message", i)
+		}
+	}
+	require.Equal(t, 0, router1.QueueDepth())
+	require.Equal(t, uint64(0), router1.Dropped())
+}
+
+func TestRouterQueueDrop(t *testing.T) {
+	router, err := NewTestRouterTCP(2032)
+	require.Nil(t, err)
+	go router.Start()
+	defer router.Stop()
+	down := NewTestServerIdentity(NewTCPAddress("127.0.0.1:2033"))
+
+	// Without retransmission everything is dropped when the send fails
+	router.SetQueueConfig(QueueConfig{Size: 2})
+	require.Nil(t, router.Send(down, &SimpleMessage{1}))
+	for i := 0; i < 100 && router.Dropped() == 0; i++ {
+		time.Sleep(WaitRetry)
+	}
+	require.NotEqual(t, uint64(0), router.Dropped())
+	require.Equal(t, 0, router.QueueDepth())
+
+	// A full queue refuses new messages
+	router.SetQueueConfig(QueueConfig{Size: 1, Retransmit: true, MinBackoff: time.Second})
+	var full bool
+	for i := 0; i < 3; i++ {
+		if err := router.Send(down, &SimpleMessage{i}); err == ErrQueueFull {
+			full = true
+		}
+	}
+	require.True(t, full)
+
+	// Or discards the oldest message
+	router.SetQueueConfig(QueueConfig{Size: 1, Retransmit: true, Drop: DropOldest, MinBackoff: time.Second})
+	dropped := router.Dropped()
+	for i := 0; i < 3; i++ {
+		require.Nil(t, router.Send(down, &SimpleMessage{i}))
+	}
+	require.True(t, router.Dropped() > dropped)
+	require.True(t, router.QueueDepth() <= 1)
+}
@@ -0,0 +1,872 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"mobilehound/crypto"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-log"
+)
+
+// A node that can't accept incoming connections, like a phone behind a
+// carrier NAT, keeps a websocket open to a RelayServer and registers there
+// under its public key. Other nodes reach it with an address of the form
+// relay://relayhost:port/<hex public key>: they open a websocket to the
+// relay, which asks the node over the registered websocket to dial back.
+// The relay then forwards the messages between both websockets.
+//
+// The relay is not trusted with the identities: before any message is sent
+// over a relayed connection, both ends exchange a random challenge and the
+// node behind the relay signs both challenges with the key of its address.
+// The dialing node signs them, too, if it has a key, which is required for
+// its ServerIdentity to be accepted.
+const (
+	relayRegisterPath = "/register/"
+	relayConnectPath  = "/connect/"
+	relayAcceptPath   = "/accept/"
+)
+
+// relayTag is prepended to the challenge a node signs when registering.
+const relayTag = "mobilehound/network/relay/register/v1"
+
+// relayAcceptTag and relayDialTag are prepended to the challenges the node
+// behind the relay and the dialing node sign on a relayed connection.
+const (
+	relayAcceptTag = "mobilehound/network/relay/accept/v1"
+	relayDialTag   = "mobilehound/network/relay/dial/v1"
+)
+
+// relayChallengeSize is the size of the challenges on a relayed connection.
+const relayChallengeSize = 32
+
+// RelayPlaintext makes nodes and relays use plaintext websockets (ws://)
+// instead of websockets over TLS (wss://). The identities are still
+// authenticated end to end, but everybody on the path to the relay can read
+// the messages. It is only meant for tests and relays on a trusted network.
+var RelayPlaintext = false
+
+// RelayTLSConfig is the TLS configuration used to connect to relays. With a
+// nil configuration, the certificate of the relay is checked against the
+// system roots.
+var RelayTLSConfig *tls.Config
+
+// relayAcceptTimeout is how long the relay waits for a node to dial back.
+var relayAcceptTimeout = 10 * time.Second
+
+// relayPingInterval is how often the relay pings the registered nodes. This
+// keeps NAT mappings alive and lets both sides detect a broken websocket.
+var relayPingInterval = 30 * time.Second
+
+// NewRelayAddress returns the Address under which the node with the given
+// public key can be reached through the relay listening on relay, which is a
+// host and a port joined by a colon.
+func NewRelayAddress(relay string, public abstract.Point) (Address, error) {
+	id, err := relayID(public)
+	if err != nil {
+		return "", err
+	}
+	return NewAddress(Relay, relay+"/"+id), nil
+}
+
+// relayID returns the identifier of a node at a relay.
+func relayID(public abstract.Point) (string, error) {
+	buf, err := public.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// relayPublic is the inverse of relayID.
+func relayPublic(id string) (abstract.Point, error) {
+	buf, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, err
+	}
+	public := Suite.Point()
+	if err := public.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// dialRelay opens a websocket to the given path of a relay, retrying in case
+// the relay is just about to start.
+func dialRelay(relay string, path string) (ws *websocket.Conn, err error) {
+	d := &websocket.Dialer{
+		HandshakeTimeout: handshakeTimeout,
+		TLSClientConfig:  RelayTLSConfig,
+	}
+	scheme := "wss://"
+	if RelayPlaintext {
+		scheme = "ws://"
+	}
+	for i := 1; i <= MaxRetryConnect; i++ {
+		ws, _, err = d.Dial(scheme+relay+path, nil)
+		if err == nil {
+			ws.SetReadLimit(int64(MaxPacketSize))
+			return
+		}
+		if i < MaxRetryConnect {
+			time.Sleep(WaitRetry)
+		}
+	}
+	return
+}
+
+// RelayConn implements the Conn interface over a websocket to a relay. Every
+// message is sent as one binary websocket message.
+type RelayConn struct {
+	// The name of the endpoint we are connected to.
+	endpoint Address
+
+	// The websocket used
+	ws *websocket.Conn
+	// The public key the remote party proved to own, nil if it didn't
+	public abstract.Point
+
+	// closed indicator
+	closed    bool
+	closedMut sync.Mutex
+	// So we only handle one receiving packet at a time
+	receiveMutex sync.Mutex
+	// So we only handle one sending packet at a time
+	sendMutex sync.Mutex
+
+	counterSafe
+}
+
+// NewRelayConn opens a RelayConn to the node behind the relay of the given
+// Relay address. The node has to prove that it owns the public key of the
+// address. If private is not nil, we prove to own the corresponding public
+// key, too, which the node requires to accept our ServerIdentity.
+// In case of an error it returns a nil RelayConn and the error.
+func NewRelayConn(addr Address, private abstract.Scalar) (*RelayConn, error) {
+	if addr.ConnType() != Relay {
+		return nil, errors.New("Can't open a RelayConn to a non-relay address")
+	}
+	public, err := relayPublic(addr.RelayID())
+	if err != nil {
+		return nil, err
+	}
+	ws, err := dialRelay(addr.NetworkAddress(), relayConnectPath+addr.RelayID())
+	if err != nil {
+		return nil, err
+	}
+	if err := relayDial(ws, public, private); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return &RelayConn{
+		endpoint: addr,
+		ws:       ws,
+		public:   public,
+	}, nil
+}
+
+// connectRelay opens a RelayConn to addr, which has to be an address of si.
+func connectRelay(si *ServerIdentity, addr Address, private abstract.Scalar) (Conn, error) {
+	c, err := NewRelayConn(addr, private)
+	if err != nil {
+		return nil, err
+	}
+	if !c.public.Equal(si.Public) {
+		c.Close()
+		return nil, fmt.Errorf("%s doesn't belong to the public key of %s", addr, si)
+	}
+	return c, nil
+}
+
+// relayDial runs the handshake of the dialing node on a relayed websocket.
+// It checks that the node behind the relay owns public and proves that we own
+// the key corresponding to private, unless it is nil.
+func relayDial(ws *websocket.Conn, public abstract.Point, private abstract.Scalar) error {
+	ws.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer ws.SetReadDeadline(time.Time{})
+	challenge, err := relayChallenge()
+	if err != nil {
+		return err
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, challenge); err != nil {
+		return err
+	}
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if len(msg) < relayChallengeSize {
+		return errors.New("Relayed node sent a short challenge")
+	}
+	remote := msg[:relayChallengeSize]
+	proven, err := verifyRelayProof(msg[relayChallengeSize:], relayAcceptTag, challenge, remote)
+	if err != nil {
+		return err
+	}
+	if !proven.Equal(public) {
+		return errors.New("Relayed node doesn't own the key of its address")
+	}
+	var proof []byte
+	if private != nil {
+		if proof, err = relayProof(private, relayDialTag, remote, challenge); err != nil {
+			return err
+		}
+	}
+	return ws.WriteMessage(websocket.BinaryMessage, proof)
+}
+
+// relayAccept runs the handshake of the node behind the relay on a relayed
+// websocket. It proves that we own the key corresponding to private and
+// returns the public key the dialing node proved to own, or nil if it didn't
+// prove any.
+func relayAccept(ws *websocket.Conn, private abstract.Scalar) (abstract.Point, error) {
+	ws.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer ws.SetReadDeadline(time.Time{})
+	_, remote, err := ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if len(remote) != relayChallengeSize {
+		return nil, errors.New("Dialing node sent a wrong challenge")
+	}
+	challenge, err := relayChallenge()
+	if err != nil {
+		return nil, err
+	}
+	proof, err := relayProof(private, relayAcceptTag, remote, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, append(challenge, proof...)); err != nil {
+		return nil, err
+	}
+	_, proof, err = ws.ReadMessage()
+	if err != nil || len(proof) == 0 {
+		return nil, err
+	}
+	return verifyRelayProof(proof, relayDialTag, challenge, remote)
+}
+
+// relayChallenge returns a fresh random challenge.
+func relayChallenge() ([]byte, error) {
+	challenge := make([]byte, relayChallengeSize)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// relayProof returns the public key corresponding to private followed by a
+// signature on the tag and the challenges.
+func relayProof(private abstract.Scalar, tag string, challenges ...[]byte) ([]byte, error) {
+	pub, err := Suite.Point().Mul(nil, private).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(Suite, private, relaySigned(tag, challenges...))
+	if err != nil {
+		return nil, err
+	}
+	return append(pub, sig...), nil
+}
+
+// verifyRelayProof checks a proof created by relayProof and returns the
+// proven public key.
+func verifyRelayProof(proof []byte, tag string, challenges ...[]byte) (abstract.Point, error) {
+	n := Suite.PointLen()
+	if len(proof) < n {
+		return nil, errors.New("Missing proof of the public key")
+	}
+	public := Suite.Point()
+	if err := public.UnmarshalBinary(proof[:n]); err != nil {
+		return nil, err
+	}
+	if err := crypto.VerifySchnorr(Suite, public, relaySigned(tag, challenges...), proof[n:]); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// relaySigned returns the message signed in a proof.
+func relaySigned(tag string, challenges ...[]byte) []byte {
+	msg := bytes.NewBufferString(tag)
+	for _, c := range challenges {
+		msg.Write(c)
+	}
+	return msg.Bytes()
+}
+
+// Receive reads the next websocket message and decodes it.
+// It returns the Envelope containing the message,
+// or nil and an error if something wrong happened.
+func (c *RelayConn) Receive() (*Envelope, error) {
+	c.receiveMutex.Lock()
+	defer c.receiveMutex.Unlock()
+	c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+	_, buff, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, relayError(err)
+	}
+	c.updateRx(uint64(len(buff)))
+
+	id, body, err := Unmarshal(buff)
+	return &Envelope{
+		MsgType: id,
+		Msg:     body,
+	}, err
+}
+
+// Send marshals the message and sends it as one websocket message.
+// It returns an error if anything was wrong.
+func (c *RelayConn) Send(msg Message) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	b, err := Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Error marshaling  message: %s", err.Error())
+	}
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return relayError(err)
+	}
+	c.updateTx(uint64(len(b)))
+	return nil
+}
+
+// Remote returns the name of the peer at the end point of
+// the connection.
+func (c *RelayConn) Remote() Address {
+	return c.endpoint
+}
+
+// Local returns the local address and port.
+func (c *RelayConn) Local() Address {
+	return NewTCPAddress(c.ws.LocalAddr().String())
+}
+
+// Type returns Relay.
+func (c *RelayConn) Type() ConnType {
+	return Relay
+}
+
+// Public returns the public key the remote party proved to own during the
+// handshake, or nil if it didn't prove any.
+func (c *RelayConn) Public() abstract.Point {
+	return c.public
+}
+
+// Close the connection.
+// Returns error if it couldn't close the connection.
+func (c *RelayConn) Close() error {
+	c.closedMut.Lock()
+	defer c.closedMut.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	c.sendMutex.Lock()
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(WaitRetry))
+	c.sendMutex.Unlock()
+	return c.ws.Close()
+}
+
+// relayError translates the errors of a websocket to the errors used in
+// our packages.
+func relayError(err error) error {
+	if _, ok := err.(*websocket.CloseError); ok {
+		return ErrEOF
+	}
+	return handleError(err)
+}
+
+// RelayHost implements the Host interface for a node that can't accept
+// incoming connections. It listens by registering at the relay of its
+// address and connects directly to PlainTCP addresses and through the
+// relay to Relay addresses.
+type RelayHost struct {
+	addr    Address
+	private abstract.Scalar
+
+	// control is the websocket registered at the relay.
+	control *websocket.Conn
+	// quit is closed when the host is stopped.
+	quit chan bool
+	// done is closed when Listen returns.
+	done    chan bool
+	running bool
+	closed  bool
+	sync.Mutex
+}
+
+// NewRelayRouter returns a new Router using RelayHost as the underlying Host.
+// The private key has to correspond to the public key of sid; it is used to
+// register at the relay.
+func NewRelayRouter(sid *ServerIdentity, private abstract.Scalar) (*Router, error) {
+	h, err := NewRelayHost(sid, private)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouter(sid, h), nil
+}
+
+// NewRelayHost returns a Host for the given ServerIdentity, which needs to
+// have a Relay address that belongs to its public key.
+func NewRelayHost(sid *ServerIdentity, private abstract.Scalar) (*RelayHost, error) {
+	if sid.Address.ConnType() != Relay {
+		return nil, errors.New("RelayHost can't listen on non-relay address")
+	}
+	if !Suite.Point().Mul(nil, private).Equal(sid.Public) {
+		return nil, errors.New("Private key doesn't match the ServerIdentity")
+	}
+	id, err := relayID(sid.Public)
+	if err != nil {
+		return nil, err
+	}
+	if id != sid.Address.RelayID() {
+		return nil, errors.New("Relay address doesn't belong to the public key")
+	}
+	return &RelayHost{
+		addr:    sid.Address,
+		private: private,
+		quit:    make(chan bool),
+		done:    make(chan bool),
+	}, nil
+}
+
+// Listen registers at the relay and calls fn in a go routine for every
+// connection the relay forwards. If the websocket to the relay breaks, it
+// registers again. It returns when Stop is called.
+func (h *RelayHost) Listen(fn func(Conn)) error {
+	h.Lock()
+	if h.closed {
+		h.Unlock()
+		return nil
+	}
+	if h.running {
+		h.Unlock()
+		return errors.New("Already listening")
+	}
+	h.running = true
+	h.Unlock()
+	defer close(h.done)
+
+	for {
+		control, err := h.register()
+		if err == nil && h.setControl(control) {
+			for {
+				_, token, err := control.ReadMessage()
+				if err != nil {
+					break
+				}
+				go h.accept(string(token), fn)
+			}
+			h.setControl(nil)
+		} else if err != nil {
+			log.Lvl2(h.addr, "couldn't register at relay:", err)
+		}
+		select {
+		case <-h.quit:
+			return nil
+		case <-time.After(WaitRetry):
+		}
+	}
+}
+
+// register opens the control websocket and proves to the relay that we own
+// the public key of our address.
+func (h *RelayHost) register() (*websocket.Conn, error) {
+	ws, err := dialRelay(h.addr.NetworkAddress(), relayRegisterPath+h.addr.RelayID())
+	if err != nil {
+		return nil, err
+	}
+	ws.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	_, challenge, err := ws.ReadMessage()
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(Suite, h.private, append([]byte(relayTag), challenge...))
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, sig); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	// The relay pings regularly, so a silent websocket is broken.
+	ws.SetReadDeadline(time.Now().Add(2 * relayPingInterval))
+	ws.SetPingHandler(func(data string) error {
+		ws.SetReadDeadline(time.Now().Add(2 * relayPingInterval))
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(WaitRetry))
+	})
+	return ws, nil
+}
+
+// setControl stores the control websocket. It returns false and closes the
+// websocket if the host has been stopped in the meantime.
+func (h *RelayHost) setControl(ws *websocket.Conn) bool {
+	h.Lock()
+	defer h.Unlock()
+	if ws != nil && h.closed {
+		ws.Close()
+		return false
+	}
+	h.control = ws
+	return true
+}
+
+// accept dials back to the relay for the connection announced with token.
+func (h *RelayHost) accept(token string, fn func(Conn)) {
+	ws, err := dialRelay(h.addr.NetworkAddress(), relayAcceptPath+token)
+	if err != nil {
+		log.Lvl2(h.addr, "couldn't accept relayed connection:", err)
+		return
+	}
+	public, err := relayAccept(ws, h.private)
+	if err != nil {
+		log.Lvl2(h.addr, "handshake of relayed connection failed:", err)
+		ws.Close()
+		return
+	}
+	fn(&RelayConn{
+		endpoint: NewTCPAddress(h.addr.NetworkAddress()),
+		ws:       ws,
+		public:   public,
+	})
+}
+
+// Stop unregisters from the relay and waits for Listen to return.
+func (h *RelayHost) Stop() error {
+	h.Lock()
+	if h.closed {
+		h.Unlock()
+		return ErrClosed
+	}
+	h.closed = true
+	close(h.quit)
+	if h.control != nil {
+		h.control.Close()
+	}
+	running := h.running
+	h.Unlock()
+	if running {
+		<-h.done
+	}
+	return nil
+}
+
+// Address returns the Relay address of this host.
+func (h *RelayHost) Address() Address {
+	return h.addr
+}
+
+// Listening returns whether the host is registered at the relay.
+func (h *RelayHost) Listening() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.control != nil
+}
+
+// Connect opens a PlainTCP connection or a connection through the relay of
+// a Relay address, on which we prove to own our key. The addresses of si are
+// tried in order.
+func (h *RelayHost) Connect(si *ServerIdentity) (Conn, error) {
+	return dialAny(si.AllAddresses(), func(addr Address) (Conn, error) {
+		switch addr.ConnType() {
+		case PlainTCP:
+			c, err := NewTCPConn(addr)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		case Relay:
+			return connectRelay(si, addr, h.private)
+		}
+		return nil, fmt.Errorf("RelayHost %s can't handle this type of connection: %s", addr, addr.ConnType())
+	})
+}
+
+// relayNode is a node registered at a RelayServer.
+type relayNode struct {
+	ws *websocket.Conn
+	// So we only send one token at a time
+	sendMutex sync.Mutex
+}
+
+// RelayServer forwards connections to nodes that registered with it. It
+// only relays the messages and can't read or modify them if the nodes use
+// an authenticated channel on top of it.
+type RelayServer struct {
+	listener net.Listener
+	upgrader websocket.Upgrader
+	// nodes maps the identifiers to the registered nodes.
+	nodes map[string]*relayNode
+	// pending maps the tokens of announced connections to the channel
+	// waiting for the node to dial back. A token is removed once the node
+	// dialed back, after which the channel always receives a websocket or nil.
+	pending map[string]chan *websocket.Conn
+	closed  bool
+	sync.Mutex
+}
+
+// NewRelayServer returns a RelayServer bound to the given network address,
+// which is a host and a port joined by a colon. It serves websockets over TLS
+// with the given configuration, which needs a certificate. Only if
+// RelayPlaintext is set, config may be nil to serve plaintext websockets.
+// A subsequent call to Address() gives the actual listening address which is
+// different if you gave it a ":0"-address.
+func NewRelayServer(addr string, config *tls.Config) (*RelayServer, error) {
+	if config == nil && !RelayPlaintext {
+		return nil, errors.New("RelayServer needs a TLS configuration")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.New("Error opening listener: " + err.Error())
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	return &RelayServer{
+		listener: ln,
+		nodes:    make(map[string]*relayNode),
+		pending:  make(map[string]chan *websocket.Conn),
+	}, nil
+}
+
+// Address returns the network address the relay listens on.
+func (r *RelayServer) Address() string {
+	return r.listener.Addr().String()
+}
+
+// Start serves the relay. This is a blocking call until r.Stop() is called.
+func (r *RelayServer) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(relayRegisterPath, r.handleRegister)
+	mux.HandleFunc(relayConnectPath, r.handleConnect)
+	mux.HandleFunc(relayAcceptPath, r.handleAccept)
+	err := http.Serve(r.listener, mux)
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return nil
+	}
+	return err
+}
+
+// Stop closes the listener and the websockets of all registered nodes.
+func (r *RelayServer) Stop() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	for _, n := range r.nodes {
+		n.ws.Close()
+	}
+	return r.listener.Close()
+}
+
+// handleRegister authenticates a node with a challenge and keeps its
+// websocket to announce incoming connections.
+func (r *RelayServer) handleRegister(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, relayRegisterPath)
+	public, err := relayPublic(id)
+	if err != nil {
+		http.Error(w, "invalid identifier", http.StatusBadRequest)
+		return
+	}
+	ws, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	ws.SetReadLimit(1024)
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		ws.Close()
+		return
+	}
+	ws.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if err := ws.WriteMessage(websocket.BinaryMessage, challenge); err != nil {
+		ws.Close()
+		return
+	}
+	_, sig, err := ws.ReadMessage()
+	if err != nil {
+		ws.Close()
+		return
+	}
+	msg := append([]byte(relayTag), challenge...)
+	if err := crypto.VerifySchnorr(Suite, public, msg, sig); err != nil {
+		log.Lvl2("Relay rejects registration of", id, ":", err)
+		ws.Close()
+		return
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * relayPingInterval))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(2 * relayPingInterval))
+		return nil
+	})
+
+	node := &relayNode{ws: ws}
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		ws.Close()
+		return
+	}
+	if old, ok := r.nodes[id]; ok {
+		old.ws.Close()
+	}
+	r.nodes[id] = node
+	r.Unlock()
+	log.Lvl3("Relay registered", id)
+
+	// The node only answers pings, so this returns when it goes away.
+	stop := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(relayPingInterval):
+				ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WaitRetry))
+			}
+		}
+	}()
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	close(stop)
+	r.Lock()
+	if r.nodes[id] == node {
+		delete(r.nodes, id)
+	}
+	r.Unlock()
+	ws.Close()
+}
+
+// handleConnect announces a new connection to the registered node and
+// forwards the messages once the node dialed back.
+func (r *RelayServer) handleConnect(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, relayConnectPath)
+	r.Lock()
+	node, ok := r.nodes[id]
+	r.Unlock()
+	if !ok {
+		http.Error(w, "unknown node", http.StatusNotFound)
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	accepted := make(chan *websocket.Conn, 1)
+	r.Lock()
+	r.pending[token] = accepted
+	r.Unlock()
+
+	ws, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		r.cancelPending(token, accepted)
+		return
+	}
+	ws.SetReadLimit(int64(MaxPacketSize))
+	node.sendMutex.Lock()
+	err = node.ws.WriteMessage(websocket.TextMessage, []byte(token))
+	node.sendMutex.Unlock()
+	if err != nil {
+		r.cancelPending(token, accepted)
+		ws.Close()
+		return
+	}
+
+	select {
+	case back := <-accepted:
+		if back == nil {
+			ws.Close()
+			return
+		}
+		relayForward(ws, back)
+	case <-time.After(relayAcceptTimeout):
+		log.Lvl2("Relay: node", id, "didn't accept connection")
+		r.cancelPending(token, accepted)
+		ws.Close()
+	}
+}
+
+// cancelPending removes the token of an announced connection. If the node
+// already dialed back, it waits for the websocket and closes it.
+func (r *RelayServer) cancelPending(token string, accepted chan *websocket.Conn) {
+	r.Lock()
+	_, waiting := r.pending[token]
+	delete(r.pending, token)
+	r.Unlock()
+	if !waiting {
+		if back := <-accepted; back != nil {
+			back.Close()
+		}
+	}
+}
+
+// handleAccept hands the websocket of a node dialing back to the waiting
+// connection.
+func (r *RelayServer) handleAccept(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.URL.Path, relayAcceptPath)
+	r.Lock()
+	accepted, ok := r.pending[token]
+	delete(r.pending, token)
+	r.Unlock()
+	if !ok {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+	ws, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		accepted <- nil
+		return
+	}
+	ws.SetReadLimit(int64(MaxPacketSize))
+	accepted <- ws
+}
+
+// relayForward copies the messages between both websockets until one of
+// them is closed, then closes the other one.
+func relayForward(a, b *websocket.Conn) {
+	done := make(chan bool, 2)
+	copyMessages := func(from, to *websocket.Conn) {
+		for {
+			t, buf, err := from.ReadMessage()
+			if err != nil {
+				break
+			}
+			if err := to.WriteMessage(t, buf); err != nil {
+				break
+			}
+		}
+		done <- true
+	}
+	go copyMessages(a, b)
+	go copyMessages(b, a)
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
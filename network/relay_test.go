@@ -0,0 +1,240 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"mobilehound/config"
+)
+
+func TestRelayRouter(t *testing.T) {
+	relay := newTestRelay(t)
+	defer relay.Stop()
+
+	kp := config.NewKeyPair(Suite)
+	addr, err := NewRelayAddress(relay.Address(), kp.Public)
+	require.Nil(t, err)
+	require.Equal(t, ConnType(Relay), addr.ConnType())
+	require.Equal(t, relay.Address(), addr.NetworkAddress())
+	mobile, err := NewRelayRouter(NewServerIdentity(kp.Public, addr), kp.Secret)
+	require.Nil(t, err)
+	router := newTestRouterKeyed(t)
+	go mobile.Start()
+	go router.Start()
+	defer router.Stop()
+	defer mobile.Stop()
+	for !mobile.Listening() || !router.Listening() {
+		time.Sleep(WaitRetry)
+	}
+
+	// The first message goes through the relay, the answer over the same
+	// connection.
+	require.Nil(t, sendrcvProc(router, mobile))
+	require.Nil(t, sendrcvProc(mobile, router))
+	c := router.connection(mobile.ServerIdentity.ID)
+	require.NotNil(t, c)
+	require.Equal(t, ConnType(Relay), c.Type())
+
+	// Without a connection, the mobile node dials out directly
+	router2 := newTestRouterKeyed(t)
+	go router2.Start()
+	defer router2.Stop()
+	for !router2.Listening() {
+		time.Sleep(WaitRetry)
+	}
+	require.Nil(t, sendrcvProc(mobile, router2))
+	require.Equal(t, PlainTCP, router2.connection(mobile.ServerIdentity.ID).Type())
+
+	// Unknown nodes can't be reached
+	other := config.NewKeyPair(Suite)
+	otherAddr, err := NewRelayAddress(relay.Address(), other.Public)
+	require.Nil(t, err)
+	_, err = NewRelayConn(otherAddr, nil)
+	require.NotNil(t, err)
+
+	// Nodes that don't prove their key over the relay are not accepted
+	anonymous, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	go anonymous.Start()
+	defer anonymous.Stop()
+	for !anonymous.Listening() {
+		time.Sleep(WaitRetry)
+	}
+	require.NotNil(t, sendrcvProc(anonymous, mobile))
+
+	// Nodes have to prove the key of the relay address they are reached at
+	_, err = connectRelay(NewServerIdentity(other.Public, addr), addr, nil)
+	require.NotNil(t, err)
+}
+
+func TestRelayProof(t *testing.T) {
+	kp := config.NewKeyPair(Suite)
+	c1, err := relayChallenge()
+	require.Nil(t, err)
+	c2, err := relayChallenge()
+	require.Nil(t, err)
+	proof, err := relayProof(kp.Secret, relayAcceptTag, c1, c2)
+	require.Nil(t, err)
+	public, err := verifyRelayProof(proof, relayAcceptTag, c1, c2)
+	require.Nil(t, err)
+	require.True(t, public.Equal(kp.Public))
+
+	_, err = verifyRelayProof(proof, relayDialTag, c1, c2)
+	require.NotNil(t, err)
+	_, err = verifyRelayProof(proof, relayAcceptTag, c2, c1)
+	require.NotNil(t, err)
+	_, err = verifyRelayProof(proof[:Suite.PointLen()-1], relayAcceptTag, c1, c2)
+	require.NotNil(t, err)
+}
+
+func TestRelayHostKeys(t *testing.T) {
+	kp := config.NewKeyPair(Suite)
+	other := config.NewKeyPair(Suite)
+	addr, err := NewRelayAddress("127.0.0.1:2020", kp.Public)
+	require.Nil(t, err)
+	require.Equal(t, addr.RelayID(), string(addr)[len("relay://127.0.0.1:2020/"):])
+
+	_, err = NewRelayHost(NewServerIdentity(kp.Public, addr), other.Secret)
+	require.NotNil(t, err)
+	_, err = NewRelayHost(NewServerIdentity(other.Public, addr), other.Secret)
+	require.NotNil(t, err)
+	_, err = NewRelayHost(NewServerIdentity(kp.Public, NewTCPAddress("127.0.0.1:2020")), kp.Secret)
+	require.NotNil(t, err)
+}
+
+func TestRelayRegister(t *testing.T) {
+	relay := newTestRelay(t)
+	defer relay.Stop()
+
+	// Registering without knowing the private key fails
+	kp := config.NewKeyPair(Suite)
+	addr, err := NewRelayAddress(relay.Address(), kp.Public)
+	require.Nil(t, err)
+	ws, err := dialRelay(relay.Address(), relayRegisterPath+addr.RelayID())
+	require.Nil(t, err)
+	_, _, err = ws.ReadMessage()
+	require.Nil(t, err)
+	require.Nil(t, ws.WriteMessage(websocket.BinaryMessage, make([]byte, 64)))
+	_, _, err = ws.ReadMessage()
+	require.NotNil(t, err)
+	ws.Close()
+	_, err = NewRelayConn(addr, nil)
+	require.NotNil(t, err)
+
+	// A stopped host unregisters
+	h, err := NewRelayHost(NewServerIdentity(kp.Public, addr), kp.Secret)
+	require.Nil(t, err)
+	go h.Listen(acceptAndClose)
+	for !h.Listening() {
+		time.Sleep(WaitRetry)
+	}
+	c, err := NewRelayConn(addr, nil)
+	require.Nil(t, err)
+	require.True(t, c.Public().Equal(kp.Public))
+	_, err = c.Receive()
+	require.Equal(t, ErrEOF, err)
+	require.Nil(t, h.Stop())
+	require.Equal(t, ErrClosed, h.Stop())
+}
+
+func TestRelayTLS(t *testing.T) {
+	_, err := NewRelayServer("127.0.0.1:0", nil)
+	require.NotNil(t, err)
+
+	// The certificate of the relay is checked
+	relay := newTestRelay(t)
+	defer relay.Stop()
+	RelayTLSConfig = nil
+	_, err = dialRelay(relay.Address(), relayRegisterPath)
+	require.NotNil(t, err)
+}
+
+func TestRelayAcceptTimeout(t *testing.T) {
+	relay := newTestRelay(t)
+	defer relay.Stop()
+	timeout := relayAcceptTimeout
+	relayAcceptTimeout = 100 * time.Millisecond
+	defer func() { relayAcceptTimeout = timeout }()
+
+	// A node which dials back too late finds its websocket closed
+	kp := config.NewKeyPair(Suite)
+	addr, err := NewRelayAddress(relay.Address(), kp.Public)
+	require.Nil(t, err)
+	h, err := NewRelayHost(NewServerIdentity(kp.Public, addr), kp.Secret)
+	require.Nil(t, err)
+	control, err := h.register()
+	require.Nil(t, err)
+	defer control.Close()
+	tokens := make(chan string, 1)
+	go func() {
+		_, token, err := control.ReadMessage()
+		if err == nil {
+			tokens <- string(token)
+		}
+	}()
+	_, err = NewRelayConn(addr, nil)
+	require.NotNil(t, err)
+	ws, err := dialRelay(relay.Address(), relayAcceptPath+<-tokens)
+	if err == nil {
+		_, _, err = ws.ReadMessage()
+		require.NotNil(t, err)
+		ws.Close()
+	}
+}
+
+// newTestRelay starts a RelayServer on a random port with a self-signed
+// certificate for 127.0.0.1, which it makes the only one trusted by
+// RelayTLSConfig.
+func newTestRelay(t *testing.T) *RelayServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	RelayTLSConfig = &tls.Config{RootCAs: roots}
+
+	relay, err := NewRelayServer("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	})
+	require.Nil(t, err)
+	go relay.Start()
+	return relay
+}
+
+// newTestRouterKeyed returns a Router with a TCPHost that listens on a
+// random port of 127.0.0.1 and proves its key to nodes behind a relay.
+func newTestRouterKeyed(t *testing.T) *Router {
+	kp := config.NewKeyPair(Suite)
+	h, err := NewTestTCPHost(0)
+	require.Nil(t, err)
+	_, port, err := net.SplitHostPort(h.TCPListener.Address().NetworkAddress())
+	require.Nil(t, err)
+	h.addr = NewTCPAddress(net.JoinHostPort("127.0.0.1", port))
+	h.private = kp.Secret
+	return NewRouter(NewServerIdentity(kp.Public, h.addr), h)
+}
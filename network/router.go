@@ -48,17 +48,33 @@ type Router struct {
 
 	// keep bandwidth of closed connections
 	traffic counterSafe
+	// keep bandwidth of closed connections before compression
+	rawTraffic counterSafe
+
+	// compression enables the compression of messages, see SetCompression.
+	compression bool
+
+	// queues holds the per-peer send queues, see SetQueueConfig.
+	queues *routerQueues
 }
 
 // NewRouter returns a new Router attached to a ServerIdentity and the host we want to
-// use.
+// use. It processes the incoming messages one after the other with a
+// BlockingDispatcher.
 func NewRouter(own *ServerIdentity, h Host) *Router {
+	return NewRouterWithDispatcher(own, h, NewBlockingDispatcher())
+}
+
+// NewRouterWithDispatcher returns a new Router like NewRouter, but using d to
+// dispatch the incoming messages, e.g. a PoolDispatcher.
+func NewRouterWithDispatcher(own *ServerIdentity, h Host, d Dispatcher) *Router {
 	r := &Router{
 		ServerIdentity:          own,
 		connections:             make(map[ServerIdentityID][]Conn),
 		host:                    h,
-		Dispatcher:              NewBlockingDispatcher(),
+		Dispatcher:              d,
 		connectionErrorHandlers: make([]func(*ServerIdentity), 0),
+		queues:                  newRouterQueues(),
 	}
 	r.address = h.Address()
 	return r
@@ -117,6 +133,7 @@ func (r *Router) Stop() error {
 	}
 	// wait for all handleConn to finish
 	r.Unlock()
+	r.queues.stop()
 	r.wg.Wait()
 
 	if err != nil {
@@ -125,11 +142,15 @@ func (r *Router) Stop() error {
 	return nil
 }
 
-// Send sends to an ServerIdentity without wrapping the msg into a ProtocolMsg
+// Send sends to an ServerIdentity without wrapping the msg into a ProtocolMsg.
+// If queueing is enabled, it only queues the message and returns.
 func (r *Router) Send(e *ServerIdentity, msg Message) error {
 	if msg == nil {
 		return errors.New("Can't send nil-packet")
 	}
+	if r.queueing() {
+		return r.enqueue(e, msg)
+	}
 
 	c := r.connection(e.ID)
 	if c == nil {
@@ -171,6 +192,9 @@ func (r *Router) connect(si *ServerIdentity) (Conn, error) {
 	if err := c.Send(r.ServerIdentity); err != nil {
 		return nil, err
 	}
+	if err := r.announceCapabilities(c); err != nil {
+		return nil, err
+	}
 
 	if err := r.registerConnection(si, c); err != nil {
 		return nil, err
@@ -223,6 +247,8 @@ func (r *Router) handleConn(remote *ServerIdentity, c Conn) {
 		}
 		r.traffic.updateRx(c.Rx())
 		r.traffic.updateTx(c.Tx())
+		r.rawTraffic.updateRx(rxRaw(c))
+		r.rawTraffic.updateTx(txRaw(c))
 		r.wg.Done()
 		r.removeConnection(remote, c)
 	}()
@@ -255,6 +281,11 @@ func (r *Router) handleConn(remote *ServerIdentity, c Conn) {
 
 		packet.ServerIdentity = remote
 
+		if packet.MsgType == CapabilitiesType {
+			r.handleCapabilities(c, packet.Msg.(*Capabilities))
+			continue
+		}
+
 		if err := r.Dispatch(packet); err != nil {
 			log.Lvl3("Error dispatching:", err)
 		}
@@ -341,6 +372,18 @@ func (r *Router) Rx() uint64 {
 	return rx
 }
 
+// Connections returns the number of open connections managed by this
+// router.
+func (r *Router) Connections() int {
+	r.Lock()
+	defer r.Unlock()
+	var n int
+	for _, arr := range r.connections {
+		n += len(arr)
+	}
+	return n
+}
+
 // Listening returns true if this router is started.
 func (r *Router) Listening() bool {
 	return r.host.Listening()
@@ -362,8 +405,25 @@ func (r *Router) receiveServerIdentity(c Conn) (*ServerIdentity, error) {
 	// Set the ServerIdentity for this connection
 	dst := nm.Msg.(*ServerIdentity)
 
-	if err != nil {
-		return nil, err
+	// On authenticated connections the remote party can only claim the
+	// identity whose key it proved during the handshake.
+	if tc, ok := c.(*TLSConn); ok {
+		if dst.Public == nil || !dst.Public.Equal(tc.Public()) {
+			return nil, fmt.Errorf("%s sent ServerIdentity with a key it didn't prove", c.Remote())
+		}
+		if !dst.ID.Equal(NewServerIdentity(dst.Public, dst.Address).ID) {
+			return nil, fmt.Errorf("%s sent ServerIdentity with a wrong ID", c.Remote())
+		}
+	}
+	// The relay can't be trusted, so the dialing node must have proven its
+	// key on a relayed connection, too.
+	if rc, ok := c.(*RelayConn); ok {
+		if dst.Public == nil || rc.Public() == nil || !dst.Public.Equal(rc.Public()) {
+			return nil, fmt.Errorf("%s sent ServerIdentity with a key it didn't prove", c.Remote())
+		}
+		if !dst.ID.Equal(NewServerIdentity(dst.Public, dst.Address).ID) {
+			return nil, fmt.Errorf("%s sent ServerIdentity with a wrong ID", c.Remote())
+		}
 	}
 	log.Lvl4(r.address, "Identity received from", dst.Address)
 	return dst, nil
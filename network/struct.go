@@ -3,6 +3,7 @@ package network
 import (
 	"bytes"
 	"errors"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -78,6 +79,9 @@ type ServerIdentity struct {
 	Address Address
 	// Description of the server
 	Description string
+	// Further addresses of the server, ex. for IPv4 and IPv6. Connect tries
+	// them in order after Address.
+	AltAddresses []Address
 }
 
 // ServerIdentityID uniquely identifies an ServerIdentity struct
@@ -107,8 +111,9 @@ var ServerIdentityType = RegisterMessage(ServerIdentity{})
 
 // ServerIdentityToml is the struct that can be marshalled into a toml file
 type ServerIdentityToml struct {
-	Public  string
-	Address Address
+	Public       string
+	Address      Address
+	AltAddresses []Address `toml:",omitempty"`
 }
 
 // NewServerIdentity creates a new ServerIdentity based on a public key and with a slice
@@ -135,8 +140,9 @@ func (si *ServerIdentity) Toml(suite abstract.Suite) *ServerIdentityToml {
 		log.Error("Error while writing public key:", err)
 	}
 	return &ServerIdentityToml{
-		Address: si.Address,
-		Public:  buf.String(),
+		Address:      si.Address,
+		AltAddresses: si.AltAddresses,
+		Public:       buf.String(),
 	}
 }
 
@@ -147,19 +153,28 @@ func (si *ServerIdentityToml) ServerIdentity(suite abstract.Suite) *ServerIdenti
 		log.Error("Error while reading public key:", err)
 	}
 	return &ServerIdentity{
-		Public:  pub,
-		Address: si.Address,
+		Public:       pub,
+		Address:      si.Address,
+		AltAddresses: si.AltAddresses,
 	}
 }
 
+// AllAddresses returns Address followed by the AltAddresses.
+func (si *ServerIdentity) AllAddresses() []Address {
+	return append([]Address{si.Address}, si.AltAddresses...)
+}
+
 // GlobalBind returns the global-binding address. Given any IP:PORT combination,
-// it will return 0.0.0.0:PORT.
+// it will return 0.0.0.0:PORT, or [::]:PORT for an IPv6 address.
 func GlobalBind(address string) (string, error) {
-	addr := strings.Split(address, ":")
-	if len(addr) != 2 {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
 		return "", errors.New("not a host:port address")
 	}
-	return "0.0.0.0:" + addr[1], nil
+	if strings.Contains(host, ":") {
+		return "[::]:" + port, nil
+	}
+	return "0.0.0.0:" + port, nil
 }
 
 // counterSafe is a struct that enables to update two counters Rx & Tx
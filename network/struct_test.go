@@ -33,6 +33,12 @@ func TestServerIdentity(t *testing.T) {
 	if si11.Address != si1.Address || !si11.Public.Equal(si1.Public) {
 		t.Error("Stg wrong with toml -> Si")
 	}
+	si1.AltAddresses = []Address{NewTCPAddress("[::1]:2000")}
+	si13 := si1.Toml(Suite).ServerIdentity(Suite)
+	if len(si13.AllAddresses()) != 2 || si13.AllAddresses()[1] != si1.AltAddresses[0] {
+		t.Error("Stg wrong with alternative addresses")
+	}
+
 	t1.Public = ""
 	si12 := t1.ServerIdentity(Suite)
 	if si12.Public.Equal(si1.Public) {
@@ -50,4 +56,12 @@ func TestGlobalBind(t *testing.T) {
 	if err == nil {
 		t.Error("Wrong with global bind")
 	}
+	bind, err := GlobalBind("[2001:db8::1]:2000")
+	if err != nil || bind != "[::]:2000" {
+		t.Error("Wrong with global bind for IPv6:", bind, err)
+	}
+	_, err = GlobalBind("::1:2000")
+	if err == nil {
+		t.Error("Wrong with global bind")
+	}
 }
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"mobilehound/v0-abstract"
 	"mobilehound/v0-log"
 )
 
@@ -33,6 +34,21 @@ func NewTCPRouter(sid *ServerIdentity) (*Router, error) {
 	return r, nil
 }
 
+// NewTCPRouterWithKey returns a new Router using TCPHost as the underlying
+// Host, which proves to own the private key of sid when it connects to nodes
+// behind a relay. Without the key, these nodes don't accept its identity.
+func NewTCPRouterWithKey(sid *ServerIdentity, private abstract.Scalar) (*Router, error) {
+	if !Suite.Point().Mul(nil, private).Equal(sid.Public) {
+		return nil, errors.New("Private key doesn't match the ServerIdentity")
+	}
+	h, err := NewTCPHost(sid.Address)
+	if err != nil {
+		return nil, err
+	}
+	h.private = private
+	return NewRouter(sid, h), nil
+}
+
 // TCPConn implements the Conn interface using plain, unencrypted TCP.
 type TCPConn struct {
 	// The name of the endpoint we are connected to.
@@ -49,7 +65,12 @@ type TCPConn struct {
 	// So we only handle one sending packet at a time
 	sendMutex sync.Mutex
 
+	// compress is true if the remote party can decompress messages.
+	compress bool
+
 	counterSafe
+	// raw counts the bytes before compression.
+	raw counterSafe
 }
 
 // NewTCPConn will open a TCPConn to the given address.
@@ -113,6 +134,8 @@ func (c *TCPConn) receiveRaw() ([]byte, error) {
 	if err := binary.Read(c.conn, globalOrder, &total); err != nil {
 		return nil, handleError(err)
 	}
+	compressed := total&compressedFlag != 0
+	total &^= compressedFlag
 	if total > MaxPacketSize {
 		return nil, errors.New(c.endpoint.String() + " sends too big packet")
 	}
@@ -138,11 +161,21 @@ func (c *TCPConn) receiveRaw() ([]byte, error) {
 
 	// register how many bytes we read.
 	c.updateRx(uint64(read))
-	return buffer.Bytes(), nil
+	if !compressed {
+		c.raw.updateRx(uint64(read))
+		return buffer.Bytes(), nil
+	}
+	out, err := inflate(buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	c.raw.updateRx(uint64(len(out)))
+	return out, nil
 }
 
 // Send converts the NetworkMessage into an ApplicationMessage
-// and sends it using send().
+// and sends it using send(). If the remote party supports it, big
+// messages are compressed.
 // It returns an error if anything was wrong.
 func (c *TCPConn) Send(msg Message) error {
 	c.sendMutex.Lock()
@@ -152,16 +185,34 @@ func (c *TCPConn) Send(msg Message) error {
 	if err != nil {
 		return fmt.Errorf("Error marshaling  message: %s", err.Error())
 	}
-	return c.sendRaw(b)
+	if c.compress && len(b) >= CompressThreshold {
+		if z, err := deflate(b); err == nil && len(z) < len(b) {
+			err = c.sendFrame(z, compressedFlag)
+			if err == nil {
+				c.raw.updateTx(uint64(len(b)))
+			}
+			return err
+		}
+	}
+	err = c.sendRaw(b)
+	if err == nil {
+		c.raw.updateTx(uint64(len(b)))
+	}
+	return err
 }
 
 // sendRaw writes the number of bytes of the message to the network then the
 // whole message b in slices of size maxChunkSize.
 // In case of an error it aborts and returns error.
 func (c *TCPConn) sendRaw(b []byte) error {
+	return c.sendFrame(b, 0)
+}
+
+// sendFrame is like sendRaw but adds flags to the size.
+func (c *TCPConn) sendFrame(b []byte, flags Size) error {
 	// First write the size
 	packetSize := Size(len(b))
-	if err := binary.Write(c.conn, globalOrder, packetSize); err != nil {
+	if err := binary.Write(c.conn, globalOrder, packetSize|flags); err != nil {
 		return err
 	}
 	// Then send everything through the connection
@@ -374,7 +425,8 @@ func (t *TCPListener) Listening() bool {
 
 // TCPHost implements the Host interface using TCP connections.
 type TCPHost struct {
-	addr Address
+	addr    Address
+	private abstract.Scalar
 	*TCPListener
 }
 
@@ -388,16 +440,24 @@ func NewTCPHost(addr Address) (*TCPHost, error) {
 	return h, err
 }
 
-// Connect can only connect to PlainTCP connections.
-// It will return an error if it is not a PlainTCP-connection-type.
+// Connect can only connect to PlainTCP connections and to nodes behind a
+// relay, which have to prove that they own the public key of si. The
+// addresses of si are tried in order.
+// It will return an error if it is another connection-type.
 func (t *TCPHost) Connect(si *ServerIdentity) (Conn, error) {
-	addr := si.Address
-	switch addr.ConnType() {
-	case PlainTCP:
-		c, err := NewTCPConn(addr)
-		return c, err
-	}
-	return nil, fmt.Errorf("TCPHost %s can't handle this type of connection: %s", addr, addr.ConnType())
+	return dialAny(si.AllAddresses(), func(addr Address) (Conn, error) {
+		switch addr.ConnType() {
+		case PlainTCP:
+			c, err := NewTCPConn(addr)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		case Relay:
+			return connectRelay(si, addr, t.private)
+		}
+		return nil, fmt.Errorf("TCPHost %s can't handle this type of connection: %s", addr, addr.ConnType())
+	})
 }
 
 // NewTCPAddress returns a new Address that has type PlainTCP with the given
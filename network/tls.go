@@ -0,0 +1,322 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"mobilehound/crypto"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-log"
+)
+
+// handshakeTimeout limits the time a TLS handshake may take.
+var handshakeTimeout = 10 * time.Second
+
+// certValidity is how long the self-signed certificate of a TLSHost is valid.
+var certValidity = 10 * 365 * 24 * time.Hour
+
+// oidIdentity is the certificate extension which binds the TLS key to the
+// public key of a ServerIdentity.
+var oidIdentity = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 51281, 1, 1}
+
+// identityTag is prepended to the signed content of the identity extension.
+const identityTag = "mobilehound/network/tls/identity/v1"
+
+// identityBinding is the content of the identity extension: the public key
+// of the ServerIdentity and its Schnorr signature on the subject public key
+// info of the certificate.
+type identityBinding struct {
+	Public    []byte
+	Signature []byte
+}
+
+// NewTLSRouter returns a new Router using TLSHost as the underlying Host. The
+// private key has to correspond to the public key of sid; it is used to bind
+// the TLS certificate to the ServerIdentity.
+func NewTLSRouter(sid *ServerIdentity, private abstract.Scalar) (*Router, error) {
+	h, err := NewTLSHost(sid, private)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRouter(sid, h)
+	return r, nil
+}
+
+// TLSConn implements the Conn interface using TLS over TCP. Both endpoints
+// authenticate with a certificate bound to their ServerIdentity, so the
+// public key of the remote party is known once the connection is set up.
+type TLSConn struct {
+	*TCPConn
+	public abstract.Point
+}
+
+// Public returns the public key the remote party proved to own during the
+// handshake.
+func (c *TLSConn) Public() abstract.Point {
+	return c.public
+}
+
+// Local returns the local address and port.
+func (c *TLSConn) Local() Address {
+	return NewAddress(TLS, c.conn.LocalAddr().String())
+}
+
+// Type returns TLS.
+func (c *TLSConn) Type() ConnType {
+	return TLS
+}
+
+// TLSListener implements the Listener interface using TLS connections.
+type TLSListener struct {
+	*TCPListener
+	config *tls.Config
+}
+
+// NewTLSListener returns a TLSListener bound to the given address. Incoming
+// connections have to present a certificate bound to a ServerIdentity.
+func NewTLSListener(addr Address, config *tls.Config) (*TLSListener, error) {
+	if addr.ConnType() != TLS {
+		return nil, errors.New("TLSListener can't listen on non-tls address")
+	}
+	ln, err := NewTCPListener(NewTCPAddress(addr.NetworkAddress()))
+	if err != nil {
+		return nil, err
+	}
+	return &TLSListener{TCPListener: ln, config: config}, nil
+}
+
+// Listen starts to listen for incoming connections and calls fn for every
+// connection whose handshake succeeded.
+// If the connection is closed, an error will be returned.
+func (t *TLSListener) Listen(fn func(Conn)) error {
+	receiver := func(c Conn) {
+		go func() {
+			tc := c.(*TCPConn)
+			conn, err := t.handshake(tc)
+			if err != nil {
+				log.Lvl2("TLS handshake with", tc.conn.RemoteAddr(), "failed:", err)
+				tc.Close()
+				return
+			}
+			fn(conn)
+		}()
+	}
+	return t.listen(receiver)
+}
+
+// handshake runs the server side of the TLS handshake on an accepted
+// connection.
+func (t *TLSListener) handshake(c *TCPConn) (*TLSConn, error) {
+	conn := tls.Server(c.conn, t.config)
+	public, err := runHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &TLSConn{
+		TCPConn: &TCPConn{
+			endpoint: NewAddress(TLS, c.conn.RemoteAddr().String()),
+			conn:     conn,
+		},
+		public: public,
+	}, nil
+}
+
+// Address returns the listening address.
+func (t *TLSListener) Address() Address {
+	return NewAddress(TLS, t.TCPListener.Address().NetworkAddress())
+}
+
+// TLSHost implements the Host interface using TLS connections.
+type TLSHost struct {
+	addr Address
+	*TLSListener
+}
+
+// NewTLSHost returns a new Host using TLS connections. It creates a fresh
+// certificate that is bound to sid by a signature of the private key.
+func NewTLSHost(sid *ServerIdentity, private abstract.Scalar) (*TLSHost, error) {
+	if !Suite.Point().Mul(nil, private).Equal(sid.Public) {
+		return nil, errors.New("Private key doesn't match the ServerIdentity")
+	}
+	config, err := newTLSConfig(private)
+	if err != nil {
+		return nil, err
+	}
+	h := &TLSHost{
+		addr: sid.Address,
+	}
+	h.TLSListener, err = NewTLSListener(sid.Address, config)
+	return h, err
+}
+
+// Connect can only connect to TLS connections. The remote party has to
+// prove that it owns the public key of si. The addresses of si are tried in
+// order.
+// It will return an error if it is not a TLS-connection-type.
+func (t *TLSHost) Connect(si *ServerIdentity) (Conn, error) {
+	return dialAny(si.AllAddresses(), func(addr Address) (Conn, error) {
+		return t.connect(si, addr)
+	})
+}
+
+// connect opens a TLS connection to addr and checks the public key of si.
+func (t *TLSHost) connect(si *ServerIdentity, addr Address) (Conn, error) {
+	if addr.ConnType() != TLS {
+		return nil, fmt.Errorf("TLSHost %s can't handle this type of connection: %s", addr, addr.ConnType())
+	}
+	c, err := NewTCPConn(NewTCPAddress(addr.NetworkAddress()))
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(c.conn, t.config)
+	public, err := runHandshake(conn)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !public.Equal(si.Public) {
+		c.Close()
+		return nil, fmt.Errorf("%s doesn't own the public key of %s", addr, si)
+	}
+	return &TLSConn{
+		TCPConn: &TCPConn{
+			endpoint: addr,
+			conn:     conn,
+		},
+		public: public,
+	}, nil
+}
+
+// NewTLSAddress returns a new Address that has type TLS with the given
+// address addr.
+func NewTLSAddress(addr string) Address {
+	return NewAddress(TLS, addr)
+}
+
+// runHandshake completes the TLS handshake on conn and returns the public key
+// bound to the certificate of the remote party.
+func runHandshake(conn *tls.Conn) (abstract.Point, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("No peer certificate")
+	}
+	return certificatePublic(certs[0])
+}
+
+// newTLSConfig creates a self-signed certificate for a fresh ECDSA key, binds
+// it to the public key corresponding to private and returns a configuration
+// that can be used both as client and as server. The peer certificates are not
+// checked against certificate authorities but have to carry a valid identity
+// extension.
+func newTLSConfig(private abstract.Scalar) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := Suite.Point().Mul(nil, private).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSchnorr(Suite, private, append([]byte(identityTag), spki...))
+	if err != nil {
+		return nil, err
+	}
+	ext, err := asn1.Marshal(identityBinding{Public: pub, Signature: sig})
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: Suite.Point().Mul(nil, private).String()},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(certValidity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidIdentity, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+		ClientAuth: tls.RequireAnyClientCert,
+		// The certificates are self-signed; verifyPeer checks the binding
+		// to the ServerIdentity instead.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeer,
+		MinVersion:            tls.VersionTLS12,
+	}, nil
+}
+
+// verifyPeer is called during the handshake and rejects certificates that
+// are not validly bound to a public key.
+func verifyPeer(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("No peer certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("Peer certificate expired or not yet valid")
+	}
+	_, err = certificatePublic(cert)
+	return err
+}
+
+// certificatePublic verifies the identity extension of cert and returns the
+// public key it binds the certificate to.
+func certificatePublic(cert *x509.Certificate) (abstract.Point, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidIdentity) {
+			continue
+		}
+		var b identityBinding
+		if rest, err := asn1.Unmarshal(ext.Value, &b); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, errors.New("Trailing data in identity extension")
+		}
+		public := Suite.Point()
+		if err := public.UnmarshalBinary(b.Public); err != nil {
+			return nil, err
+		}
+		msg := append([]byte(identityTag), cert.RawSubjectPublicKeyInfo...)
+		if err := crypto.VerifySchnorr(Suite, public, msg, b.Signature); err != nil {
+			return nil, errors.New("Invalid identity signature in certificate")
+		}
+		return public, nil
+	}
+	return nil, errors.New("Certificate isn't bound to an identity")
+}
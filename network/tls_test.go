@@ -0,0 +1,97 @@
+package network
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"mobilehound/config"
+	"mobilehound/v0-abstract"
+)
+
+func NewTestRouterTLS(port int) (*Router, abstract.Scalar, error) {
+	kp := config.NewKeyPair(Suite)
+	si := NewServerIdentity(kp.Public, NewTLSAddress("127.0.0.1:"+strconv.Itoa(port)))
+	r, err := NewTLSRouter(si, kp.Secret)
+	return r, kp.Secret, err
+}
+
+func TestTLSRouter(t *testing.T) {
+	router1, _, err := NewTestRouterTLS(2010)
+	require.Nil(t, err)
+	router2, _, err := NewTestRouterTLS(2011)
+	require.Nil(t, err)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+	for !router1.Listening() || !router2.Listening() {
+		time.Sleep(WaitRetry)
+	}
+
+	require.Nil(t, sendrcvProc(router1, router2))
+	require.Nil(t, sendrcvProc(router2, router1))
+
+	c := router1.connection(router2.ServerIdentity.ID)
+	require.NotNil(t, c)
+	require.Equal(t, ConnType(TLS), c.Type())
+	require.True(t, c.(*TLSConn).Public().Equal(router2.ServerIdentity.Public))
+
+	// A private key that doesn't match the identity is refused
+	kp := config.NewKeyPair(Suite)
+	_, err = NewTLSRouter(NewServerIdentity(kp.Public, NewTLSAddress("127.0.0.1:2012")),
+		config.NewKeyPair(Suite).Secret)
+	require.NotNil(t, err)
+	// Only tls addresses are supported
+	_, err = NewTLSRouter(NewServerIdentity(kp.Public, NewTCPAddress("127.0.0.1:2012")), kp.Secret)
+	require.NotNil(t, err)
+}
+
+func TestTLSImpersonation(t *testing.T) {
+	router1, _, err := NewTestRouterTLS(2013)
+	require.Nil(t, err)
+	router2, _, err := NewTestRouterTLS(2014)
+	require.Nil(t, err)
+	go router1.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+	for !router1.Listening() {
+		time.Sleep(WaitRetry)
+	}
+
+	// Connecting to an address with the wrong key fails
+	fake := NewServerIdentity(router2.ServerIdentity.Public, router1.ServerIdentity.Address)
+	_, err = router2.host.Connect(fake)
+	require.NotNil(t, err)
+
+	// Claiming another identity on an authenticated connection fails
+	c, err := router2.host.Connect(router1.ServerIdentity)
+	require.Nil(t, err)
+	other := NewTestServerIdentity(router2.ServerIdentity.Address)
+	require.Nil(t, c.Send(other))
+	c.Close()
+
+	// Claiming the own key with a foreign ID fails as well
+	c, err = router2.host.Connect(router1.ServerIdentity)
+	require.Nil(t, err)
+	wrongID := *router2.ServerIdentity
+	wrongID.ID = other.ID
+	require.Nil(t, c.Send(&wrongID))
+	c.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, router1.connection(other.ID))
+
+	// A plain TCP client can't talk to a TLS listener
+	tc, err := NewTCPConn(NewTCPAddress(router1.ServerIdentity.Address.NetworkAddress()))
+	require.Nil(t, err)
+	require.Nil(t, tc.Send(other))
+	_, err = tc.Receive()
+	require.NotNil(t, err)
+	tc.Close()
+	require.Nil(t, router1.connection(other.ID))
+
+	// The identity of the peer is rejected without a valid binding
+	require.NotNil(t, verifyPeer(nil, nil))
+}
@@ -0,0 +1,209 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"mobilehound/v0-log"
+)
+
+// A trace is a sequence of records, one for every received Envelope. Each
+// record is prefixed by its length as a Size, and contains:
+//   * the time of reception in nanoseconds since the epoch, as an int64
+//   * the length of the sender's marshaled ServerIdentity, as a Size
+//   * the sender's ServerIdentity, marshaled
+//   * the MessageTypeID of the message
+//   * the message, marshaled
+// Integers are written in the same byte order as the rest of the network
+// library.
+
+// TraceRecord is one received message of a trace.
+type TraceRecord struct {
+	// Time is when the message has been received.
+	Time time.Time
+	// ServerIdentity is the sender of the message.
+	ServerIdentity *ServerIdentity
+	// MsgType is the type of the message.
+	MsgType MessageTypeID
+	// Msg is the message as returned by Marshal.
+	Msg []byte
+}
+
+// Envelope returns the message of the record as it has been received.
+func (tr *TraceRecord) Envelope() (*Envelope, error) {
+	typ, msg, err := Unmarshal(tr.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if typ != tr.MsgType {
+		return nil, errors.New("Message type of the record doesn't match the message")
+	}
+	return &Envelope{
+		ServerIdentity: tr.ServerIdentity,
+		MsgType:        typ,
+		Msg:            msg,
+	}, nil
+}
+
+// TraceWriter writes received messages to a trace. It can be used by many go
+// routines at the same time.
+type TraceWriter struct {
+	w io.Writer
+	sync.Mutex
+}
+
+// NewTraceWriter returns a TraceWriter writing the trace to w.
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{w: w}
+}
+
+// Record writes env to the trace, with the current time.
+func (t *TraceWriter) Record(env *Envelope) error {
+	si, err := Marshal(env.ServerIdentity)
+	if err != nil {
+		return err
+	}
+	msg, err := Marshal(env.Msg)
+	if err != nil {
+		return err
+	}
+
+	b := new(bytes.Buffer)
+	for _, v := range []interface{}{time.Now().UnixNano(), Size(len(si)), si, env.MsgType, msg} {
+		if err := binary.Write(b, globalOrder, v); err != nil {
+			return err
+		}
+	}
+	t.Lock()
+	defer t.Unlock()
+	if err := binary.Write(t.w, globalOrder, Size(b.Len())); err != nil {
+		return err
+	}
+	_, err = t.w.Write(b.Bytes())
+	return err
+}
+
+// TraceReader reads the records of a trace.
+type TraceReader struct {
+	r *bufio.Reader
+}
+
+// NewTraceReader returns a TraceReader reading the trace from r.
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{bufio.NewReader(r)}
+}
+
+// Next returns the next record of the trace, or io.EOF at the end of the
+// trace.
+func (t *TraceReader) Next() (*TraceRecord, error) {
+	var size Size
+	if err := binary.Read(t.r, globalOrder, &size); err != nil {
+		return nil, err
+	}
+	if size > MaxPacketSize {
+		return nil, errors.New("Trace record too big")
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(t.r, buf); err != nil {
+		return nil, errors.New("Truncated trace record: " + err.Error())
+	}
+
+	b := bytes.NewBuffer(buf)
+	var nanos int64
+	var siSize Size
+	if err := binary.Read(b, globalOrder, &nanos); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(b, globalOrder, &siSize); err != nil {
+		return nil, err
+	}
+	if int(siSize) > b.Len() {
+		return nil, errors.New("Invalid trace record")
+	}
+	typ, si, err := Unmarshal(b.Next(int(siSize)))
+	if err != nil {
+		return nil, err
+	}
+	if typ != ServerIdentityType {
+		return nil, errors.New("Trace record without ServerIdentity")
+	}
+	tr := &TraceRecord{
+		Time:           time.Unix(0, nanos),
+		ServerIdentity: si.(*ServerIdentity),
+	}
+	if err := binary.Read(b, globalOrder, &tr.MsgType); err != nil {
+		return nil, err
+	}
+	tr.Msg = b.Bytes()
+	return tr, nil
+}
+
+// RecordingDispatcher writes every Envelope to a trace before passing it to
+// the underlying Dispatcher. As the Router dispatches all messages it
+// receives, it records the traffic of all kinds of connections.
+type RecordingDispatcher struct {
+	Dispatcher
+	trace *TraceWriter
+}
+
+// NewRecordingDispatcher returns a RecordingDispatcher recording to trace
+// the messages dispatched by d.
+func NewRecordingDispatcher(d Dispatcher, trace *TraceWriter) *RecordingDispatcher {
+	return &RecordingDispatcher{
+		Dispatcher: d,
+		trace:      trace,
+	}
+}
+
+// Dispatch records packet and dispatches it. A failure to record doesn't
+// keep the packet from being dispatched.
+func (d *RecordingDispatcher) Dispatch(packet *Envelope) error {
+	if err := d.trace.Record(packet); err != nil {
+		log.Error("Couldn't record packet:", err)
+	}
+	return d.Dispatcher.Dispatch(packet)
+}
+
+// Record makes the router write all messages it dispatches to trace. The
+// processors already registered are kept. It must be called before the
+// router is started.
+func (r *Router) Record(trace *TraceWriter) {
+	r.Dispatcher = NewRecordingDispatcher(r.Dispatcher, trace)
+}
+
+// RecordingConn writes every Envelope it receives to a trace. The
+// ServerIdentity of the sender must be known beforehand, as the Envelopes
+// returned by a Conn don't have it.
+type RecordingConn struct {
+	Conn
+	remote *ServerIdentity
+	trace  *TraceWriter
+}
+
+// NewRecordingConn returns a RecordingConn recording to trace the messages
+// received on c from remote.
+func NewRecordingConn(c Conn, remote *ServerIdentity, trace *TraceWriter) *RecordingConn {
+	return &RecordingConn{
+		Conn:   c,
+		remote: remote,
+		trace:  trace,
+	}
+}
+
+// Receive returns the next message of the connection and records it.
+func (c *RecordingConn) Receive() (*Envelope, error) {
+	env, err := c.Conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+	env.ServerIdentity = c.remote
+	if err := c.trace.Record(env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,64 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterRecord(t *testing.T) {
+	testRouterRecord(t, NewTestRouterTCP, 2060)
+	testRouterRecord(t, NewTestRouterLocal, 2062)
+}
+
+func testRouterRecord(t *testing.T, fac func(port int) (*Router, error), port int) {
+	router1, err := fac(port)
+	require.Nil(t, err)
+	router2, err := fac(port + 1)
+	require.Nil(t, err)
+	var trace bytes.Buffer
+	router2.Record(NewTraceWriter(&trace))
+	proc := newSimpleMessageProc(t)
+	router2.RegisterProcessor(proc, SimpleMessageType)
+	go router1.Start()
+	go router2.Start()
+
+	for i := 0; i < 3; i++ {
+		require.Nil(t, router1.Send(router2.ServerIdentity, &SimpleMessage{i}))
+		require.Equal(t, i, (<-proc.relay).I)
+	}
+	require.Nil(t, router1.Stop())
+	require.Nil(t, router2.Stop())
+
+	r := NewTraceReader(&trace)
+	for i := 0; i < 3; i++ {
+		rec, err := r.Next()
+		require.Nil(t, err)
+		require.Equal(t, SimpleMessageType, rec.MsgType)
+		require.Equal(t, router1.ServerIdentity.ID, rec.ServerIdentity.ID)
+		env, err := rec.Envelope()
+		require.Nil(t, err)
+		require.Equal(t, i, env.Msg.(*SimpleMessage).I)
+	}
+	_, err = r.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestTraceTruncated(t *testing.T) {
+	var trace bytes.Buffer
+	si := NewTestServerIdentity(NewLocalAddress("127.0.0.1:2064"))
+	w := NewTraceWriter(&trace)
+	require.Nil(t, w.Record(&Envelope{
+		ServerIdentity: si,
+		MsgType:        SimpleMessageType,
+		Msg:            &SimpleMessage{1},
+	}))
+	b := trace.Bytes()
+	_, err := NewTraceReader(bytes.NewReader(b[:len(b)-1])).Next()
+	require.NotNil(t, err)
+	rec, err := NewTraceReader(bytes.NewReader(b)).Next()
+	require.Nil(t, err)
+	require.True(t, rec.ServerIdentity.Public.Equal(si.Public))
+}
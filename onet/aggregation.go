@@ -0,0 +1,213 @@
+package onet
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"mobilehound/network"
+)
+
+// AggregationPolicy tells a TreeNodeInstance when to hand the messages of one
+// type it aggregates from its children to the protocol. Without a policy, it
+// waits for all children. A policy is set with
+// TreeNodeInstance.SetAggregation.
+//
+// A batch holds at most one message per child; a second message of the same
+// child is dropped while the batch is waiting. After a batch has been handed
+// to the protocol, the next messages of that type start a new batch under the
+// same policy. Handlers that take a second argument of type []*TreeNode get
+// the children that are missing in the batch.
+//
+// If a batch of an AggregateThreshold or AggregateFrom policy is handed over
+// before all children sent theirs, the next message of every missing child
+// belongs to that batch and is handed over on its own, as soon as it arrives,
+// instead of being added to the next batch. The children missing with such a
+// late message are the ones that still didn't answer for that batch.
+type AggregationPolicy struct {
+	// threshold is the number of messages to wait for, if > 0.
+	threshold int
+	// from is the list of children to wait for, if not nil.
+	from []TreeNodeID
+	// every is the longest time to wait after the first message, if > 0.
+	every time.Duration
+}
+
+// AggregateAll waits for the messages of all children. It is the default.
+func AggregateAll() AggregationPolicy {
+	return AggregationPolicy{}
+}
+
+// AggregateThreshold waits for the messages of k distinct children, or for
+// all children if there are less than k.
+func AggregateThreshold(k int) AggregationPolicy {
+	return AggregationPolicy{threshold: k}
+}
+
+// AggregateFrom waits for the messages of the given children, and adds the
+// messages of other children that arrived in the meantime.
+func AggregateFrom(children ...*TreeNode) AggregationPolicy {
+	ids := make([]TreeNodeID, len(children))
+	for i, c := range children {
+		ids[i] = c.ID
+	}
+	return AggregationPolicy{from: ids}
+}
+
+// AggregateEvery hands over the received messages at the latest d after the
+// first one of a batch arrived, or as soon as all children sent theirs.
+func AggregateEvery(d time.Duration) AggregationPolicy {
+	return AggregationPolicy{every: d}
+}
+
+// aggregateFlush is queued by the timer of an AggregateEvery policy.
+type aggregateFlush struct {
+	msgType network.MessageTypeID
+	// batch is the batch the timer has been started for.
+	batch int
+}
+
+// aggregateFlushID marks the message queued by an AggregateEvery policy. It
+// is only used locally and never registered.
+var aggregateFlushID = network.MessageTypeID(uuid.NewV5(uuid.NamespaceURL,
+	network.NamespaceURL+"onet/aggregateFlush"))
+
+// SetAggregation sets the policy for the messages of the type of msg. The
+// channel or handler of that type must be registered before and take a
+// slice of messages.
+func (n *TreeNodeInstance) SetAggregation(msg interface{}, p AggregationPolicy) error {
+	mt := network.MessageType(msg)
+	if mt == network.ErrorType {
+		return errors.New("Message type not registered: " + reflect.TypeOf(msg).String())
+	}
+	if !n.hasFlag(mt, AggregateMessages) {
+		return errors.New("Messages of type " + reflect.TypeOf(msg).String() + " are not aggregated")
+	}
+	n.aggregation[mt] = p
+	delete(n.late, mt)
+	return nil
+}
+
+// batchReady returns true if the messages in msgs of type mt are to be
+// handed over. msgs holds at most one message per child.
+func (n *TreeNodeInstance) batchReady(mt network.MessageTypeID, msgs []*ProtocolMsg) bool {
+	children := len(n.Children())
+	if len(msgs) >= children {
+		return true
+	}
+	p := n.aggregation[mt]
+	switch {
+	case p.threshold > 0:
+		return len(msgs) >= p.threshold
+	case p.from != nil:
+		for _, id := range p.from {
+			if !hasMsgFrom(msgs, id) {
+				return false
+			}
+		}
+		return true
+	case p.every > 0:
+		if len(msgs) == 1 {
+			n.startFlush(mt, p.every)
+		}
+	}
+	return false
+}
+
+// startFlush starts the timer that hands over the current batch of type mt
+// after d. The timer is stopped when the batch ends or the node is closed.
+func (n *TreeNodeInstance) startFlush(mt network.MessageTypeID, d time.Duration) {
+	flush := &aggregateFlush{mt, n.batches[mt]}
+	n.ctxMut.Lock()
+	defer n.ctxMut.Unlock()
+	if t := n.flushTimers[mt]; t != nil {
+		t.Stop()
+	}
+	n.flushTimers[mt] = time.AfterFunc(d, func() {
+		n.ProcessProtocolMsg(&ProtocolMsg{
+			MsgType: aggregateFlushID,
+			Msg:     flush,
+		})
+	})
+}
+
+// handleAggregateFlush hands over the batch the flush has been started for,
+// if it is still waiting.
+func (n *TreeNodeInstance) handleAggregateFlush(flush *aggregateFlush) error {
+	msgs := n.msgQueue[flush.msgType]
+	if n.batches[flush.msgType] != flush.batch || len(msgs) == 0 {
+		return nil
+	}
+	n.endBatch(flush.msgType)
+	return n.dispatchBatch(flush.msgType, msgs)
+}
+
+// markLate remembers the children missing in msgs if the batch has been
+// handed over by a threshold or from policy, so that aggregate passes on
+// their late messages one by one.
+func (n *TreeNodeInstance) markLate(mt network.MessageTypeID, msgs []*ProtocolMsg) {
+	p := n.aggregation[mt]
+	if p.threshold <= 0 && p.from == nil {
+		return
+	}
+	for _, c := range n.missingChildren(msgs) {
+		if n.late[mt] == nil {
+			n.late[mt] = make(map[TreeNodeID]bool)
+		}
+		n.late[mt][c.ID] = true
+	}
+}
+
+// endBatch removes the waiting messages of type mt and starts a new batch.
+func (n *TreeNodeInstance) endBatch(mt network.MessageTypeID) {
+	delete(n.msgQueue, mt)
+	n.batches[mt]++
+	n.ctxMut.Lock()
+	if t := n.flushTimers[mt]; t != nil {
+		t.Stop()
+		delete(n.flushTimers, mt)
+	}
+	n.ctxMut.Unlock()
+}
+
+// dispatchBatch hands msgs to the channel or handler of mt.
+func (n *TreeNodeInstance) dispatchBatch(mt network.MessageTypeID, msgs []*ProtocolMsg) error {
+	if n.channels[mt] != nil {
+		return n.dispatchChannel(msgs)
+	}
+	return n.dispatchHandler(msgs, n.missingChildren(msgs))
+}
+
+// missingChildren returns the children that didn't send any of msgs.
+func (n *TreeNodeInstance) missingChildren(msgs []*ProtocolMsg) []*TreeNode {
+	missing := []*TreeNode{}
+	for _, c := range n.Children() {
+		if !hasMsgFrom(msgs, c.ID) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// lateChildren returns the children whose message of type mt still belongs
+// to a batch that has already been handed over.
+func (n *TreeNodeInstance) lateChildren(mt network.MessageTypeID) []*TreeNode {
+	late := []*TreeNode{}
+	for _, c := range n.Children() {
+		if n.late[mt][c.ID] {
+			late = append(late, c)
+		}
+	}
+	return late
+}
+
+// hasMsgFrom returns true if one of msgs comes from the TreeNode id.
+func hasMsgFrom(msgs []*ProtocolMsg, id TreeNodeID) bool {
+	for _, m := range msgs {
+		if m.From != nil && m.From.TreeNodeID.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
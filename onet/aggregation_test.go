@@ -0,0 +1,190 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"mobilehound/log"
+)
+
+type aggregateBatch struct {
+	from    []*TreeNode
+	missing []*TreeNode
+}
+
+// newAggregateRoot returns the root of a tree with four children, with a
+// handler aggregating deadlineReplyMsgs into batches.
+func newAggregateRoot(t *testing.T, local *LocalTest) (*TreeNodeInstance, chan aggregateBatch) {
+	servers := local.GenServers(5)
+	roster := local.GenRosterFromHost(servers...)
+	tree := roster.GenerateNaryTree(4)
+	local.Trees[tree.ID] = tree
+	servers[0].overlay.RegisterRoster(roster)
+	servers[0].overlay.RegisterTree(tree)
+	require.Equal(t, 4, len(tree.Root.Children))
+
+	root, err := local.NewTreeNodeInstance(tree.Root, spawnName)
+	log.ErrFatal(err)
+	batches := make(chan aggregateBatch, 10)
+	log.ErrFatal(root.RegisterHandler(func(msgs []deadlineReplyMsg, missing []*TreeNode) error {
+		var b aggregateBatch
+		for _, m := range msgs {
+			b.from = append(b.from, m.TreeNode)
+		}
+		b.missing = missing
+		batches <- b
+		return nil
+	}))
+	return root, batches
+}
+
+// replyFrom makes child send a DeadlineReply to root.
+func replyFrom(t *testing.T, root *TreeNodeInstance, child *TreeNode) {
+	root.ProcessProtocolMsg(&ProtocolMsg{
+		MsgType: deadlineReplyType,
+		From:    &Token{TreeNodeID: child.ID},
+		Msg:     &DeadlineReply{child.RosterIndex},
+	})
+}
+
+// requireNoBatch fails if a batch is handed over shortly.
+func requireNoBatch(t *testing.T, batches chan aggregateBatch) {
+	select {
+	case <-batches:
+		t.Fatal("Batch handed over too early")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAggregateThreshold(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, batches := newAggregateRoot(t, local)
+	children := root.Children()
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateThreshold(3)))
+
+	replyFrom(t, root, children[0])
+	replyFrom(t, root, children[2])
+	requireNoBatch(t, batches)
+	replyFrom(t, root, children[3])
+	b := <-batches
+	require.Equal(t, []*TreeNode{children[0], children[2], children[3]}, b.from)
+	require.Equal(t, []*TreeNode{children[1]}, b.missing)
+
+	// A late message is handed over on its own
+	replyFrom(t, root, children[1])
+	b = <-batches
+	require.Equal(t, []*TreeNode{children[1]}, b.from)
+	require.Equal(t, 0, len(b.missing))
+
+	// The next messages start a new batch
+	replyFrom(t, root, children[1])
+	replyFrom(t, root, children[2])
+	requireNoBatch(t, batches)
+	replyFrom(t, root, children[0])
+	b = <-batches
+	require.Equal(t, []*TreeNode{children[1], children[2], children[0]}, b.from)
+}
+
+func TestAggregateThresholdDistinct(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, batches := newAggregateRoot(t, local)
+	children := root.Children()
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateThreshold(2)))
+
+	// A child sending twice only counts once
+	replyFrom(t, root, children[0])
+	replyFrom(t, root, children[0])
+	requireNoBatch(t, batches)
+	replyFrom(t, root, children[1])
+	b := <-batches
+	require.Equal(t, []*TreeNode{children[0], children[1]}, b.from)
+}
+
+func TestAggregateLate(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, batches := newAggregateRoot(t, local)
+	children := root.Children()
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateThreshold(2)))
+
+	replyFrom(t, root, children[0])
+	replyFrom(t, root, children[1])
+	b := <-batches
+	require.Equal(t, []*TreeNode{children[2], children[3]}, b.missing)
+
+	// Late messages only miss the children that still didn't answer
+	replyFrom(t, root, children[3])
+	b = <-batches
+	require.Equal(t, []*TreeNode{children[3]}, b.from)
+	require.Equal(t, []*TreeNode{children[2]}, b.missing)
+	replyFrom(t, root, children[2])
+	b = <-batches
+	require.Equal(t, []*TreeNode{children[2]}, b.from)
+	require.Equal(t, 0, len(b.missing))
+}
+
+func TestAggregateFrom(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, batches := newAggregateRoot(t, local)
+	children := root.Children()
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateFrom(children[1], children[3])))
+
+	replyFrom(t, root, children[1])
+	replyFrom(t, root, children[0])
+	requireNoBatch(t, batches)
+	replyFrom(t, root, children[3])
+	b := <-batches
+	require.Equal(t, 3, len(b.from))
+	require.Equal(t, []*TreeNode{children[2]}, b.missing)
+
+	replyFrom(t, root, children[2])
+	b = <-batches
+	require.Equal(t, []*TreeNode{children[2]}, b.from)
+}
+
+func TestAggregateEvery(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, batches := newAggregateRoot(t, local)
+	children := root.Children()
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateEvery(100*time.Millisecond)))
+
+	replyFrom(t, root, children[0])
+	replyFrom(t, root, children[1])
+	select {
+	case b := <-batches:
+		require.Equal(t, 2, len(b.from))
+		require.Equal(t, []*TreeNode{children[2], children[3]}, b.missing)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Batch not flushed")
+	}
+
+	// All children in: no need to wait
+	for _, c := range children {
+		replyFrom(t, root, c)
+	}
+	b := <-batches
+	require.Equal(t, 0, len(b.missing))
+
+	// Closing the node stops the timer
+	log.ErrFatal(root.SetAggregation(&DeadlineReply{}, AggregateEvery(time.Hour)))
+	replyFrom(t, root, children[0])
+	requireNoBatch(t, batches)
+	root.ctxMut.Lock()
+	timer := root.flushTimers[deadlineReplyType]
+	root.ctxMut.Unlock()
+	require.NotNil(t, timer)
+	root.closeDispatch()
+	require.False(t, timer.Stop())
+}
+
+func TestSetAggregationUnknown(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	root, _ := newAggregateRoot(t, local)
+	require.NotNil(t, root.SetAggregation(&spawn{}, AggregateThreshold(1)))
+}
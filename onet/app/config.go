@@ -0,0 +1,158 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"mobilehound/crypto"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-config"
+)
+
+// CothorityConfig is the configuration of a server as stored in its private
+// toml file.
+type CothorityConfig struct {
+	Public       string
+	Private      string
+	Address      network.Address
+	AltAddresses []network.Address `toml:",omitempty"`
+	Description  string
+}
+
+// NewCothorityConfig creates the configuration of a server listening on
+// address with a new keypair.
+func NewCothorityConfig(address network.Address, description string) (*CothorityConfig, error) {
+	if !address.Valid() {
+		return nil, errors.New("Invalid address " + string(address))
+	}
+	kp := config.NewKeyPair(network.Suite)
+	var priv, pub bytes.Buffer
+	if err := crypto.Write64Scalar(network.Suite, &priv, kp.Secret); err != nil {
+		return nil, err
+	}
+	if err := crypto.Write64Point(network.Suite, &pub, kp.Public); err != nil {
+		return nil, err
+	}
+	return &CothorityConfig{
+		Public:      pub.String(),
+		Private:     priv.String(),
+		Address:     address,
+		Description: description,
+	}, nil
+}
+
+// ParseCothority reads the configuration of a server from file.
+func ParseCothority(file string) (*CothorityConfig, error) {
+	hc := &CothorityConfig{}
+	if _, err := toml.DecodeFile(file, hc); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// Save writes the configuration to file, readable only by the user as it
+// holds the private key.
+func (hc *CothorityConfig) Save(file string) error {
+	return writeToml(file, 0600, hc)
+}
+
+// Keys returns the private and public key of the configuration.
+func (hc *CothorityConfig) Keys() (abstract.Scalar, abstract.Point, error) {
+	priv, err := crypto.Read64Scalar(network.Suite, strings.NewReader(hc.Private))
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := crypto.Read64Point(network.Suite, strings.NewReader(hc.Public))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !network.Suite.Point().Mul(nil, priv).Equal(pub) {
+		return nil, nil, errors.New("Public key doesn't match private key")
+	}
+	return priv, pub, nil
+}
+
+// ServerIdentity returns the identity of the server of the configuration.
+func (hc *CothorityConfig) ServerIdentity() (*network.ServerIdentity, error) {
+	_, pub, err := hc.Keys()
+	if err != nil {
+		return nil, err
+	}
+	si := network.NewServerIdentity(pub, hc.Address)
+	si.AltAddresses = hc.AltAddresses
+	return si, nil
+}
+
+// SavePublic writes the public part of the configuration to file as the
+// only entry of a group. The public files of all servers put one after the
+// other form the group file read by ReadGroupToml.
+func (hc *CothorityConfig) SavePublic(file string) error {
+	return writeToml(file, 0644, &onet.RosterToml{
+		List: []*network.ServerIdentityToml{{
+			Public:       hc.Public,
+			Address:      hc.Address,
+			AltAddresses: hc.AltAddresses,
+		}},
+	})
+}
+
+// ReadGroupToml reads a group file holding a RosterToml and returns its
+// Roster.
+func ReadGroupToml(file string) (*onet.Roster, error) {
+	rt := &onet.RosterToml{}
+	if _, err := toml.DecodeFile(file, rt); err != nil {
+		return nil, err
+	}
+	if len(rt.List) == 0 {
+		return nil, errors.New("Empty group file " + file)
+	}
+	list := make([]*network.ServerIdentity, len(rt.List))
+	for i, st := range rt.List {
+		pub, err := crypto.Read64Point(network.Suite, strings.NewReader(st.Public))
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range append([]network.Address{st.Address}, st.AltAddresses...) {
+			if !addr.Valid() {
+				return nil, errors.New("Invalid address " + string(addr))
+			}
+		}
+		list[i] = network.NewServerIdentity(pub, st.Address)
+		list[i].AltAddresses = st.AltAddresses
+	}
+	return onet.NewRoster(list), nil
+}
+
+// writeToml encodes v to file with the given permissions. The file is
+// written to a temporary file in the same directory first and then renamed,
+// so an existing file gets the new permissions, too.
+func writeToml(file string, perm os.FileMode, v interface{}) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"mobilehound/network"
+)
+
+func TestSetupGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conode")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var group []byte
+	var configs []*CothorityConfig
+	for i, addr := range []string{"tcp://127.0.0.1:2300", "tls://127.0.0.1:2302"} {
+		priv := filepath.Join(dir, "private"+strconv.Itoa(i)+".toml")
+		pub := filepath.Join(dir, "public"+strconv.Itoa(i)+".toml")
+		hc, err := Setup(network.Address(addr), "test", priv, pub)
+		require.Nil(t, err)
+		configs = append(configs, hc)
+
+		hc2, err := ParseCothority(priv)
+		require.Nil(t, err)
+		require.Equal(t, hc, hc2)
+		info, err := os.Stat(priv)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		b, err := ioutil.ReadFile(pub)
+		require.Nil(t, err)
+		group = append(group, b...)
+	}
+	groupFile := filepath.Join(dir, "group.toml")
+	require.Nil(t, ioutil.WriteFile(groupFile, group, 0644))
+
+	roster, err := ReadGroupToml(groupFile)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(roster.List))
+	for i, hc := range configs {
+		si, err := hc.ServerIdentity()
+		require.Nil(t, err)
+		require.True(t, si.ID.Equal(roster.List[i].ID))
+	}
+
+	// Only the first server is running
+	server, err := NewServer(configs[0])
+	require.Nil(t, err)
+	go server.Start()
+	defer server.Close()
+	for !server.Listening() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = Ping(roster.List[0], time.Second)
+	require.Nil(t, err)
+	require.NotNil(t, CheckGroup(groupFile, time.Second))
+}
+
+func TestSavePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conode")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	priv := filepath.Join(dir, "private.toml")
+	require.Nil(t, ioutil.WriteFile(priv, []byte("old"), 0644))
+	hc, err := NewCothorityConfig("tcp://127.0.0.1:2306", "")
+	require.Nil(t, err)
+	require.Nil(t, hc.Save(priv))
+	info, err := os.Stat(priv)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	hc2, err := ParseCothority(priv)
+	require.Nil(t, err)
+	require.Equal(t, hc, hc2)
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(files))
+}
+
+func TestKeysMismatch(t *testing.T) {
+	hc1, err := NewCothorityConfig("tcp://127.0.0.1:2304", "")
+	require.Nil(t, err)
+	hc2, err := NewCothorityConfig("tcp://127.0.0.1:2304", "")
+	require.Nil(t, err)
+	hc1.Public = hc2.Public
+	_, _, err = hc1.Keys()
+	require.NotNil(t, err)
+	_, err = NewCothorityConfig("127.0.0.1:2304", "")
+	require.NotNil(t, err)
+}
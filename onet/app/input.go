@@ -0,0 +1,64 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var in = bufio.NewReader(os.Stdin)
+
+// Input prints the arguments and reads a line from the standard input. It
+// returns def if the line is empty.
+func Input(def string, args ...interface{}) string {
+	fmt.Print(args...)
+	fmt.Printf(" [%s]: ", def)
+	str, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return def
+	}
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return def
+	}
+	return str
+}
+
+// InputYN asks a yes/no question and returns def if the answer is empty.
+func InputYN(def bool, args ...interface{}) bool {
+	defStr := "Yn"
+	if !def {
+		defStr = "Ny"
+	}
+	return strings.ToLower(string(Input(defStr, args...)[0])) == "y"
+}
+
+// Copy copies the file src to dst. If dst is a directory, the file is
+// copied into it with the same name.
+func Copy(dst, src string) error {
+	info, err := os.Stat(dst)
+	if err == nil && info.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+	srcInfo, err := fsrc.Stat()
+	if err != nil {
+		return err
+	}
+	fdst, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, srcInfo.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fdst, fsrc); err != nil {
+		fdst.Close()
+		return err
+	}
+	return fdst.Close()
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/onet/status"
+)
+
+// Setup creates a new keypair for a server listening on address. It writes
+// the private configuration to privateFile and the public ServerIdentityToml
+// to publicFile.
+func Setup(address network.Address, description, privateFile, publicFile string) (*CothorityConfig, error) {
+	hc, err := NewCothorityConfig(address, description)
+	if err != nil {
+		return nil, err
+	}
+	if err := hc.Save(privateFile); err != nil {
+		return nil, err
+	}
+	if err := hc.SavePublic(publicFile); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// NewServer returns the server of the configuration, with a router
+// matching the type of its address. All registered services are started
+// with it.
+func NewServer(hc *CothorityConfig) (*onet.Server, error) {
+	priv, _, err := hc.Keys()
+	if err != nil {
+		return nil, err
+	}
+	si, err := hc.ServerIdentity()
+	if err != nil {
+		return nil, err
+	}
+	var r *network.Router
+	switch si.Address.ConnType() {
+	case network.TLS:
+		r, err = network.NewTLSRouter(si, priv)
+	case network.Relay:
+		r, err = network.NewRelayRouter(si, priv)
+	default:
+		r, err = network.NewTCPRouterWithKey(si, priv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return onet.NewServer(r, priv), nil
+}
+
+// StartTimeout is how long RunServer waits for the server to listen.
+var StartTimeout = 10 * time.Second
+
+// RunServer starts the server of the configuration in configFile, with its
+// router, websocket and services. It returns once the process is
+// interrupted and the server is closed.
+func RunServer(configFile string) error {
+	hc, err := ParseCothority(configFile)
+	if err != nil {
+		return err
+	}
+	server, err := NewServer(hc)
+	if err != nil {
+		return err
+	}
+	go server.Start()
+	deadline := time.Now().Add(StartTimeout)
+	for !server.Listening() {
+		if time.Now().After(deadline) {
+			server.Close()
+			return errors.New("Server didn't start listening in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Info("Started server", server.ServerIdentity, "-", hc.Description)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	sig := <-sigs
+	log.Info("Received", sig, "- closing server")
+	return server.Close()
+}
+
+// Ping asks the status service of si for its status and returns the time it
+// took.
+func Ping(si *network.ServerIdentity, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if _, cerr := status.NewClient().RequestContext(ctx, si); cerr != nil {
+		return 0, cerr
+	}
+	return time.Since(start), nil
+}
+
+// CheckGroup pings all servers of the group in groupFile and prints the
+// result. It returns an error if at least one server doesn't answer.
+func CheckGroup(groupFile string, timeout time.Duration) error {
+	roster, err := ReadGroupToml(groupFile)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, si := range roster.List {
+		rtt, err := Ping(si, timeout)
+		if err != nil {
+			log.Info(fmt.Sprintf("%s: %s", si.Address, err))
+			failed++
+			continue
+		}
+		log.Info(fmt.Sprintf("%s: OK (%s)", si.Address, rtt))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d servers didn't answer", failed, len(roster.List))
+	}
+	return nil
+}
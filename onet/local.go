@@ -74,6 +74,20 @@ func NewTCPTest() *LocalTest {
 	return t
 }
 
+// InjectFaults makes the messages between the servers of this LocalTest go
+// through a FaultInjector seeded with seed, and returns it so the test can
+// slow down links, drop messages or partition the servers. It only works in
+// the Local mode and should be called before the servers are used.
+func (l *LocalTest) InjectFaults(seed int64) *network.FaultInjector {
+	if l.mode != Local {
+		log.Fatal("Can only inject faults in local mode")
+		return nil
+	}
+	f := network.NewFaultInjector(seed)
+	l.ctx.SetFaults(f)
+	return f
+}
+
 // StartProtocol takes a name and a tree and will create a
 // new Node with the protocol 'name' running from the tree-root
 func (l *LocalTest) StartProtocol(name string, t *Tree) (ProtocolInstance, error) {
@@ -24,6 +24,21 @@ func TestGenLocalHost(t *testing.T) {
 	}
 }
 
+func TestLocalTestInjectFaults(t *testing.T) {
+	l := NewLocalTest()
+	f := l.InjectFaults(1)
+	servers := l.GenServers(2)
+	defer l.CloseAll()
+
+	a0, a1 := servers[0].Address(), servers[1].Address()
+	f.Partition([]network.Address{a0}, []network.Address{a1})
+	if err := servers[0].Send(servers[1].ServerIdentity, &SimpleMessage{}); err == nil {
+		t.Fatal("Shouldn't connect through a partition")
+	}
+	f.Heal()
+	log.ErrFatal(servers[0].Send(servers[1].ServerIdentity, &SimpleMessage{}))
+}
+
 // This tests the client-connection in the case of a non-garbage-collected
 // client that stays in the service.
 func TestNewTCPTest(t *testing.T) {
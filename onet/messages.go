@@ -121,6 +121,18 @@ func (t *Token) ID() TokenID {
 	return t.cacheID
 }
 
+// TokenKey returns the ID of the destination Token of a ProtocolMsg and the
+// ID of the sender for all other messages. Given to the SetKey-method of a
+// network.PoolDispatcher, it lets different protocol instances run in
+// parallel while each one gets its messages in order.
+func TokenKey(env *network.Envelope) []byte {
+	if pm, ok := env.Msg.(*ProtocolMsg); ok && pm.To != nil {
+		id := pm.To.ID()
+		return id[:]
+	}
+	return network.SenderKey(env)
+}
+
 // Clone returns a new token out of this one
 func (t *Token) Clone() *Token {
 	t2 := *t
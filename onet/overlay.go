@@ -512,6 +512,14 @@ func (o *Overlay) nodeDelete(tok *Token) {
 	o.instancesInfo[tok.ID()] = true
 }
 
+// instancesRunning returns the number of protocol instances that are not
+// done yet.
+func (o *Overlay) instancesRunning() int {
+	o.instancesLock.Lock()
+	defer o.instancesLock.Unlock()
+	return len(o.instances)
+}
+
 func (o *Overlay) suite() abstract.Suite {
 	return o.server.Suite()
 }
@@ -17,6 +17,7 @@ import (
 // with RegisterMessage.
 type ServiceProcessor struct {
 	handlers map[string]serviceHandler
+	streams  map[string]serviceHandler
 	*Context
 }
 
@@ -30,6 +31,7 @@ type serviceHandler struct {
 func NewServiceProcessor(c *Context) *ServiceProcessor {
 	return &ServiceProcessor{
 		handlers: make(map[string]serviceHandler),
+		streams:  make(map[string]serviceHandler),
 		Context:  c,
 	}
 }
@@ -98,6 +100,103 @@ func (p *ServiceProcessor) RegisterHandlers(procs ...interface{}) error {
 	return nil
 }
 
+// RegisterStreamingHandler stores a handler for subscriptions of clients.
+// Like in RegisterHandler, requests to "ws://service_name/struct_name"
+// are forwarded to f, which must be of the following form:
+// func(msg interface{}, stop chan bool)(ret chan interface{}, err ClientError)
+//
+//  * msg is a pointer to a structure to the message sent.
+//  * stop is closed when the client unsubscribes or disconnects.
+//  * ret is a channel of pointers to the structs pushed to the client. The
+//	subscription ends when the handler closes it.
+//  * err is a Client-error and can return nil or a ClientError that holds
+//	an error-id and an error-msg.
+func (p *ServiceProcessor) RegisterStreamingHandler(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func {
+		return errors.New("Input is not a function")
+	}
+	if ft.NumIn() != 2 {
+		return errors.New("Need two arguments: *struct and chan bool")
+	}
+	cr := ft.In(0)
+	if cr.Kind() != reflect.Ptr || cr.Elem().Kind() != reflect.Struct {
+		return errors.New("1st argument must be a pointer to a struct")
+	}
+	if ft.In(1) != reflect.TypeOf((chan bool)(nil)) {
+		return errors.New("2nd argument must be: chan bool")
+	}
+	if ft.NumOut() != 2 {
+		return errors.New("Need 2 return values: chan and ClientError")
+	}
+	ret := ft.Out(0)
+	if ret.Kind() != reflect.Chan || ret.ChanDir()&reflect.RecvDir == 0 {
+		return errors.New("1st return value must be a channel")
+	}
+	if ft.Out(1) != reflect.TypeOf((*ClientError)(nil)).Elem() {
+		return errors.New("2nd return value has to be: ClientError, but is: " +
+			ft.Out(1).String())
+	}
+
+	log.Lvl4("Registering streaming handler", cr.String())
+	pm := strings.Split(cr.Elem().String(), ".")[1]
+	p.streams[pm] = serviceHandler{f, cr.Elem()}
+	return nil
+}
+
+// Streaming returns true if a streaming handler is registered for path. It
+// implements the StreamingService interface.
+func (p *ServiceProcessor) Streaming(path string) bool {
+	_, ok := p.streams[path]
+	return ok
+}
+
+// ProcessClientStream decodes the request of a client, starts the
+// streaming handler of path and encodes every message of the handler. It
+// implements the StreamingService interface.
+func (p *ServiceProcessor) ProcessClientStream(path string, buf []byte, stop chan bool) (chan []byte, ClientError) {
+	mh, ok := p.streams[path]
+	if !ok {
+		return nil, NewClientErrorCode(WebSocketErrorPathNotFound, "Path not found")
+	}
+	msg := reflect.New(mh.msgType)
+	err := protobuf.DecodeWithConstructors(buf, msg.Interface(),
+		network.DefaultConstructors(network.Suite))
+	if err != nil {
+		return nil, NewClientErrorCode(WebSocketErrorProtobufDecode, err.Error())
+	}
+	ret := reflect.ValueOf(mh.handler).Call([]reflect.Value{msg, reflect.ValueOf(stop)})
+	if cerr := ret[1].Interface(); cerr != nil {
+		return nil, cerr.(ClientError)
+	}
+
+	msgs := make(chan []byte)
+	go func() {
+		defer close(msgs)
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: ret[0]},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)},
+		}
+		for {
+			chosen, m, ok := reflect.Select(cases)
+			if chosen == 1 || !ok {
+				return
+			}
+			buf, err := protobuf.Encode(m.Interface())
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			select {
+			case msgs <- buf:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return msgs, nil
+}
+
 // Process implements the Processor interface and dispatches ClientRequest messages.
 func (p *ServiceProcessor) Process(env *network.Envelope) {
 	log.Panic("Cannot handle message.")
@@ -0,0 +1,43 @@
+package onet
+
+import (
+	"io"
+	"time"
+
+	"mobilehound/log"
+	"mobilehound/network"
+)
+
+// Replay feeds the messages of a trace recorded with network.Router.Record to
+// the processors of this server, one after the other and in the order they
+// have been received. If keepTiming is true, it waits between two messages
+// as long as the recording node did.
+//
+// The server should be set up like the recording node, with the same private
+// key and the same Rosters and Trees, so the replayed messages are
+// understood. Messages sent by the server in reaction go through its Router
+// as usual.
+func (c *Server) Replay(trace io.Reader, keepTiming bool) error {
+	r := network.NewTraceReader(trace)
+	var last time.Time
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		env, err := rec.Envelope()
+		if err != nil {
+			return err
+		}
+		if keepTiming && !last.IsZero() {
+			time.Sleep(rec.Time.Sub(last))
+		}
+		last = rec.Time
+		if err := c.Router.Dispatch(env); err != nil {
+			log.Lvl3(c.ServerIdentity, "Error dispatching replayed message:", err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package onet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"mobilehound/network"
+)
+
+func TestServerReplay(t *testing.T) {
+	l := NewLocalTest()
+	servers := l.GenServers(2)
+	defer l.CloseAll()
+
+	var trace bytes.Buffer
+	w := network.NewTraceWriter(&trace)
+	msgType := network.MessageType(&SimpleMessage{})
+	for i := 0; i < 5; i++ {
+		require.Nil(t, w.Record(&network.Envelope{
+			ServerIdentity: servers[0].ServerIdentity,
+			MsgType:        msgType,
+			Msg:            &SimpleMessage{i},
+		}))
+	}
+
+	var got []int
+	servers[1].RegisterProcessorFunc(msgType, func(env *network.Envelope) {
+		require.True(t, env.ServerIdentity.ID.Equal(servers[0].ServerIdentity.ID))
+		got = append(got, env.Msg.(*SimpleMessage).I)
+	})
+	require.Nil(t, servers[1].Replay(&trace, false))
+	require.Equal(t, []int{0, 1, 2, 3, 4}, got)
+}
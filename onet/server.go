@@ -64,9 +64,16 @@ func NewServer(r *network.Router, pkey abstract.Scalar) *Server {
 }
 
 // NewServerTCP returns a new Server out of a private-key and its related public
-// key within the ServerIdentity. The server will use a default TcpRouter as Router.
+// key within the ServerIdentity. The server will use a default TcpRouter as Router,
+// or a TlsRouter if the address of the ServerIdentity is of type TLS.
 func NewServerTCP(e *network.ServerIdentity, pkey abstract.Scalar) *Server {
-	r, err := network.NewTCPRouter(e)
+	var r *network.Router
+	var err error
+	if e.Address.ConnType() == network.TLS {
+		r, err = network.NewTLSRouter(e, pkey)
+	} else {
+		r, err = network.NewTCPRouterWithKey(e, pkey)
+	}
 	log.ErrFatal(err)
 	return NewServer(r, pkey)
 }
@@ -86,6 +93,12 @@ func (c *Server) GetStatus() *Status {
 		"Available_Services": strings.Join(a, ","),
 		"TX_bytes":           strconv.FormatUint(c.Router.Tx(), 10),
 		"RX_bytes":           strconv.FormatUint(c.Router.Rx(), 10),
+		"TX_raw_bytes":       strconv.FormatUint(c.Router.TxRaw(), 10),
+		"RX_raw_bytes":       strconv.FormatUint(c.Router.RxRaw(), 10),
+		"Queued_messages":    strconv.Itoa(c.Router.QueueDepth()),
+		"Dropped_messages":   strconv.FormatUint(c.Router.Dropped(), 10),
+		"Connections":        strconv.Itoa(c.Router.Connections()),
+		"Protocols_running":  strconv.Itoa(c.overlay.instancesRunning()),
 		"Uptime":             time.Now().Sub(c.started).String(),
 		"System": fmt.Sprintf("%s/%s/%s", runtime.GOOS, runtime.GOARCH,
 			runtime.Version()),
@@ -42,6 +42,23 @@ type Service interface {
 	network.Processor
 }
 
+// StreamingService is an optional interface of a Service that lets
+// clients subscribe to a stream of messages instead of getting a single
+// reply. The websocket checks with Streaming whether a request starts a
+// subscription and then calls ProcessClientStream instead of
+// ProcessClientRequest.
+type StreamingService interface {
+	// Streaming returns true if requests to handler start a subscription.
+	Streaming(handler string) bool
+	// ProcessClientStream starts the subscription of a client. Every
+	// message sent on the returned channel is pushed to the client, until
+	// the service closes the channel. When the client unsubscribes or
+	// disconnects, stop is closed and the service must stop sending. The
+	// returned ClientError is either nil or any errorCode between 4100
+	// and 4999.
+	ProcessClientStream(handler string, msg []byte, stop chan bool) (chan []byte, ClientError)
+}
+
 // ServiceID is a type to represent a uuid for a Service
 type ServiceID uuid.UUID
 
@@ -0,0 +1,141 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"mobilehound/network"
+	"mobilehound/onet"
+)
+
+func init() {
+	for _, m := range []interface{}{Request{}, Response{}} {
+		network.RegisterMessage(m)
+	}
+}
+
+// DefaultTimeout is how long RosterStatus waits for the servers to reply.
+const DefaultTimeout = 10 * time.Second
+
+// Request asks a server for its status reports.
+type Request struct {
+}
+
+// Response holds the status reports of a server, indexed by the name of
+// the StatusReporter, together with its ServerIdentity.
+type Response struct {
+	Status         map[string]*onet.Status
+	ServerIdentity *network.ServerIdentity
+}
+
+// NodeStatus is one row of the table returned by RosterStatus. If the
+// server didn't reply in time, Reachable is false, Error holds the reason
+// and all other fields besides ServerIdentity are zero.
+type NodeStatus struct {
+	ServerIdentity *network.ServerIdentity
+	Reachable      bool
+	Error          string
+	// Latency is the round-trip time of the status request
+	Latency time.Duration
+	Uptime  time.Duration
+	// Connections is the number of open connections of the server
+	Connections int
+	// Protocols is the number of running protocol instances
+	Protocols int
+	Tx        uint64
+	Rx        uint64
+	// Status holds all reports of the server
+	Status map[string]*onet.Status
+}
+
+// Client is a structure to communicate with the status service.
+type Client struct {
+	*onet.Client
+}
+
+// NewClient instantiates a new status service client.
+func NewClient() *Client {
+	return &Client{Client: onet.NewClient(ServiceName)}
+}
+
+// Request asks a single server for its status reports.
+func (c *Client) Request(si *network.ServerIdentity) (*Response, onet.ClientError) {
+	return c.RequestContext(context.Background(), si)
+}
+
+// RequestContext is Request with a context that can cancel the request.
+func (c *Client) RequestContext(ctx context.Context, si *network.ServerIdentity) (*Response, onet.ClientError) {
+	reply := &Response{}
+	if cerr := c.SendProtobufContext(ctx, si, &Request{}, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// RosterStatus asks all servers of the roster for their status in parallel
+// and returns one row per server, in the order of the roster. Servers that
+// don't reply within timeout are marked as not reachable, so a slow server
+// only shows up in its own row.
+func (c *Client) RosterStatus(roster *onet.Roster, timeout time.Duration) []*NodeStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	table := make([]*NodeStatus, len(roster.List))
+	var wg sync.WaitGroup
+	for i, si := range roster.List {
+		wg.Add(1)
+		go func(i int, si *network.ServerIdentity) {
+			defer wg.Done()
+			start := time.Now()
+			reply, cerr := c.RequestContext(ctx, si)
+			table[i] = newNodeStatus(si, reply, time.Since(start), cerr)
+		}(i, si)
+	}
+	wg.Wait()
+	return table
+}
+
+// newNodeStatus fills in a row of the status table from the reply of a
+// server.
+func newNodeStatus(si *network.ServerIdentity, reply *Response, latency time.Duration, cerr onet.ClientError) *NodeStatus {
+	ns := &NodeStatus{ServerIdentity: si}
+	if cerr != nil {
+		ns.Error = cerr.Error()
+		return ns
+	}
+	ns.Reachable = true
+	ns.Latency = latency
+	ns.Status = reply.Status
+	s, ok := reply.Status["Status"]
+	if !ok || s == nil {
+		return ns
+	}
+	ns.Uptime, _ = time.ParseDuration(s.Field["Uptime"])
+	ns.Connections, _ = strconv.Atoi(s.Field["Connections"])
+	ns.Protocols, _ = strconv.Atoi(s.Field["Protocols_running"])
+	ns.Tx, _ = strconv.ParseUint(s.Field["TX_bytes"], 10, 64)
+	ns.Rx, _ = strconv.ParseUint(s.Field["RX_bytes"], 10, 64)
+	return ns
+}
+
+// Reachable returns a roster of the servers of the table that replied with
+// a latency of at most maxLatency, or of all servers that replied if
+// maxLatency is 0. It returns an error if no server is left.
+func Reachable(table []*NodeStatus, maxLatency time.Duration) (*onet.Roster, error) {
+	var list []*network.ServerIdentity
+	for _, ns := range table {
+		if !ns.Reachable {
+			continue
+		}
+		if maxLatency > 0 && ns.Latency > maxLatency {
+			continue
+		}
+		list = append(list, ns.ServerIdentity)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("No server is reachable")
+	}
+	return onet.NewRoster(list), nil
+}
@@ -0,0 +1,41 @@
+// Package status implements a service returning the status reports of a
+// server, and a client querying the status of all servers of a roster in
+// parallel.
+package status
+
+import (
+	"mobilehound/log"
+	"mobilehound/onet"
+)
+
+// ServiceName is the name under which the status service is registered.
+const ServiceName = "Status"
+
+func init() {
+	_, err := onet.RegisterNewService(ServiceName, newService)
+	log.ErrFatal(err)
+}
+
+// Service answers status requests with the reports of all StatusReporters
+// of its server.
+type Service struct {
+	*onet.ServiceProcessor
+}
+
+func newService(c *onet.Context) onet.Service {
+	s := &Service{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+	}
+	if err := s.RegisterHandler(s.Request); err != nil {
+		log.ErrFatal(err, "Couldn't register messages")
+	}
+	return s
+}
+
+// Request returns the status reports of this server.
+func (s *Service) Request(req *Request) (*Response, onet.ClientError) {
+	return &Response{
+		Status:         s.ReportStatus(),
+		ServerIdentity: s.ServerIdentity(),
+	}, nil
+}
@@ -0,0 +1,101 @@
+package status
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"mobilehound/network"
+	"mobilehound/onet"
+)
+
+func TestServiceStatus(t *testing.T) {
+	local := onet.NewTCPTest()
+	_, roster, _ := local.GenTree(3, false)
+	defer local.CloseAll()
+
+	cl := NewClient()
+	defer cl.Close()
+	reply, cerr := cl.Request(roster.List[0])
+	require.Nil(t, cerr)
+	require.True(t, reply.ServerIdentity.Equal(roster.List[0]))
+	require.NotNil(t, reply.Status["Status"])
+	require.Equal(t, roster.List[0].Address.Port(), reply.Status["Status"].Field["Port"])
+}
+
+func TestRosterStatus(t *testing.T) {
+	local := onet.NewTCPTest()
+	_, roster, _ := local.GenTree(3, false)
+	defer local.CloseAll()
+
+	// A server that is part of the roster but not running.
+	_, pub := onet.PrivPub()
+	down := network.NewServerIdentity(pub, network.NewAddress(network.PlainTCP, "127.0.0.1:2"))
+	list := append(append([]*network.ServerIdentity{}, roster.List...), down)
+	all := onet.NewRoster(list)
+
+	table := NewClient().RosterStatus(all, DefaultTimeout)
+	require.Equal(t, len(list), len(table))
+	for i, ns := range table[:3] {
+		require.True(t, ns.ServerIdentity.Equal(roster.List[i]))
+		require.True(t, ns.Reachable, ns.Error)
+		require.True(t, ns.Latency > 0)
+		require.True(t, ns.Uptime > 0)
+		require.NotNil(t, ns.Status["Status"])
+	}
+	require.False(t, table[3].Reachable)
+	require.NotEqual(t, "", table[3].Error)
+
+	fit, err := Reachable(table, 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(fit.List))
+	_, err = Reachable(table[3:], 0)
+	require.NotNil(t, err)
+}
+
+func TestRosterStatusTimeout(t *testing.T) {
+	local := onet.NewTCPTest()
+	_, roster, _ := local.GenTree(2, false)
+	defer local.CloseAll()
+
+	table := NewClient().RosterStatus(roster, 0)
+	require.Equal(t, 2, len(table))
+	for _, ns := range table {
+		require.NotNil(t, ns.ServerIdentity)
+		require.False(t, ns.Reachable)
+		require.NotEqual(t, "", ns.Error)
+	}
+
+	// A server that accepts connections on its websocket port but never
+	// answers only shows up in its own row.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	_, pub := onet.PrivPub()
+	slow := network.NewServerIdentity(pub, network.NewAddress(network.PlainTCP,
+		"127.0.0.1:"+strconv.Itoa(port-1)))
+	all := onet.NewRoster(append(append([]*network.ServerIdentity{}, roster.List...), slow))
+
+	timeout := 500 * time.Millisecond
+	start := time.Now()
+	table = NewClient().RosterStatus(all, timeout)
+	require.True(t, time.Since(start) < 2*timeout)
+	require.Equal(t, 3, len(table))
+	for _, ns := range table[:2] {
+		require.True(t, ns.Reachable, ns.Error)
+	}
+	require.False(t, table[2].Reachable)
+	require.NotEqual(t, "", table[2].Error)
+}
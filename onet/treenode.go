@@ -1,13 +1,16 @@
 package onet
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"strings"
 
+	"github.com/satori/go.uuid"
 	"mobilehound/v0-abstract"
 	"mobilehound/log"
 	"mobilehound/network"
@@ -36,6 +39,13 @@ type TreeNodeInstance struct {
 	// aggregate messages in order to dispatch them at once in the protocol
 	// instance
 	msgQueue map[network.MessageTypeID][]*ProtocolMsg
+	// aggregation holds the policies set with SetAggregation
+	aggregation map[network.MessageTypeID]AggregationPolicy
+	// batches counts the batches handed over per message type
+	batches map[network.MessageTypeID]int
+	// late holds per message type the children that were missing in a
+	// batch handed over by a threshold or from policy
+	late map[network.MessageTypeID]map[TreeNodeID]bool
 	// done callback
 	onDoneCallback func() bool
 	// queue holding msgs
@@ -54,7 +64,38 @@ type TreeNodeInstance struct {
 	config    *GenericConfig
 	sentTo    map[TreeNodeID]bool
 	configMut sync.Mutex
-}
+
+	// ctx is cancelled when the node is done, aborted or its deadline is
+	// over. baseCtx is only cancelled when the node is done or aborted.
+	ctx            context.Context
+	baseCtx        context.Context
+	cancel         context.CancelFunc
+	cancelDeadline context.CancelFunc
+	// timer fires at the deadline set with SetDeadline.
+	timer *time.Timer
+	// flushTimers hold the running timers of AggregateEvery policies.
+	flushTimers map[network.MessageTypeID]*time.Timer
+	// onTimeout is called once the deadline is over.
+	onTimeout func()
+	// timedOut is set once the deadline is over. From then on, aggregated
+	// messages are dispatched as they come.
+	timedOut bool
+	ctxMut   sync.Mutex
+}
+
+// protocolAbort is sent down the tree when a node aborts the protocol. Nodes
+// only follow it if it comes from their parent.
+type protocolAbort struct {
+	Reason string
+}
+
+// protocolAbortID is the message type of protocolAbort.
+var protocolAbortID = network.RegisterMessage(protocolAbort{})
+
+// timeoutMsgID marks the message queued by the timer of a node. It is only
+// used locally and never registered.
+var timeoutMsgID = network.MessageTypeID(uuid.NewV5(uuid.NamespaceURL,
+	network.NamespaceURL+"onet/timeout"))
 
 const (
 	// AggregateMessages (if set) tells to aggregate messages from all children
@@ -77,12 +118,18 @@ func newTreeNodeInstance(o *Overlay, tok *Token, tn *TreeNode, io MessageProxy)
 		handlers:             make(map[network.MessageTypeID]interface{}),
 		messageTypeFlags:     make(map[network.MessageTypeID]uint32),
 		msgQueue:             make(map[network.MessageTypeID][]*ProtocolMsg),
+		aggregation:          make(map[network.MessageTypeID]AggregationPolicy),
+		batches:              make(map[network.MessageTypeID]int),
+		late:                 make(map[network.MessageTypeID]map[TreeNodeID]bool),
+		flushTimers:          make(map[network.MessageTypeID]*time.Timer),
 		treeNode:             tn,
 		msgDispatchQueue:     make([]*ProtocolMsg, 0, 1),
 		msgDispatchQueueWait: make(chan bool, 1),
 		protoIO:              io,
 		sentTo:               make(map[TreeNodeID]bool),
 	}
+	n.baseCtx, n.cancel = context.WithCancel(context.Background())
+	n.ctx = n.baseCtx
 	go n.dispatchMsgReader()
 	return n
 }
@@ -239,11 +286,18 @@ func (n *TreeNodeInstance) RegisterHandler(c interface{}) error {
 	if cr.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
 		return errors.New("return-type of message-handler needs to be error")
 	}
+	if cr.NumIn() < 1 || cr.NumIn() > 2 {
+		return errors.New("Need one or two arguments")
+	}
 	ci := cr.In(0)
 	if ci.Kind() == reflect.Slice {
 		flags += AggregateMessages
 		ci = ci.Elem()
 	}
+	if cr.NumIn() == 2 && (flags&AggregateMessages == 0 ||
+		cr.In(1) != reflect.TypeOf([]*TreeNode{})) {
+		return errors.New("Second argument is only allowed for aggregated messages and must be []*TreeNode")
+	}
 	if ci.Kind() != reflect.Struct {
 		return errors.New("Input is not a structure")
 	}
@@ -292,6 +346,17 @@ func (n *TreeNodeInstance) Shutdown() error {
 // closeDispatch shuts down the go-routine and calls the protocolInstance-shutdown
 func (n *TreeNodeInstance) closeDispatch() error {
 	log.Lvl3("Closing node", n.Info())
+	n.ctxMut.Lock()
+	if n.timer != nil {
+		n.timer.Stop()
+		n.cancelDeadline()
+	}
+	for mt, t := range n.flushTimers {
+		t.Stop()
+		delete(n.flushTimers, mt)
+	}
+	n.cancel()
+	n.ctxMut.Unlock()
 	n.msgDispatchQueueMutex.Lock()
 	n.closing = true
 	if len(n.msgDispatchQueueWait) == 0 {
@@ -310,7 +375,9 @@ func (n *TreeNodeInstance) ProtocolName() string {
 	return n.overlay.server.protocols.ProtocolIDToName(n.token.ProtoID)
 }
 
-func (n *TreeNodeInstance) dispatchHandler(msgSlice []*ProtocolMsg) error {
+// dispatchHandler hands msgSlice to the handler of its type. Handlers of
+// aggregated messages which take a second argument get missing.
+func (n *TreeNodeInstance) dispatchHandler(msgSlice []*ProtocolMsg, missing []*TreeNode) error {
 	mt := msgSlice[0].MsgType
 	to := reflect.TypeOf(n.handlers[mt]).In(0)
 	f := reflect.ValueOf(n.handlers[mt])
@@ -321,7 +388,11 @@ func (n *TreeNodeInstance) dispatchHandler(msgSlice []*ProtocolMsg) error {
 			msgs.Index(i).Set(n.reflectCreate(to.Elem(), msg))
 		}
 		log.Lvl4("Dispatching aggregation to", n.ServerIdentity().Address)
-		errV = f.Call([]reflect.Value{msgs})[0]
+		args := []reflect.Value{msgs}
+		if f.Type().NumIn() == 2 {
+			args = append(args, reflect.ValueOf(missing))
+		}
+		errV = f.Call(args)[0]
 	} else {
 		for _, msg := range msgSlice {
 			if errV.IsValid() && !errV.IsNil() {
@@ -435,10 +506,22 @@ func (n *TreeNodeInstance) dispatchMsgReader() {
 
 // dispatchMsgToProtocol will dispatch this onet.Data to the right instance
 func (n *TreeNodeInstance) dispatchMsgToProtocol(onetMsg *ProtocolMsg) error {
+	switch onetMsg.MsgType {
+	case timeoutMsgID:
+		return n.handleTimeout()
+	case protocolAbortID:
+		if !n.abortAllowed(onetMsg) {
+			return fmt.Errorf("ignoring abort from %v which is not the parent", onetMsg.From)
+		}
+		return n.handleAbort(onetMsg.Msg.(*protocolAbort).Reason)
+	case aggregateFlushID:
+		return n.handleAggregateFlush(onetMsg.Msg.(*aggregateFlush))
+	}
+
 	// if message comes from parent, dispatch directly
 	// if messages come from children we must aggregate them
 	// if we still need to wait for additional messages, we return
-	msgType, msgs, done := n.aggregate(onetMsg)
+	msgType, msgs, missing, done := n.aggregate(onetMsg)
 	if !done {
 		log.Lvl3(n.Name(), "Not done aggregating children msgs")
 		return nil
@@ -452,7 +535,7 @@ func (n *TreeNodeInstance) dispatchMsgToProtocol(onetMsg *ProtocolMsg) error {
 		err = n.dispatchChannel(msgs)
 	case n.handlers[msgType] != nil:
 		log.Lvl4("Dispatching to handler", n.ServerIdentity().Address)
-		err = n.dispatchHandler(msgs)
+		err = n.dispatchHandler(msgs, missing)
 	default:
 		return fmt.Errorf("message-type not handled by the protocol: %s", reflect.TypeOf(onetMsg.Msg))
 	}
@@ -477,31 +560,41 @@ func (n *TreeNodeInstance) hasFlag(mt network.MessageTypeID, f uint32) bool {
 // aggregate store the message for a protocol instance such that a protocol
 // instances will get all its children messages at once.
 // node is the node the host is representing in this Tree, and onetMsg is the
-// message being analyzed.
-func (n *TreeNodeInstance) aggregate(onetMsg *ProtocolMsg) (network.MessageTypeID, []*ProtocolMsg, bool) {
+// message being analyzed. Once the messages are to be handed over, it returns
+// them together with the children that are missing in their batch.
+func (n *TreeNodeInstance) aggregate(onetMsg *ProtocolMsg) (network.MessageTypeID, []*ProtocolMsg, []*TreeNode, bool) {
 	mt := onetMsg.MsgType
+	msgs := []*ProtocolMsg{onetMsg}
 	fromParent := !n.IsRoot() && onetMsg.From.TreeNodeID.Equal(n.Parent().ID)
-	if fromParent || !n.hasFlag(mt, AggregateMessages) {
-		return mt, []*ProtocolMsg{onetMsg}, true
+	if fromParent || !n.hasFlag(mt, AggregateMessages) || n.isTimedOut() {
+		return mt, msgs, n.missingChildren(msgs), true
+	}
+	from := onetMsg.From.TreeNodeID
+	if n.late[mt][from] {
+		// the batch this message belongs to has already been handed over
+		delete(n.late[mt], from)
+		return mt, msgs, n.lateChildren(mt), true
+	}
+	if hasMsgFrom(n.msgQueue[mt], from) {
+		log.Lvl2(n.Name(), "dropping second message of type", mt, "from", from, "in the same batch")
+		return mt, nil, nil, false
 	}
 	// store the msg according to its type
 	if _, ok := n.msgQueue[mt]; !ok {
 		n.msgQueue[mt] = make([]*ProtocolMsg, 0)
 	}
-	msgs := append(n.msgQueue[mt], onetMsg)
+	msgs = append(n.msgQueue[mt], onetMsg)
 	n.msgQueue[mt] = msgs
 	log.Lvl4(n.ServerIdentity().Address, "received", len(msgs), "of", len(n.Children()), "messages")
 
-	// do we have everything yet or no
-	// get the node this host is in this tree
-	// OK we have all the children messages
-	if len(msgs) == len(n.Children()) {
-		// erase
-		delete(n.msgQueue, mt)
-		return mt, msgs, true
+	// do we have everything the aggregation policy waits for?
+	if n.batchReady(mt, msgs) {
+		n.endBatch(mt)
+		n.markLate(mt, msgs)
+		return mt, msgs, n.missingChildren(msgs), true
 	}
 	// no we still have to wait!
-	return mt, nil, false
+	return mt, nil, nil, false
 }
 
 // startProtocol calls the Start() on the underlying protocol which in turn will
@@ -524,6 +617,115 @@ func (n *TreeNodeInstance) Done() {
 	n.overlay.nodeDone(n.token)
 }
 
+// Context returns a context that is cancelled when the node is done, when the
+// protocol is aborted or when the deadline set with SetDeadline is over.
+// Protocols can use it to stop waiting on their channels.
+func (n *TreeNodeInstance) Context() context.Context {
+	n.ctxMut.Lock()
+	defer n.ctxMut.Unlock()
+	return n.ctx
+}
+
+// SetTimeout is SetDeadline with a deadline of d from now.
+func (n *TreeNodeInstance) SetTimeout(d time.Duration) {
+	n.SetDeadline(time.Now().Add(d))
+}
+
+// SetDeadline sets the time until which the node waits for the messages of
+// its children. Once it is over:
+//  - the messages being aggregated are dispatched to their channel or
+//    handler, even if not all children sent theirs
+//  - messages arriving later are dispatched one by one
+//  - the function registered with OnTimeout is called
+//  - the Context of the node is cancelled
+// A later call replaces the deadline, unless it is already over.
+func (n *TreeNodeInstance) SetDeadline(t time.Time) {
+	n.ctxMut.Lock()
+	defer n.ctxMut.Unlock()
+	if n.timedOut {
+		return
+	}
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+	// The context of an earlier deadline is released with baseCtx.
+	n.ctx, n.cancelDeadline = context.WithDeadline(n.baseCtx, t)
+	n.timer = time.AfterFunc(t.Sub(time.Now()), func() {
+		n.ProcessProtocolMsg(&ProtocolMsg{MsgType: timeoutMsgID})
+	})
+}
+
+// OnTimeout registers fn to be called once the deadline of the node is
+// over. It is called after the partial aggregates have been dispatched, from
+// the same go-routine as the handlers.
+func (n *TreeNodeInstance) OnTimeout(fn func()) {
+	n.ctxMut.Lock()
+	defer n.ctxMut.Unlock()
+	n.onTimeout = fn
+}
+
+// isTimedOut returns true once the deadline is over.
+func (n *TreeNodeInstance) isTimedOut() bool {
+	n.ctxMut.Lock()
+	defer n.ctxMut.Unlock()
+	return n.timedOut
+}
+
+// handleTimeout dispatches the partial aggregates and calls the timeout
+// handler.
+func (n *TreeNodeInstance) handleTimeout() error {
+	n.ctxMut.Lock()
+	n.timedOut = true
+	fn := n.onTimeout
+	n.ctxMut.Unlock()
+	log.Lvl3(n.Info(), "timed out")
+
+	var errs []collectedErrors
+	for mt, msgs := range n.msgQueue {
+		n.endBatch(mt)
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := n.dispatchBatch(mt, msgs); err != nil {
+			errs = append(errs, collectedErrors{mt.String(), err})
+		}
+	}
+	if fn != nil {
+		fn()
+	}
+	return collectErrors("Error while dispatching partial %s: %s\n", errs)
+}
+
+// Abort stops the protocol on this node and all nodes below it: it sends the
+// reason to the children, which do the same, and every node shuts down its
+// protocol instance, cancels its Context and frees its resources, without
+// calling the OnDoneCallback. Called on the root, it aborts the whole
+// protocol.
+func (n *TreeNodeInstance) Abort(reason string) {
+	n.ProcessProtocolMsg(&ProtocolMsg{
+		MsgType: protocolAbortID,
+		Msg:     &protocolAbort{reason},
+	})
+}
+
+// abortAllowed returns true if the abort in onetMsg is to be followed: only
+// the parent may abort a node. An Abort called on this node has neither a
+// sender nor a ServerIdentity, which messages from the network always have.
+func (n *TreeNodeInstance) abortAllowed(onetMsg *ProtocolMsg) bool {
+	if onetMsg.From == nil {
+		return onetMsg.ServerIdentity == nil
+	}
+	return !n.IsRoot() && onetMsg.From.TreeNodeID.Equal(n.Parent().ID)
+}
+
+// handleAbort passes the abort on to the children and deletes the node.
+func (n *TreeNodeInstance) handleAbort(reason string) error {
+	log.Lvl3(n.Info(), "aborts:", reason)
+	err := n.SendToChildrenInParallel(&protocolAbort{reason})
+	n.overlay.nodeDone(n.token)
+	return err
+}
+
 // OnDoneCallback should be called if we want to control the Done() of the node.
 // It is used by protocols that uses others protocols inside and that want to
 // control when the final Done() should be called.
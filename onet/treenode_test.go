@@ -1,6 +1,7 @@
 package onet
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 
 func init() {
 	GlobalProtocolRegister(spawnName, newSpawnProto)
+	GlobalProtocolRegister(deadlineName, newDeadlineProto)
 }
 
 func TestTreeNodeCreateProtocol(t *testing.T) {
@@ -127,6 +129,149 @@ func TestTreeNodeInstance_RegisterChannel(t *testing.T) {
 	log.ErrFatal(ri.dispatchChannel(msg))
 }
 
+func TestTreeNodeInstanceTimeout(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	deadlineNodes = make(chan *deadlineProto, 10)
+
+	hosts, _, tree := local.GenTree(3, true)
+	pi, err := hosts[0].overlay.CreateProtocol(deadlineName, tree, NilServiceID)
+	log.ErrFatal(err)
+	root := pi.(*deadlineProto)
+	timeout := make(chan bool, 1)
+	root.OnTimeout(func() { timeout <- true })
+	root.SetTimeout(200 * time.Millisecond)
+	log.ErrFatal(root.Start())
+
+	// The silent child keeps the root from aggregating all replies
+	select {
+	case n := <-root.replies:
+		require.Equal(t, 1, n)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Didn't get the partial aggregate")
+	}
+	require.True(t, <-timeout)
+	<-root.Context().Done()
+	require.Equal(t, context.DeadlineExceeded, root.Context().Err())
+}
+
+func TestTreeNodeInstanceAbort(t *testing.T) {
+	local := NewLocalTest()
+	defer local.CloseAll()
+	deadlineNodes = make(chan *deadlineProto, 10)
+
+	hosts, _, tree := local.GenTree(3, true)
+	pi, err := hosts[0].overlay.CreateProtocol(deadlineName, tree, NilServiceID)
+	log.ErrFatal(err)
+	root := pi.(*deadlineProto)
+	log.ErrFatal(root.Start())
+
+	var nodes []*deadlineProto
+	for len(nodes) < 3 {
+		select {
+		case n := <-deadlineNodes:
+			nodes = append(nodes, n)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Not all nodes started")
+		}
+	}
+	// Only the parent may abort a node: neither a sibling nor a remote
+	// sender without a token
+	var child, sibling *deadlineProto
+	for _, n := range nodes {
+		switch n.Index() {
+		case 1:
+			child = n
+		case 2:
+			sibling = n
+		}
+	}
+	child.ProcessProtocolMsg(&ProtocolMsg{
+		MsgType:        protocolAbortID,
+		Msg:            &protocolAbort{"sibling"},
+		From:           sibling.Token(),
+		ServerIdentity: sibling.ServerIdentity(),
+	})
+	child.ProcessProtocolMsg(&ProtocolMsg{
+		MsgType:        protocolAbortID,
+		Msg:            &protocolAbort{"no token"},
+		ServerIdentity: sibling.ServerIdentity(),
+	})
+	select {
+	case <-child.Context().Done():
+		t.Fatal("Node aborted by a node that is not its parent")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	root.Abort("test")
+	for _, n := range nodes {
+		select {
+		case <-n.Context().Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Node not aborted:", n.Info())
+		}
+		require.Equal(t, context.Canceled, n.Context().Err())
+	}
+}
+
+const deadlineName = "Deadline"
+
+// deadlineNodes gets all instances of deadlineProto
+var deadlineNodes chan *deadlineProto
+
+// deadlineProto asks its children for a reply, but the child with index 2
+// never answers.
+type deadlineProto struct {
+	*TreeNodeInstance
+	replies chan int
+}
+
+type DeadlineQuery struct{}
+
+type DeadlineReply struct {
+	Index int
+}
+
+var deadlineReplyType = network.RegisterMessage(&DeadlineReply{})
+
+type deadlineQueryMsg struct {
+	*TreeNode
+	DeadlineQuery
+}
+
+type deadlineReplyMsg struct {
+	*TreeNode
+	DeadlineReply
+}
+
+func newDeadlineProto(tn *TreeNodeInstance) (ProtocolInstance, error) {
+	p := &deadlineProto{
+		TreeNodeInstance: tn,
+		replies:          make(chan int, 1),
+	}
+	if err := p.RegisterHandlers(p.handleQuery, p.handleReplies); err != nil {
+		return nil, err
+	}
+	deadlineNodes <- p
+	return p, nil
+}
+
+func (p *deadlineProto) Start() error {
+	return p.SendToChildren(&DeadlineQuery{})
+}
+
+func (p *deadlineProto) handleQuery(msg deadlineQueryMsg) error {
+	if p.Index() == 2 {
+		return nil
+	}
+	return p.SendToParent(&DeadlineReply{p.Index()})
+}
+
+func (p *deadlineProto) handleReplies(msgs []deadlineReplyMsg) error {
+	p.replies <- len(msgs)
+	return nil
+}
+
 // spawnCh is used to dispatch information from a spawnProto to the test
 var spawnCh = make(chan bool)
 
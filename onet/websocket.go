@@ -1,8 +1,10 @@
 package onet
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 
@@ -31,6 +33,9 @@ type WebSocket struct {
 	mux       *http.ServeMux
 	startstop chan bool
 	started   bool
+	// conns holds the open websockets, which are hijacked from the http
+	// server and therefore not closed by it on stop
+	conns map[*websocket.Conn]bool
 	sync.Mutex
 }
 
@@ -46,6 +51,10 @@ const (
 	WebSocketErrorInvalidErrorCode
 	// WebSocketErrorRead indicates that there has been a problem on reception
 	WebSocketErrorRead
+	// WebSocketErrorNotProcessed indicates that the connection has been
+	// closed before the server processed the request, so it can be sent
+	// again
+	WebSocketErrorNotProcessed
 )
 
 // NewWebSocket opens a webservice-listener one port above the given
@@ -54,6 +63,7 @@ func NewWebSocket(si *network.ServerIdentity) *WebSocket {
 	w := &WebSocket{
 		services:  make(map[string]Service),
 		startstop: make(chan bool),
+		conns:     make(map[*websocket.Conn]bool),
 	}
 	webHost, err := getWebAddress(si, true)
 	log.ErrFatal(err)
@@ -86,6 +96,7 @@ func (w *WebSocket) start() {
 func (w *WebSocket) registerService(service string, s Service) error {
 	w.services[service] = s
 	h := &wsHandler{
+		ws:          w,
 		service:     s,
 		serviceName: service,
 	}
@@ -104,10 +115,28 @@ func (w *WebSocket) stop() {
 	w.server.Stop(100 * time.Millisecond)
 	<-w.startstop
 	w.started = false
+	for conn := range w.conns {
+		conn.Close()
+	}
+}
+
+// track remembers the open websocket conn, so that stop can close it.
+func (w *WebSocket) track(conn *websocket.Conn) {
+	w.Lock()
+	defer w.Unlock()
+	w.conns[conn] = true
+}
+
+// untrack forgets the closed websocket conn.
+func (w *WebSocket) untrack(conn *websocket.Conn) {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.conns, conn)
 }
 
 // Pass the request to the websocket.
 type wsHandler struct {
+	ws          *WebSocket
 	serviceName string
 	service     Service
 }
@@ -129,7 +158,9 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Error(err)
 		return
 	}
+	t.ws.track(ws)
 	defer func() {
+		t.ws.untrack(ws)
 		ws.Close()
 	}()
 	var ce ClientError
@@ -144,6 +175,16 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		var reply []byte
 		path := strings.TrimPrefix(r.URL.Path, "/"+t.serviceName+"/")
 		log.Lvl3("Got request for", t.serviceName, path)
+		if ss, ok := s.(StreamingService); ok && ss.Streaming(path) {
+			ce = t.stream(ws, mt, ss, path, buf)
+			if ce == nil {
+				ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+					time.Now().Add(time.Millisecond*500))
+				return
+			}
+			break
+		}
 		reply, ce = s.ProcessClientRequest(path, buf)
 		if ce == nil {
 			err := ws.WriteMessage(mt, reply)
@@ -161,88 +202,181 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		time.Now().Add(time.Millisecond*500))
 }
 
+// stream pushes the messages of a subscription to the client until the
+// service ends it or the client leaves.
+func (t wsHandler) stream(ws *websocket.Conn, mt int, ss StreamingService, path string, buf []byte) ClientError {
+	stop := make(chan bool)
+	defer close(stop)
+	msgs, ce := ss.ProcessClientStream(path, buf, stop)
+	if ce != nil {
+		return ce
+	}
+	// Any message or error from the client ends the subscription.
+	left := make(chan bool)
+	go func() {
+		ws.ReadMessage()
+		close(left)
+	}()
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if err := ws.WriteMessage(mt, msg); err != nil {
+				log.Lvl3("Subscriber left:", err)
+				return nil
+			}
+		case <-left:
+			return nil
+		}
+	}
+}
+
 type destination struct {
 	si   *network.ServerIdentity
 	path string
 }
 
+// DefaultClientTimeout is the deadline of every request of a Client, unless
+// the context of the request ends earlier.
+const DefaultClientTimeout = 5 * time.Minute
+
+// DefaultClientParallel is how many requests SendToAll has in flight at the
+// same time.
+const DefaultClientParallel = 10
+
+// DefaultClientIdle is how long a Client created with NewClientKeep keeps a
+// connection without pending requests open.
+const DefaultClientIdle = 30 * time.Second
+
+// errConnIdle is the error of a kept connection closed for being idle.
+var errConnIdle = errors.New("idle connection closed")
+
 // Client is a struct used to communicate with a remote Service running on a
 // onet.Server. Using Send it can connect to multiple remote Servers.
+// It can be used from many go-routines at the same time: every destination
+// has its own connection, so a slow server only delays the requests sent
+// to it.
 type Client struct {
 	service     string
-	connections map[destination]*websocket.Conn
+	connections map[destination]*clientConn
 	// whether to keep the connection
-	keep bool
-	rx   uint64
-	tx   uint64
+	keep     bool
+	idle     time.Duration
+	timeout  time.Duration
+	parallel int
+	rx       uint64
+	tx       uint64
 	sync.Mutex
 }
 
-// NewClient returns a client using the service s. On the first Send, the
-// connection will be started, until Close is called.
+// NewClient returns a client using the service s. Every Send opens a new
+// connection that is closed once the reply is received.
 func NewClient(s string) *Client {
 	return &Client{
 		service:     s,
-		connections: make(map[destination]*websocket.Conn),
+		connections: make(map[destination]*clientConn),
+		timeout:     DefaultClientTimeout,
+		parallel:    DefaultClientParallel,
 	}
 }
 
 // NewClientKeep returns a Client that doesn't close the connection between
-// two messages if it's the same server.
+// two messages if it's the same server. Requests sent at the same time to
+// the same server are pipelined on that connection.
 func NewClientKeep(s string) *Client {
-	return &Client{
-		service:     s,
-		keep:        true,
-		connections: make(map[destination]*websocket.Conn),
+	c := NewClient(s)
+	c.keep = true
+	c.idle = DefaultClientIdle
+	return c
+}
+
+// SetTimeout sets the deadline of every request; 0 means that only the
+// context of the request can abort it.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.timeout = timeout
+}
+
+// SetIdleTimeout sets how long a kept connection without pending requests
+// stays open; 0 keeps it open until Close.
+func (c *Client) SetIdleTimeout(idle time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.idle = idle
+}
+
+// SetParallel sets how many requests SendToAll has in flight at the same
+// time.
+func (c *Client) SetParallel(parallel int) {
+	c.Lock()
+	defer c.Unlock()
+	if parallel < 1 {
+		parallel = 1
 	}
+	c.parallel = parallel
 }
 
 // Send will marshal the message into a ClientRequest message and send it.
 func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]byte, ClientError) {
+	return c.SendContext(context.Background(), dst, path, buf)
+}
+
+// SendContext sends buf to the service on dst and returns the reply. It
+// returns an error as soon as ctx is done or the timeout of the Client
+// passed, whichever comes first.
+func (c *Client) SendContext(ctx context.Context, dst *network.ServerIdentity, path string, buf []byte) ([]byte, ClientError) {
 	c.Lock()
-	defer c.Unlock()
+	timeout := c.timeout
+	c.Unlock()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	dest := destination{dst, path}
-	conn, ok := c.connections[dest]
-	if !ok {
-		// Open connection to service.
-		url, err := getWebAddress(dst, false)
-		if err != nil {
-			return nil, NewClientError(err)
-		}
-		log.Lvlf4("Sending %x to %s/%s/%s", buf, url, c.service, path)
-		d := &websocket.Dialer{}
-		// Re-try to connect in case the websocket is just about to start
-		for a := 0; a < network.MaxRetryConnect; a++ {
-			conn, _, err = d.Dial(fmt.Sprintf("ws://%s/%s/%s", url, c.service, path),
-				http.Header{"Origin": []string{"http://" + url}})
-			if err == nil {
-				break
-			}
-			time.Sleep(network.WaitRetry)
-		}
-		if err != nil {
-			return nil, NewClientError(err)
-		}
-		c.connections[dest] = conn
+	cc, err := c.connection(ctx, dest)
+	if err != nil {
+		return nil, NewClientError(err)
 	}
-	defer func() {
-		if !c.keep {
-			if err := c.closeConn(dest); err != nil {
+	if !c.keep {
+		defer func() {
+			if err := cc.close(); err != nil {
 				log.Errorf("error while closing the connection to %v : %v\n", dest, err)
 			}
+		}()
+	}
+	log.Lvlf4("Sending %x to %s/%s", buf, c.service, path)
+	reply, err := cc.send(buf)
+	if err == errConnIdle {
+		// The kept connection has just been closed; use a new one
+		if cc, err = c.connection(ctx, dest); err != nil {
+			return nil, NewClientError(err)
 		}
-	}()
-	if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
-		return nil, NewClientError(err)
+		reply, err = cc.send(buf)
 	}
-	c.tx += uint64(len(buf))
-	_, rcv, err := conn.ReadMessage()
 	if err != nil {
 		return nil, NewClientError(err)
 	}
-	log.Lvlf4("Received %x", rcv)
-	c.rx += uint64(len(rcv))
-	return rcv, nil
+	c.Lock()
+	c.tx += uint64(len(buf))
+	c.Unlock()
+	select {
+	case r := <-reply:
+		if ce, ok := r.err.(ClientError); ok {
+			return nil, ce
+		}
+		if r.err != nil {
+			return nil, NewClientError(r.err)
+		}
+		log.Lvlf4("Received %x", r.buf)
+		return r.buf, nil
+	case <-ctx.Done():
+		return nil, NewClientError(ctx.Err())
+	}
 }
 
 // SendProtobuf wraps protobuf.(En|De)code over the Client.Send-function. It
@@ -253,12 +387,18 @@ func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]b
 // data from the service. ClientError has a code and a msg in case
 // something went wrong.
 func (c *Client) SendProtobuf(dst *network.ServerIdentity, msg interface{}, ret interface{}) ClientError {
+	return c.SendProtobufContext(context.Background(), dst, msg, ret)
+}
+
+// SendProtobufContext is SendProtobuf with a context that can cancel the
+// request.
+func (c *Client) SendProtobufContext(ctx context.Context, dst *network.ServerIdentity, msg interface{}, ret interface{}) ClientError {
 	buf, err := protobuf.Encode(msg)
 	if err != nil {
 		return NewClientError(err)
 	}
 	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
-	reply, cerr := c.Send(dst, path, buf)
+	reply, cerr := c.SendContext(ctx, dst, path, buf)
 	if cerr != nil {
 		return cerr
 	}
@@ -270,33 +410,238 @@ func (c *Client) SendProtobuf(dst *network.ServerIdentity, msg interface{}, ret
 	return nil
 }
 
-// SendToAll sends a message to all ServerIdentities of the Roster and returns
-// all errors encountered concatenated together as a string.
-func (c *Client) SendToAll(dst *Roster, path string, buf []byte) ([][]byte, ClientError) {
+// SendToAll sends a message to all ServerIdentities of the Roster in
+// parallel. It returns the replies and the errors in the order of the
+// Roster; the error of a server that replied is nil.
+func (c *Client) SendToAll(dst *Roster, path string, buf []byte) ([][]byte, []ClientError) {
+	return c.SendToAllContext(context.Background(), dst, path, buf)
+}
+
+// SendToAllContext is SendToAll with a context that can cancel the
+// requests still in flight.
+func (c *Client) SendToAllContext(ctx context.Context, dst *Roster, path string, buf []byte) ([][]byte, []ClientError) {
 	msgs := make([][]byte, len(dst.List))
-	var errstrs []string
-	for i, e := range dst.List {
-		var err ClientError
-		msgs[i], err = c.Send(e, path, buf)
+	errs := make([]ClientError, len(dst.List))
+	c.Lock()
+	slots := make(chan bool, c.parallel)
+	c.Unlock()
+	var wg sync.WaitGroup
+	for i, si := range dst.List {
+		slots <- true
+		wg.Add(1)
+		go func(i int, si *network.ServerIdentity) {
+			defer wg.Done()
+			msgs[i], errs[i] = c.SendContext(ctx, si, path, buf)
+			<-slots
+		}(i, si)
+	}
+	wg.Wait()
+	return msgs, errs
+}
+
+// Subscription is a stream of messages that a StreamingService pushes to a
+// Client, see Client.Subscribe.
+type Subscription struct {
+	// C receives the messages of the service. It is closed once the
+	// subscription ends; Err then returns why.
+	C       chan []byte
+	conn    *websocket.Conn
+	closing chan bool
+	once    sync.Once
+	err     ClientError
+	sync.Mutex
+}
+
+// Subscribe sends buf to the streaming handler path of dst and returns the
+// subscription receiving the messages of the service. Every subscription
+// uses its own connection. It ends when the service ends it, Close is
+// called or ctx is done; the timeout of the Client only applies to
+// setting up the connection.
+func (c *Client) Subscribe(ctx context.Context, dst *network.ServerIdentity, path string, buf []byte) (*Subscription, ClientError) {
+	c.Lock()
+	timeout := c.timeout
+	c.Unlock()
+	dialCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := c.dial(dialCtx, destination{dst, path})
+	if err != nil {
+		return nil, NewClientError(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+		conn.Close()
+		return nil, NewClientError(err)
+	}
+	c.Lock()
+	c.tx += uint64(len(buf))
+	c.Unlock()
+	s := &Subscription{
+		C:       make(chan []byte),
+		conn:    conn,
+		closing: make(chan bool),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Lock()
+			s.err = NewClientError(ctx.Err())
+			s.Unlock()
+			s.Close()
+		case <-s.closing:
+		}
+	}()
+	go s.receive(c)
+	return s, nil
+}
+
+// SubscribeProtobuf encodes msg and subscribes to the streaming handler of
+// its type, like SendProtobuf does for requests. The messages of the
+// subscription can be decoded with protobuf.DecodeWithConstructors.
+func (c *Client) SubscribeProtobuf(ctx context.Context, dst *network.ServerIdentity, msg interface{}) (*Subscription, ClientError) {
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, NewClientError(err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+	return c.Subscribe(ctx, dst, path, buf)
+}
+
+// receive passes the messages of the service to C until the connection
+// closes.
+func (s *Subscription) receive(c *Client) {
+	defer close(s.C)
+	defer s.Close()
+	for {
+		_, buf, err := s.conn.ReadMessage()
 		if err != nil {
-			errstrs = append(errstrs, fmt.Sprint(e.String(), err.Error()))
+			s.Lock()
+			select {
+			case <-s.closing:
+			default:
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					s.err = NewClientError(err)
+				}
+			}
+			s.Unlock()
+			return
+		}
+		c.Lock()
+		c.rx += uint64(len(buf))
+		c.Unlock()
+		select {
+		case s.C <- buf:
+		case <-s.closing:
+			return
 		}
 	}
+}
+
+// Close unsubscribes from the service and closes the connection.
+func (s *Subscription) Close() error {
 	var err error
-	if len(errstrs) > 0 {
-		err = errors.New(strings.Join(errstrs, "\n"))
+	s.once.Do(func() {
+		close(s.closing)
+		s.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Millisecond*500))
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// Err returns the error that ended the subscription, or nil if the service
+// ended it or Close was called.
+func (s *Subscription) Err() ClientError {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+// connection returns the kept connection to dest or dials a new one.
+func (c *Client) connection(ctx context.Context, dest destination) (*clientConn, error) {
+	if c.keep {
+		c.Lock()
+		cc, ok := c.connections[dest]
+		c.Unlock()
+		if ok {
+			return cc, nil
+		}
+	}
+	conn, err := c.dial(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	cc := newClientConn(c, dest, conn)
+	if !c.keep {
+		return cc, nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if other, ok := c.connections[dest]; ok {
+		// Another request dialed at the same time.
+		go cc.close()
+		return other, nil
+	}
+	c.connections[dest] = cc
+	return cc, nil
+}
+
+// dial opens a websocket to dest. It re-tries, waiting twice as long
+// every time, in case the websocket is just about to start.
+func (c *Client) dial(ctx context.Context, dest destination) (*websocket.Conn, error) {
+	url, err := getWebAddress(dest.si, false)
+	if err != nil {
+		return nil, err
+	}
+	d := &websocket.Dialer{
+		NetDial: func(netw, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, netw, addr)
+		},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.HandshakeTimeout = deadline.Sub(time.Now())
+	}
+	wait := network.WaitRetry
+	for a := 1; ; a++ {
+		conn, _, err := d.Dial(fmt.Sprintf("ws://%s/%s/%s", url, c.service, dest.path),
+			http.Header{"Origin": []string{"http://" + url}})
+		if err == nil {
+			return conn, nil
+		}
+		if a >= network.MaxRetryConnect {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// remove forgets the kept connection cc if it is still the one to dest.
+func (c *Client) remove(dest destination, cc *clientConn) {
+	c.Lock()
+	defer c.Unlock()
+	if c.connections[dest] == cc {
+		delete(c.connections, dest)
 	}
-	return msgs, NewClientError(err)
 }
 
 // Close sends a close-command to all open connections and returns nil if no
 // errors occurred or all errors encountered concatenated together as a string.
 func (c *Client) Close() error {
 	c.Lock()
-	defer c.Unlock()
+	conns := c.connections
+	c.connections = make(map[destination]*clientConn)
+	c.Unlock()
 	var errstrs []string
-	for dest := range c.connections {
-		if err := c.closeConn(dest); err != nil {
+	for _, cc := range conns {
+		if err := cc.close(); err != nil {
 			errstrs = append(errstrs, err.Error())
 		}
 	}
@@ -307,17 +652,6 @@ func (c *Client) Close() error {
 	return err
 }
 
-// closeConn sends a close-command to the connection.
-func (c *Client) closeConn(dst destination) error {
-	conn, ok := c.connections[dst]
-	if ok {
-		delete(c.connections, dst)
-		conn.WriteMessage(websocket.CloseMessage, nil)
-		return conn.Close()
-	}
-	return nil
-}
-
 // Tx returns the number of bytes transmitted by this Client. It implements
 // the monitor.CounterIOMeasure interface.
 func (c *Client) Tx() uint64 {
@@ -334,6 +668,148 @@ func (c *Client) Rx() uint64 {
 	return c.rx
 }
 
+// clientConn is a websocket of a Client. As the server answers the requests
+// of a connection one after the other, requests can be written without
+// waiting for the previous replies, which are then matched in order.
+type clientConn struct {
+	conn *websocket.Conn
+	// pending holds the reply channels of the requests waiting for a reply
+	pending []chan clientReply
+	closed  bool
+	err     error
+	// idle closes the connection once it had no pending requests for
+	// idleTimeout; idleTimeout is 0 if the connection is not kept
+	idle        *time.Timer
+	idleTimeout time.Duration
+	// protects writing to conn and all fields above
+	sync.Mutex
+}
+
+// clientReply is the reply to a request or the error of the connection.
+type clientReply struct {
+	buf []byte
+	err error
+}
+
+// newClientConn wraps conn and starts reading the replies.
+func newClientConn(c *Client, dest destination, conn *websocket.Conn) *clientConn {
+	cc := &clientConn{conn: conn}
+	c.Lock()
+	if c.keep {
+		cc.idleTimeout = c.idle
+	}
+	c.Unlock()
+	go cc.readReplies(c, dest)
+	return cc
+}
+
+// send writes the request and returns the channel of its reply.
+func (cc *clientConn) send(buf []byte) (chan clientReply, error) {
+	cc.Lock()
+	defer cc.Unlock()
+	if cc.closed {
+		return nil, cc.err
+	}
+	if err := cc.conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+		return nil, err
+	}
+	if cc.idle != nil {
+		cc.idle.Stop()
+	}
+	reply := make(chan clientReply, 1)
+	cc.pending = append(cc.pending, reply)
+	return reply, nil
+}
+
+// readReplies passes every reply to the oldest pending request. Once the
+// connection fails, the oldest pending request gets the error. If the server
+// closed the connection with an error code, it did so after the first
+// failing request and without processing the ones behind it, so the other
+// pending requests get WebSocketErrorNotProcessed. On any other failure they
+// may have been processed and get the error as well.
+func (cc *clientConn) readReplies(c *Client, dest destination) {
+	for {
+		_, buf, err := cc.conn.ReadMessage()
+		if err != nil {
+			// Requests sent again get a new connection
+			c.remove(dest, cc)
+			cc.Lock()
+			if !cc.closed {
+				cc.closed = true
+				cc.err = err
+			}
+			if cc.idle != nil {
+				cc.idle.Stop()
+			}
+			rest := cc.err
+			if ce, ok := err.(*websocket.CloseError); ok && ce.Code >= 4000 && ce.Code < 5000 {
+				rest = NewClientErrorCode(WebSocketErrorNotProcessed,
+					"connection closed before the request was processed")
+			}
+			for i, reply := range cc.pending {
+				if i == 0 {
+					reply <- clientReply{err: cc.err}
+				} else {
+					reply <- clientReply{err: rest}
+				}
+			}
+			cc.pending = nil
+			cc.Unlock()
+			cc.conn.Close()
+			return
+		}
+		c.Lock()
+		c.rx += uint64(len(buf))
+		c.Unlock()
+		cc.Lock()
+		if len(cc.pending) == 0 {
+			cc.Unlock()
+			log.Error("Got a reply without a request from", dest.si)
+			continue
+		}
+		reply := cc.pending[0]
+		cc.pending = cc.pending[1:]
+		if len(cc.pending) == 0 && cc.idleTimeout > 0 {
+			if cc.idle == nil {
+				cc.idle = time.AfterFunc(cc.idleTimeout, func() { cc.closeIdle(c, dest) })
+			} else {
+				cc.idle.Reset(cc.idleTimeout)
+			}
+		}
+		cc.Unlock()
+		reply <- clientReply{buf: buf}
+	}
+}
+
+// closeIdle closes the connection if it still has no pending requests.
+func (cc *clientConn) closeIdle(c *Client, dest destination) {
+	cc.Lock()
+	if cc.closed || len(cc.pending) > 0 {
+		cc.Unlock()
+		return
+	}
+	cc.closed = true
+	cc.err = errConnIdle
+	cc.conn.WriteMessage(websocket.CloseMessage, nil)
+	cc.Unlock()
+	c.remove(dest, cc)
+	cc.conn.Close()
+}
+
+// close sends a close-command to the connection. Pending requests fail.
+func (cc *clientConn) close() error {
+	cc.Lock()
+	if cc.closed {
+		cc.Unlock()
+		return nil
+	}
+	cc.closed = true
+	cc.err = errors.New("connection closed")
+	cc.conn.WriteMessage(websocket.CloseMessage, nil)
+	cc.Unlock()
+	return cc.conn.Close()
+}
+
 // ClientError allows for returning error-codes and error-messages. It is
 // implemented by cerror, that can be instantiated using NewClientError and
 // NewClientErrorCode.
@@ -1,7 +1,10 @@
 package onet
 
 import (
+	"bytes"
+	"context"
 	"testing"
+	"time"
 
 	"fmt"
 
@@ -173,6 +176,231 @@ func TestMultiplePath(t *testing.T) {
 	require.Equal(t, path2, string(resp))
 }
 
+func TestClient_SendToAll(t *testing.T) {
+	local := NewTCPTest()
+	defer local.CloseAll()
+	servers := local.GenServers(3)
+	roster := local.GenRosterFromHost(servers...)
+	// A server that is part of the roster but not running.
+	_, down := NewPrivIdentity(2)
+	roster = NewRoster(append(roster.List, down))
+
+	buf, err := protobuf.Encode(&SimpleResponse{1})
+	require.Nil(t, err)
+	client := NewClient(serviceWebSocket)
+	client.SetParallel(2)
+	replies, errs := client.SendToAll(roster, "SimpleResponse", buf)
+	require.Equal(t, 4, len(replies))
+	require.Equal(t, 4, len(errs))
+	for i := range servers {
+		require.Nil(t, errs[i])
+		sr := &SimpleResponse{}
+		require.Nil(t, protobuf.Decode(replies[i], sr))
+		require.Equal(t, 2, sr.Val)
+	}
+	require.NotNil(t, errs[3])
+	require.Nil(t, replies[3])
+}
+
+func TestClient_Timeout(t *testing.T) {
+	_, err := RegisterNewService(echoServiceName, newEchoService)
+	log.ErrFatal(err)
+	defer UnregisterService(echoServiceName)
+
+	local := NewTCPTest()
+	server := local.GenServers(1)[0]
+	defer local.CloseAll()
+
+	client := NewClientKeep(echoServiceName)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, cerr := client.SendContext(ctx, server.ServerIdentity, "slow", []byte{1})
+	require.NotNil(t, cerr)
+	require.True(t, time.Since(start) < echoSlow)
+
+	// Other paths are not blocked by the slow request.
+	resp, cerr := client.Send(server.ServerIdentity, "echo", []byte{2})
+	require.Nil(t, cerr)
+	require.Equal(t, []byte{2}, resp)
+
+	client.SetTimeout(100 * time.Millisecond)
+	_, cerr = client.Send(server.ServerIdentity, "slow", []byte{3})
+	require.NotNil(t, cerr)
+
+	// Don't leave the service sleeping after the test.
+	for i := 0; i < 2; i++ {
+		<-echoSlowDone
+	}
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	_, err := RegisterNewService(echoServiceName, newEchoService)
+	log.ErrFatal(err)
+	defer UnregisterService(echoServiceName)
+
+	local := NewTCPTest()
+	server := local.GenServers(1)[0]
+	defer local.CloseAll()
+
+	client := NewClientKeep(echoServiceName)
+	defer client.Close()
+	// Open the connection so that all requests share it.
+	_, cerr := client.Send(server.ServerIdentity, "echo", []byte{0})
+	require.Nil(t, cerr)
+
+	nbrParallel := 20
+	wg := sync.WaitGroup{}
+	wg.Add(nbrParallel)
+	for i := 0; i < nbrParallel; i++ {
+		go func(i int) {
+			defer wg.Done()
+			buf := []byte{byte(i), 1, 2, 3}
+			resp, cerr := client.Send(server.ServerIdentity, "echo", buf)
+			assert.Nil(t, cerr)
+			assert.True(t, bytes.Equal(buf, resp))
+		}(i)
+	}
+	wg.Wait()
+	client.Lock()
+	require.Equal(t, 1, len(client.connections))
+	client.Unlock()
+	require.Nil(t, client.Close())
+	client.Lock()
+	require.Equal(t, 0, len(client.connections))
+	client.Unlock()
+}
+
+func TestClient_PipelineError(t *testing.T) {
+	_, err := RegisterNewService(echoServiceName, newEchoService)
+	log.ErrFatal(err)
+	defer UnregisterService(echoServiceName)
+
+	local := NewTCPTest()
+	server := local.GenServers(1)[0]
+	defer local.CloseAll()
+
+	client := NewClientKeep(echoServiceName)
+	defer client.Close()
+	_, cerr := client.Send(server.ServerIdentity, "fail", []byte{1})
+	require.Nil(t, cerr)
+
+	// Only the failing request gets the error of the server, the requests
+	// queued behind it have not been processed.
+	failed := make(chan ClientError)
+	go func() {
+		_, cerr := client.Send(server.ServerIdentity, "fail", []byte{0})
+		failed <- cerr
+	}()
+	time.Sleep(echoFail / 4)
+	nbrQueued := 3
+	queued := make(chan ClientError, nbrQueued)
+	for i := 0; i < nbrQueued; i++ {
+		go func(i int) {
+			_, cerr := client.Send(server.ServerIdentity, "fail", []byte{byte(i + 1)})
+			queued <- cerr
+		}(i)
+	}
+	cerr = <-failed
+	require.NotNil(t, cerr)
+	require.Equal(t, 4100, cerr.ErrorCode())
+	for i := 0; i < nbrQueued; i++ {
+		cerr := <-queued
+		require.NotNil(t, cerr)
+		require.Equal(t, WebSocketErrorNotProcessed, cerr.ErrorCode())
+	}
+
+	// A new connection is opened for the next request.
+	resp, cerr := client.Send(server.ServerIdentity, "fail", []byte{2})
+	require.Nil(t, cerr)
+	require.Equal(t, []byte{2}, resp)
+}
+
+func TestClient_Idle(t *testing.T) {
+	_, err := RegisterNewService(echoServiceName, newEchoService)
+	log.ErrFatal(err)
+	defer UnregisterService(echoServiceName)
+
+	local := NewTCPTest()
+	server := local.GenServers(1)[0]
+	defer local.CloseAll()
+
+	client := NewClientKeep(echoServiceName)
+	defer client.Close()
+	client.SetIdleTimeout(50 * time.Millisecond)
+	_, cerr := client.Send(server.ServerIdentity, "echo", []byte{1})
+	require.Nil(t, cerr)
+	time.Sleep(200 * time.Millisecond)
+	client.Lock()
+	require.Equal(t, 0, len(client.connections))
+	client.Unlock()
+
+	resp, cerr := client.Send(server.ServerIdentity, "echo", []byte{2})
+	require.Nil(t, cerr)
+	require.Equal(t, []byte{2}, resp)
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	_, err := RegisterNewService(streamServiceName, newStreamService)
+	log.ErrFatal(err)
+	defer UnregisterService(streamServiceName)
+
+	local := NewTCPTest()
+	server := local.GenServers(1)[0]
+	defer local.CloseAll()
+	client := NewClient(streamServiceName)
+
+	// The service ends the stream after N messages.
+	sub, cerr := client.SubscribeProtobuf(context.Background(), server.ServerIdentity,
+		&StreamRequest{N: 5})
+	require.Nil(t, cerr)
+	var vals []int
+	for buf := range sub.C {
+		sr := &SimpleResponse{}
+		require.Nil(t, protobuf.Decode(buf, sr))
+		vals = append(vals, sr.Val)
+	}
+	require.Nil(t, sub.Err())
+	require.Equal(t, []int{0, 1, 2, 3, 4}, vals)
+
+	// The client unsubscribes from an endless stream.
+	sub, cerr = client.SubscribeProtobuf(context.Background(), server.ServerIdentity,
+		&StreamRequest{Forever: true})
+	require.Nil(t, cerr)
+	<-sub.C
+	require.Nil(t, sub.Close())
+	service := server.Service(streamServiceName).(*streamService)
+	select {
+	case <-service.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Service didn't stop the stream")
+	}
+	for range sub.C {
+	}
+	require.Nil(t, sub.Err())
+
+	// Cancelling the context ends the subscription.
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, cerr = client.SubscribeProtobuf(ctx, server.ServerIdentity,
+		&StreamRequest{Forever: true})
+	require.Nil(t, cerr)
+	cancel()
+	for range sub.C {
+	}
+	require.NotNil(t, sub.Err())
+	<-service.stopped
+
+	// Errors of the service are returned by Err.
+	sub, cerr = client.SubscribeProtobuf(context.Background(), server.ServerIdentity,
+		&StreamRequest{N: -1})
+	require.Nil(t, cerr)
+	for range sub.C {
+	}
+	require.NotNil(t, sub.Err())
+	require.Equal(t, 4100, sub.Err().ErrorCode())
+}
+
 const serviceWebSocket = "WebSocket"
 
 type ServiceWebSocket struct {
@@ -207,3 +435,85 @@ func (ds *DummyService3) NewProtocol(tn *TreeNodeInstance, conf *GenericConfig)
 
 func (ds *DummyService3) Process(env *network.Envelope) {
 }
+
+const echoServiceName = "echoService"
+
+// echoSlow is how long the echoService takes to answer on the "slow" path.
+const echoSlow = 2 * time.Second
+
+// echoFail is how long the echoService takes to fail on the "fail" path.
+const echoFail = 200 * time.Millisecond
+
+// echoSlowDone receives a value every time the echoService answered on the
+// "slow" path.
+var echoSlowDone = make(chan bool, 10)
+
+// EchoService returns the request, after echoSlow if the path is "slow". On
+// the "fail" path, a request starting with 0 fails after echoFail.
+type EchoService struct {
+}
+
+func newEchoService(c *Context) Service {
+	return &EchoService{}
+}
+
+func (es *EchoService) ProcessClientRequest(path string, buf []byte) ([]byte, ClientError) {
+	switch {
+	case path == "slow":
+		time.Sleep(echoSlow)
+		echoSlowDone <- true
+	case path == "fail" && len(buf) > 0 && buf[0] == 0:
+		time.Sleep(echoFail)
+		return nil, NewClientErrorCode(4100, "failed")
+	}
+	return buf, nil
+}
+
+func (es *EchoService) NewProtocol(tn *TreeNodeInstance, conf *GenericConfig) (ProtocolInstance, error) {
+	return nil, nil
+}
+
+func (es *EchoService) Process(env *network.Envelope) {
+}
+
+const streamServiceName = "streamService"
+
+// StreamRequest asks the streamService for N messages, or for messages
+// until the client leaves if Forever is true.
+type StreamRequest struct {
+	N       int
+	Forever bool
+}
+
+type streamService struct {
+	*ServiceProcessor
+	stopped chan bool
+}
+
+func newStreamService(c *Context) Service {
+	s := &streamService{
+		ServiceProcessor: NewServiceProcessor(c),
+		stopped:          make(chan bool, 1),
+	}
+	log.ErrFatal(s.RegisterStreamingHandler(s.StreamRequest))
+	return s
+}
+
+func (s *streamService) StreamRequest(req *StreamRequest, stop chan bool) (chan *SimpleResponse, ClientError) {
+	if req.N < 0 {
+		return nil, NewClientErrorCode(4100, "negative N")
+	}
+	msgs := make(chan *SimpleResponse)
+	go func() {
+		defer close(msgs)
+		for i := 0; req.Forever || i < req.N; i++ {
+			select {
+			case msgs <- &SimpleResponse{i}:
+			case <-stop:
+				s.stopped <- true
+				return
+			}
+		}
+	}()
+	return msgs, nil
+}
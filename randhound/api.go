@@ -0,0 +1,322 @@
+package randhound
+
+import (
+	"context"
+	"time"
+
+	"github.com/dedis/protobuf"
+	"mobilehound/crypto"
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+)
+
+func init() {
+	for _, m := range []interface{}{GenerateRandom{}, GenerateRandomReply{},
+		GetTranscript{}, GetTranscriptReply{}, VerifyRandom{}, VerifyRandomReply{},
+		StartBeacon{}, StartBeaconReply{}, StopBeacon{}, StopBeaconReply{},
+		GetRound{}, GetRoundReply{}, GetChain{}, GetChainReply{},
+		SubscribeBeacon{}, SubscribeProgress{}, ProgressEvent{}} {
+		network.RegisterMessage(m)
+	}
+}
+
+// GenerateRandom asks a server to run RandHound as the client on the given
+// roster. The server has to be part of the roster; it is moved to the first
+// position before the run.
+type GenerateRandom struct {
+	Roster  *onet.Roster
+	Groups  int
+	Faulty  int
+	Purpose string
+}
+
+// GenerateRandomReply holds the collective randomness of a run together with
+// the binary encoding of its transcript (see Transcript.MarshalBinary).
+type GenerateRandomReply struct {
+	SID        []byte
+	Random     []byte
+	Transcript []byte
+}
+
+// GetTranscript asks a server for the result of an earlier run.
+type GetTranscript struct {
+	SID []byte
+}
+
+// GetTranscriptReply holds the stored result of a run.
+type GetTranscriptReply struct {
+	Random     []byte
+	Transcript []byte
+}
+
+// VerifyRandom asks a server to verify a random string against the binary
+// encoding of a transcript.
+type VerifyRandom struct {
+	Random     []byte
+	Transcript []byte
+}
+
+// VerifyRandomReply holds the verification result and the roster indices of
+// the servers whose proofs did not verify.
+type VerifyRandomReply struct {
+	Valid  bool
+	Reason string
+	Blamed []uint32
+}
+
+// StartBeacon asks a server to lead a randomness beacon on the given roster,
+// starting a new RandHound run every Interval milliseconds. It has to be
+// signed by an operator of the server, see Service.SetOperators.
+type StartBeacon struct {
+	Roster   *onet.Roster
+	Groups   int
+	Faulty   int
+	Purpose  string
+	Interval int
+	Time     int64 // Time of the request (Unix nanoseconds)
+	Sig      crypto.SchnorrSig
+}
+
+// StartBeaconReply holds the number of the first round the beacon will run.
+type StartBeaconReply struct {
+	Round int
+}
+
+// StopBeacon asks a server to stop leading its beacon. It has to be signed
+// by an operator of the server, see Service.SetOperators.
+type StopBeacon struct {
+	Time int64 // Time of the request (Unix nanoseconds)
+	Sig  crypto.SchnorrSig
+}
+
+// StopBeaconReply holds the number of rounds in the history.
+type StopBeaconReply struct {
+	Rounds int
+}
+
+// GetRound asks for a round of the beacon; a negative Round asks for the
+// latest one.
+type GetRound struct {
+	Round int
+}
+
+// GetRoundReply holds a beacon round and the binary transcript of its run.
+type GetRoundReply struct {
+	Round      *BeaconRound
+	Transcript []byte
+}
+
+// GetChain asks for the beacon history from the genesis round up to Round; a
+// negative Round asks for the whole history.
+type GetChain struct {
+	Round int
+}
+
+// GetChainReply holds the requested part of the beacon history.
+type GetChainReply struct {
+	Rounds []*BeaconRound
+}
+
+// SubscribeBeacon subscribes to the rounds of the beacon led by a server.
+// Every new round is pushed as a GetRoundReply.
+type SubscribeBeacon struct {
+}
+
+// SubscribeProgress subscribes to the progress of all runs a server executes
+// as the client.
+type SubscribeProgress struct {
+}
+
+// ProgressEvent is pushed whenever the client of a run recorded a reply, see
+// ProgressFunc.
+type ProgressEvent struct {
+	SID      []byte
+	Purpose  string
+	Phase    int
+	Received int
+	Expected int
+}
+
+// Client is a structure to communicate with the RandHound service.
+type Client struct {
+	*onet.Client
+}
+
+// NewClient instantiates a new RandHound service client.
+func NewClient() *Client {
+	return &Client{Client: onet.NewClient(ServiceName)}
+}
+
+// GenerateRandom asks the first server of the roster to run RandHound on the
+// roster and returns the collective randomness and transcript.
+func (c *Client) GenerateRandom(roster *onet.Roster, groups int, faulty int, purpose string) (*GenerateRandomReply, onet.ClientError) {
+	if roster == nil || len(roster.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Empty roster")
+	}
+	reply := &GenerateRandomReply{}
+	req := &GenerateRandom{
+		Roster:  roster,
+		Groups:  groups,
+		Faulty:  faulty,
+		Purpose: purpose,
+	}
+	if cerr := c.SendProtobuf(roster.List[0], req, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// GetTranscript fetches the result of the run with the given session
+// identifier from the server that acted as the client of that run.
+func (c *Client) GetTranscript(si *network.ServerIdentity, sid []byte) (*GetTranscriptReply, onet.ClientError) {
+	reply := &GetTranscriptReply{}
+	if cerr := c.SendProtobuf(si, &GetTranscript{SID: sid}, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// VerifyRandom asks the given server to verify the random string against the
+// binary encoding of a transcript.
+func (c *Client) VerifyRandom(si *network.ServerIdentity, random []byte, transcript []byte) (*VerifyRandomReply, onet.ClientError) {
+	reply := &VerifyRandomReply{}
+	req := &VerifyRandom{Random: random, Transcript: transcript}
+	if cerr := c.SendProtobuf(si, req, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// StartBeacon asks the first server of the roster to lead a beacon that runs
+// RandHound on the roster at every interval. The request is signed with key,
+// which has to belong to an operator of that server.
+func (c *Client) StartBeacon(roster *onet.Roster, groups int, faulty int, purpose string, interval time.Duration, key abstract.Scalar) (*StartBeaconReply, onet.ClientError) {
+	if roster == nil || len(roster.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Empty roster")
+	}
+	reply := &StartBeaconReply{}
+	req := &StartBeacon{
+		Roster:   roster,
+		Groups:   groups,
+		Faulty:   faulty,
+		Purpose:  purpose,
+		Interval: int(interval / time.Millisecond),
+	}
+	if err := req.Sign(network.Suite, key); err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if cerr := c.SendProtobuf(roster.List[0], req, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// StopBeacon asks the leader to stop its beacon. The request is signed with
+// key, which has to belong to an operator of the leader.
+func (c *Client) StopBeacon(leader *network.ServerIdentity, key abstract.Scalar) (*StopBeaconReply, onet.ClientError) {
+	reply := &StopBeaconReply{}
+	req := &StopBeacon{}
+	if err := req.Sign(network.Suite, key); err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if cerr := c.SendProtobuf(leader, req, reply); cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// GetRound fetches round n of the beacon led by leader and verifies it: the
+// history from the genesis round up to n has to be signed by the leader and
+// correctly chained, and the transcript of round n has to prove its
+// randomness. A negative n fetches the latest round.
+func (c *Client) GetRound(leader *network.ServerIdentity, n int) (*BeaconRound, *Transcript, onet.ClientError) {
+	reply := &GetRoundReply{}
+	if cerr := c.SendProtobuf(leader, &GetRound{Round: n}, reply); cerr != nil {
+		return nil, nil, cerr
+	}
+	if reply.Round == nil {
+		return nil, nil, onet.NewClientErrorCode(ErrorBeacon, "Empty reply")
+	}
+
+	// Only use the round as part of the verified history
+	chain := &GetChainReply{}
+	if cerr := c.SendProtobuf(leader, &GetChain{Round: reply.Round.Round}, chain); cerr != nil {
+		return nil, nil, cerr
+	}
+	if err := VerifyBeacon(network.Suite, leader.Public, chain.Rounds); err != nil {
+		return nil, nil, onet.NewClientError(err)
+	}
+	if len(chain.Rounds) != reply.Round.Round+1 {
+		return nil, nil, onet.NewClientErrorCode(ErrorBeacon, "Incomplete beacon history")
+	}
+	round := chain.Rounds[reply.Round.Round]
+
+	var prev *BeaconRound
+	if round.Round > 0 {
+		prev = chain.Rounds[round.Round-1]
+	}
+	t, err := VerifyBeaconRound(network.Suite, round, prev, reply.Transcript)
+	if err != nil {
+		return nil, nil, onet.NewClientError(err)
+	}
+	return round, t, nil
+}
+
+// SubscribeBeacon returns a channel receiving every new round of the beacon
+// led by leader, until ctx is done. The rounds are not verified; use
+// GetRound to verify a round. Rounds the caller doesn't read in time may be
+// dropped by the server.
+func (c *Client) SubscribeBeacon(ctx context.Context, leader *network.ServerIdentity) (chan *GetRoundReply, onet.ClientError) {
+	sub, cerr := c.SubscribeProtobuf(ctx, leader, &SubscribeBeacon{})
+	if cerr != nil {
+		return nil, cerr
+	}
+	rounds := make(chan *GetRoundReply)
+	go func() {
+		defer close(rounds)
+		defer sub.Close()
+		for buf := range sub.C {
+			reply := &GetRoundReply{}
+			if err := protobuf.DecodeWithConstructors(buf, reply,
+				network.DefaultConstructors(network.Suite)); err != nil {
+				log.Error("Couldn't decode beacon round:", err)
+				return
+			}
+			select {
+			case rounds <- reply:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return rounds, nil
+}
+
+// SubscribeProgress returns a channel receiving the progress of all runs
+// the server si executes as the client, until ctx is done.
+func (c *Client) SubscribeProgress(ctx context.Context, si *network.ServerIdentity) (chan *ProgressEvent, onet.ClientError) {
+	sub, cerr := c.SubscribeProtobuf(ctx, si, &SubscribeProgress{})
+	if cerr != nil {
+		return nil, cerr
+	}
+	events := make(chan *ProgressEvent)
+	go func() {
+		defer close(events)
+		defer sub.Close()
+		for buf := range sub.C {
+			ev := &ProgressEvent{}
+			if err := protobuf.Decode(buf, ev); err != nil {
+				log.Error("Couldn't decode progress event:", err)
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
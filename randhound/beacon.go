@@ -0,0 +1,221 @@
+package randhound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"mobilehound/crypto"
+	"mobilehound/v0-abstract"
+)
+
+// Domain separation tags of the beacon encodings.
+const (
+	tagBeacon      = "RandHound/Beacon/v1"
+	tagStartBeacon = "RandHound/StartBeacon/v1"
+	tagStopBeacon  = "RandHound/StopBeacon/v1"
+)
+
+// MinBeaconInterval is the shortest interval between two beacon rounds a
+// server accepts.
+const MinBeaconInterval = time.Second
+
+// BeaconRequestWindow is how far the time of a signed StartBeacon or
+// StopBeacon request may be off from the clock of the server.
+const BeaconRequestWindow = 5 * time.Minute
+
+// BeaconRound is one entry of the append-only history of a randomness beacon.
+// Every round refers to the hash of its predecessor and is signed by the
+// leader that ran it; the genesis round has round number 0 and an empty Prev,
+// which is why Prev is an optional protobuf field.
+type BeaconRound struct {
+	Round          int               // Round number, starting at 0
+	Purpose        string            // Purpose of the beacon
+	Time           int64             // Scheduled start of the round (Unix nanoseconds)
+	SID            []byte            // Session identifier of the RandHound run
+	Random         []byte            // Collective randomness of the run
+	TranscriptHash []byte            // Hash of the binary transcript of the run
+	Prev           []byte            `protobuf:"opt"` // Hash of the previous round
+	Sig            crypto.SchnorrSig // Signature of the leader
+}
+
+// Encode returns the bytes of the round that are signed and hashed:
+//
+//	bytes("RandHound/Beacon/v1") u32(Round) bytes(Purpose) u64(Time)
+//	bytes(SID) bytes(Random) bytes(TranscriptHash) bytes(Prev)
+//
+// using the notation of the message encoding. The Sig field is not included.
+func (r *BeaconRound) Encode() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagBeacon))
+	w.uint32(r.Round)
+	w.bytes([]byte(r.Purpose))
+	w.uint64(uint64(r.Time))
+	w.bytes(r.SID)
+	w.bytes(r.Random)
+	w.bytes(r.TranscriptHash)
+	w.bytes(r.Prev)
+	return w.result()
+}
+
+// Hash returns the suite hash of the encoding of the round. It is the value
+// stored in the Prev field of the next round.
+func (r *BeaconRound) Hash(suite abstract.Suite) ([]byte, error) {
+	b, err := r.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.HashBytes(suite.Hash(), b)
+}
+
+// sign signs the round with the private key of the leader.
+func (r *BeaconRound) sign(suite abstract.Suite, key abstract.Scalar) error {
+	b, err := r.Encode()
+	if err != nil {
+		return err
+	}
+	r.Sig, err = crypto.SignSchnorr(suite, key, b)
+	return err
+}
+
+// beaconPurpose returns the purpose of the RandHound run of a round. Every
+// round after the genesis round commits to the randomness of its predecessor
+// through the purpose and therefore through the session identifier.
+func beaconPurpose(purpose string, round int, prevRandom []byte) string {
+	if round == 0 {
+		return purpose
+	}
+	return fmt.Sprintf("%s/round %d/%x", purpose, round, prevRandom)
+}
+
+// VerifyBeacon checks that rounds is a valid beacon history starting at the
+// genesis round: round numbers are consecutive, every round refers to the hash
+// of its predecessor and all rounds are signed by the leader.
+func VerifyBeacon(suite abstract.Suite, leader abstract.Point, rounds []*BeaconRound) error {
+	if len(rounds) == 0 {
+		return errors.New("Empty beacon history")
+	}
+	var prev []byte
+	for i, r := range rounds {
+		if r.Round != i {
+			return fmt.Errorf("Round %v at position %v", r.Round, i)
+		}
+		if !bytes.Equal(r.Prev, prev) {
+			return fmt.Errorf("Round %v does not refer to its predecessor", i)
+		}
+		if i > 0 && r.Purpose != rounds[0].Purpose {
+			return fmt.Errorf("Round %v has a different purpose", i)
+		}
+		b, err := r.Encode()
+		if err != nil {
+			return err
+		}
+		if err := crypto.VerifySchnorr(suite, leader, b, r.Sig); err != nil {
+			return fmt.Errorf("Round %v: %v", i, err)
+		}
+		if prev, err = crypto.HashBytes(suite.Hash(), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBeaconRound checks that the binary transcript tb, as received from
+// the leader, belongs to round r and proves its randomness, and returns the
+// decoded transcript. The hash is taken over the received bytes, so it doesn't
+// depend on the transcript encoding round-tripping. The previous round is
+// needed to check the chaining of the purpose and has to be nil for the
+// genesis round.
+func VerifyBeaconRound(suite abstract.Suite, r *BeaconRound, prev *BeaconRound, tb []byte) (*Transcript, error) {
+	th, err := crypto.HashBytes(suite.Hash(), tb)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(th, r.TranscriptHash) {
+		return nil, errors.New("Wrong transcript hash")
+	}
+	t, err := TranscriptFromBinary(suite, tb)
+	if err != nil {
+		return nil, err
+	}
+	var prevRandom []byte
+	if r.Round > 0 {
+		if prev == nil || prev.Round != r.Round-1 {
+			return nil, errors.New("Missing previous round")
+		}
+		prevRandom = prev.Random
+	}
+	if t.Purpose != beaconPurpose(r.Purpose, r.Round, prevRandom) {
+		return nil, errors.New("Transcript purpose does not match the beacon chain")
+	}
+	if !bytes.Equal(t.SID, r.SID) {
+		return nil, errors.New("Transcript belongs to a different session")
+	}
+	if err := VerifyTranscript(suite, r.Random, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Encode returns the bytes of the request that are signed:
+//
+//	bytes("RandHound/StartBeacon/v1") u32(len(Roster))
+//	{ point(Public) bytes(Address) }* u32(Groups) u32(Faulty)
+//	bytes(Purpose) u32(Interval) u64(Time)
+//
+// using the notation of the message encoding. The Sig field is not included.
+func (req *StartBeacon) Encode() ([]byte, error) {
+	if req.Roster == nil {
+		return nil, errors.New("Missing roster")
+	}
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagStartBeacon))
+	w.uint32(len(req.Roster.List))
+	for _, si := range req.Roster.List {
+		w.marshal(si.Public)
+		w.bytes([]byte(si.Address))
+	}
+	w.uint32(req.Groups)
+	w.uint32(req.Faulty)
+	w.bytes([]byte(req.Purpose))
+	w.uint32(req.Interval)
+	w.uint64(uint64(req.Time))
+	return w.result()
+}
+
+// Sign sets the time of the request to now and signs it with the key of an
+// operator of the leader.
+func (req *StartBeacon) Sign(suite abstract.Suite, key abstract.Scalar) error {
+	req.Time = time.Now().UnixNano()
+	b, err := req.Encode()
+	if err != nil {
+		return err
+	}
+	req.Sig, err = crypto.SignSchnorr(suite, key, b)
+	return err
+}
+
+// Encode returns the bytes of the request that are signed:
+//
+//	bytes("RandHound/StopBeacon/v1") u64(Time)
+//
+// using the notation of the message encoding. The Sig field is not included.
+func (req *StopBeacon) Encode() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagStopBeacon))
+	w.uint64(uint64(req.Time))
+	return w.result()
+}
+
+// Sign sets the time of the request to now and signs it with the key of an
+// operator of the leader.
+func (req *StopBeacon) Sign(suite abstract.Suite, key abstract.Scalar) error {
+	req.Time = time.Now().UnixNano()
+	b, err := req.Encode()
+	if err != nil {
+		return err
+	}
+	req.Sig, err = crypto.SignSchnorr(suite, key, b)
+	return err
+}
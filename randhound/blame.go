@@ -0,0 +1,122 @@
+package randhound
+
+import (
+	"fmt"
+	"sort"
+
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+)
+
+// FailureKind describes why a server has been blamed.
+type FailureKind int
+
+// Kinds of failures recorded in a BlameReport.
+const (
+	// BadEncryptionProof means that encrypted shares of a server did not
+	// match their encryption consistency proofs.
+	BadEncryptionProof FailureKind = iota + 1
+	// BadDecryptionProof means that decrypted shares of a server did not
+	// match their decryption consistency proofs.
+	BadDecryptionProof
+	// BadSignature means that the signature of a reply did not verify.
+	BadSignature
+	// BadReplyHash means that a reply referred to a different client
+	// message.
+	BadReplyHash
+	// MissingReply means that a server did not reply before the deadline of
+	// a phase.
+	MissingReply
+)
+
+var failureNames = map[FailureKind]string{
+	BadEncryptionProof: "bad encryption proof",
+	BadDecryptionProof: "bad decryption proof",
+	BadSignature:       "bad signature",
+	BadReplyHash:       "bad hash",
+	MissingReply:       "missing reply",
+}
+
+func (k FailureKind) String() string {
+	if name, ok := failureNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("FailureKind(%d)", int(k))
+}
+
+// parseFailureKind is the inverse of FailureKind.String.
+func parseFailureKind(s string) (FailureKind, error) {
+	for k, name := range failureNames {
+		if name == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown failure kind %q", s)
+}
+
+// Blame records a single failure of a server.
+type Blame struct {
+	Server  int             // Roster index of the server
+	Public  abstract.Point  // Public key of the server
+	Address network.Address // Address of the server
+	Phase   int             // Protocol phase (1 or 2)
+	Kind    FailureKind     // Kind of failure
+}
+
+// BlameReport lists the servers that misbehaved during a protocol run. The
+// report is created by the client and is not covered by any signature;
+// auditors can recompute the proof failures with AuditTranscript.
+type BlameReport struct {
+	Blames []Blame
+}
+
+// add records a failure unless the same failure has already been recorded.
+func (br *BlameReport) add(b Blame) {
+	for _, c := range br.Blames {
+		if b.Server == c.Server && b.Phase == c.Phase && b.Kind == c.Kind {
+			return
+		}
+	}
+	br.Blames = append(br.Blames, b)
+}
+
+// merge adds all failures of other to the report.
+func (br *BlameReport) merge(other *BlameReport) {
+	for _, b := range other.Blames {
+		br.add(b)
+	}
+}
+
+// Servers returns the sorted roster indices of all blamed servers.
+func (br *BlameReport) Servers() []int {
+	seen := make(map[int]bool)
+	var servers []int
+	for _, b := range br.Blames {
+		if !seen[b.Server] {
+			seen[b.Server] = true
+			servers = append(servers, b.Server)
+		}
+	}
+	sort.Ints(servers)
+	return servers
+}
+
+// blame records a failure of the server with the given roster index. Needs to
+// be called with the mutex held.
+func (rh *RandHound) blame(server int, phase int, kind FailureKind) {
+	b := Blame{Server: server, Phase: phase, Kind: kind}
+	if list := rh.Roster().List; server >= 0 && server < len(list) {
+		b.Public = list[server].Public
+		b.Address = list[server].Address
+	}
+	rh.blames.add(b)
+}
+
+// Blame returns the failures the client has observed so far.
+func (rh *RandHound) Blame() *BlameReport {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	report := &BlameReport{}
+	report.merge(rh.blames)
+	return report
+}
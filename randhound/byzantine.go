@@ -0,0 +1,192 @@
+// +build byzantine
+
+package randhound
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-random"
+)
+
+// Byzantine servers are only available when building with the byzantine tag,
+// e.g. go test -tags byzantine. Without it, the handlers never deviate from
+// the protocol, see honest.go.
+
+// Behaviour describes how a server deviates from the protocol. It is used to
+// inject faults in tests and simulations.
+type Behaviour int
+
+// Supported server behaviours.
+const (
+	// Honest servers follow the protocol.
+	Honest Behaviour = iota
+	// BadShare servers send invalid encrypted and decrypted shares.
+	BadShare
+	// BadProof servers send invalid encryption and decryption consistency
+	// proofs.
+	BadProof
+	// BadHash servers reply with wrong HI1 and HI2 hashes.
+	BadHash
+	// StaleSID servers reply as if the messages belonged to another session.
+	StaleSID
+	// Silent servers never reply.
+	Silent
+)
+
+var behaviourNames = map[Behaviour]string{
+	Honest:   "honest",
+	BadShare: "badshare",
+	BadProof: "badproof",
+	BadHash:  "badhash",
+	StaleSID: "stalesid",
+	Silent:   "silent",
+}
+
+func (b Behaviour) String() string {
+	if name, ok := behaviourNames[b]; ok {
+		return name
+	}
+	return "Behaviour(" + strconv.Itoa(int(b)) + ")"
+}
+
+var behaviours = struct {
+	sync.Mutex
+	m map[network.ServerIdentityID]Behaviour
+}{m: make(map[network.ServerIdentityID]Behaviour)}
+
+// SetBehaviour makes the server with the given identity behave as b in all
+// following protocol runs. Setting Honest restores the normal behaviour.
+func SetBehaviour(si *network.ServerIdentity, b Behaviour) {
+	behaviours.Lock()
+	defer behaviours.Unlock()
+	if b == Honest {
+		delete(behaviours.m, si.ID)
+		return
+	}
+	behaviours.m[si.ID] = b
+}
+
+// faults is the behaviour of a server, with the methods the handlers use to
+// inject it.
+type faults Behaviour
+
+// faultsFor returns the faults injected in the given server.
+func faultsFor(si *network.ServerIdentity) faults {
+	behaviours.Lock()
+	defer behaviours.Unlock()
+	return faults(behaviours.m[si.ID])
+}
+
+// ParseByzantine parses a specification such as "badshare:3,silent:2" and
+// assigns the behaviours to the servers of a roster with the given number of
+// nodes. Servers are assigned starting with the last one of the roster, in
+// the order of the specification. The client at index 0 is never assigned.
+func ParseByzantine(spec string, nodes int) (map[int]Behaviour, error) {
+
+	byzantine := make(map[int]Behaviour)
+	next := nodes - 1
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid Byzantine entry %q", entry)
+		}
+
+		var b Behaviour
+		found := false
+		for k, name := range behaviourNames {
+			if name == strings.ToLower(strings.TrimSpace(parts[0])) {
+				b = k
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown Byzantine behaviour %q", parts[0])
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid number of servers in %q", entry)
+		}
+
+		for i := 0; i < n; i++ {
+			if next < 1 {
+				return nil, fmt.Errorf("Not enough servers for %q", spec)
+			}
+			byzantine[next] = b
+			next--
+		}
+	}
+
+	return byzantine, nil
+}
+
+// silent returns whether the server doesn't reply.
+func (f faults) silent() bool {
+	return Behaviour(f) == Silent
+}
+
+// i1 returns the I1 message the server answers, which belongs to another
+// session for StaleSID servers.
+func (f faults) i1(msg *I1) *I1 {
+	if Behaviour(f) != StaleSID {
+		return msg
+	}
+	stale := *msg
+	stale.SID = staleSID(msg.SID)
+	return &stale
+}
+
+// i2 returns the I2 message the server answers, which belongs to another
+// session for StaleSID servers.
+func (f faults) i2(msg *I2) *I2 {
+	if Behaviour(f) != StaleSID {
+		return msg
+	}
+	stale := *msg
+	stale.SID = staleSID(msg.SID)
+	return &stale
+}
+
+// hash returns the hash the server replies with, which is random for BadHash
+// servers.
+func (f faults) hash(h []byte) []byte {
+	if Behaviour(f) != BadHash {
+		return h
+	}
+	return random.Bytes(len(h), random.Stream)
+}
+
+// shares replaces the values or proofs of the given shares for BadShare and
+// BadProof servers.
+func (f faults) shares(suite abstract.Suite, share []Share) {
+	for i := range share {
+		switch Behaviour(f) {
+		case BadShare:
+			share[i].Val, _ = suite.Point().Pick(nil, random.Stream)
+		case BadProof:
+			share[i].Proof.R = suite.Scalar().Pick(random.Stream)
+		}
+	}
+}
+
+// staleSID returns a session identifier that differs from sid but has the same
+// length and version.
+func staleSID(sid []byte) []byte {
+	stale := make([]byte, len(sid))
+	copy(stale, sid)
+	if len(stale) > 0 {
+		stale[len(stale)-1] ^= 0xff
+	}
+	return stale
+}
@@ -0,0 +1,103 @@
+// +build byzantine
+
+package randhound_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mobilehound/log"
+	"mobilehound/randhound"
+)
+
+func TestRandHoundByzantine(t *testing.T) {
+
+	var nodes int = 16
+	var faulty int = 2
+	var groups int = 3
+	var purpose string = "RandHound Byzantine test"
+
+	local, servers, roster, tree := newLocal(nodes, false)
+	defer local.CloseAll()
+	defer func() {
+		for _, s := range servers {
+			randhound.SetBehaviour(s.ServerIdentity, randhound.Honest)
+		}
+	}()
+
+	for _, behaviour := range []string{"badshare", "badproof", "badhash", "stalesid", "silent"} {
+
+		spec := behaviour + ":" + strconv.Itoa(faulty)
+		byzantine, err := randhound.ParseByzantine(spec, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, si := range roster.List {
+			randhound.SetBehaviour(si, byzantine[i])
+		}
+
+		rh := newRandHound(t, local, tree, faulty, groups, purpose)
+		rh.SetTimeout(5*time.Second, 5*time.Second)
+		if err := rh.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := <-rh.Done; err != nil {
+			t.Fatal(spec, "- protocol failed:", err)
+		}
+		random, transcript, err := rh.Random()
+		if err != nil {
+			t.Fatal(spec, "- no randomness:", err)
+		}
+		if err := randhound.VerifyTranscript(rh.Suite(), random, transcript); err != nil {
+			t.Fatal(spec, "- verification failed:", err)
+		}
+		for _, b := range transcript.Blame.Blames {
+			if _, ok := byzantine[b.Server]; !ok {
+				t.Fatal(spec, "- honest server blamed:", b.Server, b.Kind)
+			}
+			if !b.Public.Equal(roster.List[b.Server].Public) {
+				t.Fatal(spec, "- wrong key in blame report")
+			}
+		}
+		data, err := json.Marshal(transcript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := randhound.TranscriptFromJSON(rh.Suite(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Blame.Servers(), transcript.Blame.Servers()) {
+			t.Fatal(spec, "- blame report lost in encoding")
+		}
+		log.Lvlf1("RandHound - %v: ok, blamed %v", spec, transcript.Blame.Servers())
+	}
+}
+
+func TestParseByzantine(t *testing.T) {
+	byzantine, err := randhound.ParseByzantine("badshare:2, silent:1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]randhound.Behaviour{
+		9: randhound.BadShare,
+		8: randhound.BadShare,
+		7: randhound.Silent,
+	}
+	if !reflect.DeepEqual(byzantine, expected) {
+		t.Fatal("Wrong assignment:", byzantine)
+	}
+
+	if b, err := randhound.ParseByzantine("", 10); err != nil || len(b) != 0 {
+		t.Fatal("Empty specification should assign nothing")
+	}
+	for _, spec := range []string{"evil:1", "silent", "silent:x", "silent:10"} {
+		if _, err := randhound.ParseByzantine(spec, 10); err == nil {
+			t.Fatal("Invalid specification accepted:", spec)
+		}
+	}
+}
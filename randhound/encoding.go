@@ -0,0 +1,272 @@
+package randhound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"mobilehound/crypto"
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+)
+
+// The RandHound messages I1, R1, I2 and R2 are signed and hashed over an
+// explicit byte encoding so that verifiers written in other languages can check
+// a transcript. The encoding of a message is the concatenation of the
+// following fields, where
+//
+//	u32(x)    is x as a 4-byte little-endian unsigned integer,
+//	u64(x)    is x as an 8-byte little-endian unsigned integer,
+//	bytes(b)  is u32(len(b)) followed by b,
+//	point(P)  is the fixed-size binary encoding of P (32 bytes for Ed25519),
+//	scalar(s) is the fixed-size binary encoding of s (32 bytes little-endian
+//	          for Ed25519),
+//	list(x)   is u32(len(x)) followed by the encoding of each element,
+//	share(s)  is u32(Source) u32(Target) u32(Pos) point(Val) scalar(Proof.C)
+//	          scalar(Proof.R) point(Proof.VG) point(Proof.VH):
+//
+//	I1:  bytes("RandHound/I1/v1") bytes(SID) u32(Threshold) list(u32(Group))
+//	     list(point(Key)) u32(Nodes) u32(Faulty) bytes(Purpose) u64(Time)
+//	     bytes(CliRand) list(u32(Thresholds)) list(u32(GroupSize))
+//	     list(u32(AllGroup))
+//	R1:  bytes("RandHound/R1/v1") bytes(HI1) list(share(EncShare))
+//	     bytes(CommitPoly)
+//	I2:  bytes("RandHound/I2/v1") bytes(SID) list(u32(ChosenSecret))
+//	     list(share(EncShare)) list(point(PolyCommit))
+//	R2:  bytes("RandHound/R2/v1") bytes(HI2) list(share(DecShare))
+//
+// The Sig field is never part of the encoding. Signatures are Schnorr
+// signatures over the encoding and the hashes HI1 and HI2 are the suite hash of
+// the encoding of I1 and I2, respectively.
+//
+// The session identifier of a version 1 run is a single version byte (0x01)
+// followed by the suite hash of
+//
+//	bytes("RandHound/SID/v1") u32(nodes) u32(faulty) bytes(purpose)
+//	u64(time) bytes(cliRand) point(cliKey) list(u32(threshold))
+//	list(list(point(serverKey)))
+//
+// where time is the initiation time in nanoseconds since the Unix epoch.
+//
+// Version 2 also binds the number of groups and the sharding configuration to
+// the session. Its session identifier starts with the version byte 0x02, and
+// the I1 message and the hashed session parameters use the tags
+// "RandHound/I1/v2" and "RandHound/SID/v2" and append
+//
+//	u32(Groups) u32(Sharding.Strategy) u32(Sharding.MinGroupSize)
+//
+// to the version 1 encoding. R1, I2 and R2 are encoded as in version 1.
+//
+// Legacy session identifiers are a plain hash without the version byte; for
+// those, messages are encoded with network.Marshal.
+
+// Versions of the message and session identifier encoding.
+const (
+	// VersionLegacy hashes and signs the network.Marshal output of a message.
+	VersionLegacy = 0
+	// Version1 hashes and signs the explicit encoding documented above.
+	Version1 = 1
+	// Version2 adds the number of groups and the sharding configuration to
+	// the version 1 encoding of I1 and the session identifier.
+	Version2 = 2
+	// CurrentVersion is the version used for new protocol runs.
+	CurrentVersion = Version2
+)
+
+// Domain separation tags of the version 1 and 2 encodings.
+const (
+	tagI1    = "RandHound/I1/v1"
+	tagR1    = "RandHound/R1/v1"
+	tagI2    = "RandHound/I2/v1"
+	tagR2    = "RandHound/R2/v1"
+	tagSID   = "RandHound/SID/v1"
+	tagI1v2  = "RandHound/I1/v2"
+	tagSIDv2 = "RandHound/SID/v2"
+)
+
+// Encode returns the version 1 encoding of the I1 message.
+func (i1 *I1) Encode() ([]byte, error) {
+	return i1.writer(tagI1).result()
+}
+
+// EncodeV2 returns the version 2 encoding of the I1 message.
+func (i1 *I1) EncodeV2() ([]byte, error) {
+	w := i1.writer(tagI1v2)
+	w.uint32(i1.Groups)
+	w.uint32(int(i1.Sharding.Strategy))
+	w.uint32(i1.Sharding.MinGroupSize)
+	return w.result()
+}
+
+// writer returns a writer holding the fields of the I1 message that are
+// common to version 1 and 2, starting with the given tag.
+func (i1 *I1) writer(tag string) *binaryWriter {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tag))
+	w.bytes(i1.SID)
+	w.uint32(i1.Threshold)
+	w.uint32s(i1.Group)
+	w.points(i1.Key)
+	w.uint32(i1.Nodes)
+	w.uint32(i1.Faulty)
+	w.bytes([]byte(i1.Purpose))
+	w.uint64(uint64(i1.Time))
+	w.bytes(i1.CliRand)
+	w.uint32s(i1.Thresholds)
+	w.uint32s(i1.GroupSize)
+	w.uint32s(i1.AllGroup)
+	return w
+}
+
+// Encode returns the version 1 encoding of the R1 message.
+func (r1 *R1) Encode() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagR1))
+	w.bytes(r1.HI1)
+	w.shares(r1.EncShare)
+	w.bytes(r1.CommitPoly)
+	return w.result()
+}
+
+// Encode returns the version 1 encoding of the I2 message.
+func (i2 *I2) Encode() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagI2))
+	w.bytes(i2.SID)
+	w.uint32s(i2.ChosenSecret)
+	w.shares(i2.EncShare)
+	w.points(i2.PolyCommit)
+	return w.result()
+}
+
+// Encode returns the version 1 encoding of the R2 message.
+func (r2 *R2) Encode() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tagR2))
+	w.bytes(r2.HI2)
+	w.shares(r2.DecShare)
+	return w.result()
+}
+
+// encoder is implemented by all RandHound messages.
+type encoder interface {
+	Encode() ([]byte, error)
+}
+
+// SessionVersion returns the encoding version of a session identifier.
+func SessionVersion(suite abstract.Suite, sid []byte) (int, error) {
+	n := suite.Hash().Size()
+	switch {
+	case len(sid) == n:
+		return VersionLegacy, nil
+	case len(sid) == n+1 && sid[0] == Version1:
+		return Version1, nil
+	case len(sid) == n+1 && sid[0] == Version2:
+		return Version2, nil
+	}
+	return 0, errors.New("Unknown session identifier version")
+}
+
+// encodeMessage returns the bytes of m that are signed and hashed for the given
+// version. The Sig field of m is ignored.
+func encodeMessage(m interface{}, version int) ([]byte, error) {
+
+	switch version {
+	case Version1, Version2:
+		if i1, ok := m.(*I1); ok && version == Version2 {
+			return i1.EncodeV2()
+		}
+		e, ok := m.(encoder)
+		if !ok {
+			return nil, fmt.Errorf("Cannot encode message of type %T", m)
+		}
+		return e.Encode()
+
+	case VersionLegacy:
+		// Make a copy of the signature
+		x := reflect.ValueOf(m).Elem().FieldByName("Sig")
+		sig := reflect.New(x.Type()).Elem()
+		sig.Set(x)
+
+		// Reset signature field
+		reflect.ValueOf(m).Elem().FieldByName("Sig").Set(reflect.ValueOf(crypto.SchnorrSig{})) // XXX: hack
+
+		// Marshal message
+		mb, err := network.Marshal(m)
+
+		// Copy back original signature
+		reflect.ValueOf(m).Elem().FieldByName("Sig").Set(sig) // XXX: hack
+
+		return mb, err
+	}
+
+	return nil, fmt.Errorf("Unknown encoding version %v", version)
+}
+
+// hashMessage returns the suite hash of the encoding of m.
+func hashMessage(suite abstract.Suite, m interface{}, version int) ([]byte, error) {
+	mb, err := encodeMessage(m, version)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.HashBytes(suite.Hash(), mb)
+}
+
+func sessionIDv1(suite abstract.Suite, nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point) ([]byte, error) {
+	w, err := sessionWriter(tagSID, nodes, faulty, purpose, time, rand, threshold, clientKey, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return versionedHash(suite, Version1, w)
+}
+
+func sessionIDv2(suite abstract.Suite, nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point, groups int, sharding ShardConfig) ([]byte, error) {
+	w, err := sessionWriter(tagSIDv2, nodes, faulty, purpose, time, rand, threshold, clientKey, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	w.uint32(groups)
+	w.uint32(int(sharding.Strategy))
+	w.uint32(sharding.MinGroupSize)
+	return versionedHash(suite, Version2, w)
+}
+
+// sessionWriter returns a writer holding the session parameters that are
+// common to version 1 and 2, starting with the given tag.
+func sessionWriter(tag string, nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point) (*binaryWriter, error) {
+
+	if len(threshold) != len(serverKey) {
+		return nil, fmt.Errorf("Non-matching number of group thresholds and keys")
+	}
+
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.bytes([]byte(tag))
+	w.uint32(nodes)
+	w.uint32(faulty)
+	w.bytes([]byte(purpose))
+	w.uint64(uint64(time.UnixNano()))
+	w.bytes(rand)
+	w.marshal(clientKey)
+	w.ints(threshold)
+	w.uint32(len(serverKey))
+	for _, gk := range serverKey {
+		w.points(gk)
+	}
+	return w, nil
+}
+
+// versionedHash returns the version byte followed by the suite hash of the
+// bytes written to w.
+func versionedHash(suite abstract.Suite, version int, w *binaryWriter) ([]byte, error) {
+	b, err := w.result()
+	if err != nil {
+		return nil, err
+	}
+	h, err := crypto.HashBytes(suite.Hash(), b)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(version)}, h...), nil
+}
@@ -0,0 +1,242 @@
+package randhound
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"mobilehound/crypto"
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-config"
+)
+
+// Test vectors for the version 1 encoding documented in encoding.go, using
+// the Ed25519 suite of the network package.
+const (
+	vectorI1  = "0f00000052616e64486f756e642f49312f763103000000010203020000000200000000000000010000000200000058666666666666666666666666666666666666666666666666666666666666660100000000000000000000000000000000000000000000000000000000000000030000000000000004000000746573740000167b0d12d11401000000ff01000000010000000100000002000000020000000000000001000000"
+	vectorHI1 = "89ab0332bfc44a929a187aef27926b55bbc5ef8f192a67467dcb99040f6b171b"
+	vectorR2  = "0f00000052616e64486f756e642f52322f763104000000aaaaaaaa0100000001000000020000000000000058666666666666666666666666666666666666666666666666666666666666660100000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000001000000000000000000000000000000000000000000000000000000000000005866666666666666666666666666666666666666666666666666666666666666"
+	vectorSID = "01854d5c36247ca1d9b441909ada354786961559aea76b2f7af4c848f6c0337997"
+)
+
+// Test vectors for the version 2 encoding of I1 and the session identifier.
+const (
+	vectorI1v2  = "0f00000052616e64486f756e642f49312f763203000000010203020000000200000000000000010000000200000058666666666666666666666666666666666666666666666666666666666666660100000000000000000000000000000000000000000000000000000000000000030000000000000004000000746573740000167b0d12d11401000000ff01000000010000000100000002000000020000000000000001000000010000000000000002000000"
+	vectorHI1v2 = "5948389057c18f432927f2a32dc4ea0f63a72e07149b64767259526cdc6ec007"
+	vectorSIDv2 = "02e6114f8ad0f749b9293aadfe2c9d26aa2241b32f0fc538bf95c7b242c588e851"
+)
+
+func TestEncodingVectors(t *testing.T) {
+	suite := network.Suite
+	base := suite.Point().Base()
+	null := suite.Point().Null()
+
+	i1 := &I1{
+		Sig:        crypto.SchnorrSig{0x01},
+		SID:        []byte{1, 2, 3},
+		Threshold:  2,
+		Group:      []uint32{0, 1},
+		Key:        []abstract.Point{base, null},
+		Nodes:      3,
+		Faulty:     0,
+		Purpose:    "test",
+		Time:       time.Unix(1500000000, 0).UnixNano(),
+		CliRand:    []byte{0xff},
+		Thresholds: []uint32{1},
+		GroupSize:  []uint32{2},
+		AllGroup:   []uint32{0, 1},
+		Groups:     1,
+		Sharding:   ShardConfig{MinGroupSize: 2},
+	}
+	b, err := i1.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(b) != vectorI1 {
+		t.Fatal("Wrong I1 encoding:", hex.EncodeToString(b))
+	}
+	h, err := hashMessage(suite, i1, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(h) != vectorHI1 {
+		t.Fatal("Wrong I1 hash:", hex.EncodeToString(h))
+	}
+	b, err = i1.EncodeV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(b) != vectorI1v2 {
+		t.Fatal("Wrong version 2 I1 encoding:", hex.EncodeToString(b))
+	}
+	h, err = hashMessage(suite, i1, Version2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(h) != vectorHI1v2 {
+		t.Fatal("Wrong version 2 I1 hash:", hex.EncodeToString(h))
+	}
+
+	r2 := &R2{
+		HI2: []byte{0xaa, 0xaa, 0xaa, 0xaa},
+		DecShare: []Share{{
+			Source: 1,
+			Target: 2,
+			Pos:    0,
+			Val:    base,
+			Proof: ProofCore{
+				C:  suite.Scalar().SetInt64(1),
+				R:  suite.Scalar().SetInt64(2),
+				VG: null,
+				VH: base,
+			},
+		}},
+	}
+	b, err = r2.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(b) != vectorR2 {
+		t.Fatal("Wrong R2 encoding:", hex.EncodeToString(b))
+	}
+
+	sid, err := sessionID(suite, Version1, 3, 0, "test", time.Unix(1500000000, 0), []byte{0xff}, []int{1}, base, [][]abstract.Point{{base, null}}, 1, ShardConfig{MinGroupSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sid) != vectorSID {
+		t.Fatal("Wrong session identifier:", hex.EncodeToString(sid))
+	}
+	if v, err := SessionVersion(suite, sid); err != nil || v != Version1 {
+		t.Fatal("Wrong session version", v, err)
+	}
+	sid, err = sessionID(suite, Version2, 3, 0, "test", time.Unix(1500000000, 0), []byte{0xff}, []int{1}, base, [][]abstract.Point{{base, null}}, 1, ShardConfig{MinGroupSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sid) != vectorSIDv2 {
+		t.Fatal("Wrong version 2 session identifier:", hex.EncodeToString(sid))
+	}
+	if v, err := SessionVersion(suite, sid); err != nil || v != Version2 {
+		t.Fatal("Wrong session version", v, err)
+	}
+}
+
+func TestEncodingVersions(t *testing.T) {
+	suite := network.Suite
+	kp := config.NewKeyPair(suite)
+
+	for _, version := range []int{VersionLegacy, Version1, Version2} {
+		sid, err := sessionID(suite, version, 3, 0, "test", time.Now(), []byte{0xff}, []int{1}, kp.Public, [][]abstract.Point{{kp.Public}}, 1, ShardConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := SessionVersion(suite, sid); err != nil || v != version {
+			t.Fatal("Wrong session version", v, err)
+		}
+
+		i1 := &I1{SID: sid, Threshold: 1, Group: []uint32{1}, Key: []abstract.Point{kp.Public}}
+		if err := signSchnorr(suite, kp.Secret, i1, version); err != nil {
+			t.Fatal(err)
+		}
+		if err := verifySchnorr(suite, kp.Public, i1, version); err != nil {
+			t.Fatal("Signature does not verify:", err)
+		}
+		h, err := hashMessage(suite, i1, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyMessage(suite, i1, h, version); err != nil {
+			t.Fatal(err)
+		}
+
+		i1.Threshold = 2
+		if err := verifySchnorr(suite, kp.Public, i1, version); err == nil {
+			t.Fatal("Signature of modified message should not verify")
+		}
+	}
+
+	if _, err := SessionVersion(suite, []byte{2, 3}); err == nil {
+		t.Fatal("Unknown session identifier should be rejected")
+	}
+}
+
+func TestTranscriptVersion1(t *testing.T) {
+	suite := network.Suite
+	kp := config.NewKeyPair(suite)
+	now := time.Unix(1500000000, 0)
+
+	// A version 1 transcript has no session parameters in the I1 messages,
+	// no blame report and no sharding configuration
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+	w.uint32(1)
+	w.bytes([]byte{1, 2, 3})
+	w.uint32(2)
+	w.uint32(1)
+	w.uint32(0)
+	w.bytes([]byte("test"))
+	tb, err := now.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bytes(tb)
+	w.bytes([]byte{0xff})
+	w.marshal(kp.Public)
+	w.uint32(1)
+	w.ints([]int{1})
+	w.uint32(1)
+	w.points([]abstract.Point{kp.Public})
+	w.ints([]int{1})
+	w.uint32(0)
+	w.uint32(1)
+	w.uint32(0)
+	w.bytes([]byte{0x01})
+	w.bytes([]byte{1, 2, 3})
+	w.uint32(1)
+	w.uint32s([]uint32{1})
+	w.points([]abstract.Point{kp.Public})
+	w.uint32(0)
+	w.uint32(0)
+	w.uint32(0)
+	b, err := w.result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := TranscriptFromBinary(suite, b)
+	if err != nil {
+		t.Fatal("Couldn't decode version 1 transcript:", err)
+	}
+	if tr.Purpose != "test" || !tr.Time.Equal(now) || len(tr.I1s) != 1 ||
+		tr.I1s[0].Threshold != 1 || !tr.I1s[0].Key[0].Equal(kp.Public) {
+		t.Fatal("Wrong version 1 transcript:", tr)
+	}
+
+	// Transcripts are always written in the current version
+	b2, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr2, err := TranscriptFromBinary(suite, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr2.Purpose != tr.Purpose || len(tr2.I1s) != 1 {
+		t.Fatal("Wrong re-encoded transcript:", tr2)
+	}
+
+	// Single servers make up a group with threshold 0
+	tr.Threshold[0] = 0
+	if err := tr.check(); err != nil {
+		t.Fatal("Threshold 0 should be accepted:", err)
+	}
+	tr.Threshold[0] = 2
+	if err := tr.check(); err == nil {
+		t.Fatal("Threshold above the group size should be rejected")
+	}
+	if _, err := TranscriptFromBinary(suite, append([]byte{3, 0, 0, 0}, b[4:]...)); err == nil {
+		t.Fatal("Unknown transcript version should be rejected")
+	}
+}
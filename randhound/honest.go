@@ -0,0 +1,35 @@
+// +build !byzantine
+
+package randhound
+
+import (
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+)
+
+// faults injects nothing: servers only deviate from the protocol when
+// building with the byzantine tag, see byzantine.go.
+type faults struct{}
+
+// faultsFor returns the faults injected in the given server.
+func faultsFor(si *network.ServerIdentity) faults {
+	return faults{}
+}
+
+func (f faults) silent() bool {
+	return false
+}
+
+func (f faults) i1(msg *I1) *I1 {
+	return msg
+}
+
+func (f faults) i2(msg *I2) *I2 {
+	return msg
+}
+
+func (f faults) hash(h []byte) []byte {
+	return h
+}
+
+func (f faults) shares(suite abstract.Suite, share []Share) {}
@@ -3,11 +3,11 @@ package randhound_test
 import (
 	"testing"
 
-	"github.com/dedis/cothority/randhound"
+	"mobilehound/log"
+	"mobilehound/randhound"
 	"mobilehound/v0-abstract"
 	"mobilehound/v0-edwards"
 	"mobilehound/v0-random"
-	"mobilehound/log"
 )
 
 func TestProof(t *testing.T) {
@@ -10,20 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 	"mobilehound/onet"
 	"mobilehound/v0-abstract"
 	"mobilehound/v0-random"
 	"mobilehound/crypto"
 	"mobilehound/log"
-	"mobilehound/network"
 )
 
-// TODO:
-// - Import / export transcript in JSON
-// - Signatures of I-messages are currently not checked by the servers since
-//	 the latter are assumed to be stateless; should they know the public key of the client?
-
 func init() {
 	onet.GlobalProtocolRegister("RandHound", NewRandHound)
 }
@@ -54,6 +49,7 @@ func (rh *RandHound) Setup(nodes int, faulty int, groups int, purpose string) er
 	rh.groups = groups
 	rh.faulty = faulty
 	rh.purpose = purpose
+	rh.version = CurrentVersion
 
 	rh.server = make([][]*onet.TreeNode, groups)
 	rh.group = make([][]int, groups)
@@ -70,12 +66,33 @@ func (rh *RandHound) Setup(nodes int, faulty int, groups int, purpose string) er
 	rh.secret = make(map[int][]int)
 	rh.chosenSecret = make(map[int][]int)
 
-	rh.Done = make(chan bool, 1)
+	rh.blames = &BlameReport{}
+	rh.Done = make(chan error, 1)
 	rh.SecretReady = false
 
 	return nil
 }
 
+// SetTimeout configures the deadlines for collecting the R1 and R2 messages,
+// respectively. When a deadline passes, the client continues with the replies
+// received so far if they suffice and otherwise sends a TimeoutError on Done.
+// A zero duration waits forever. Needs to be called before Start.
+func (rh *RandHound) SetTimeout(r1 time.Duration, r2 time.Duration) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	rh.timeoutR1 = r1
+	rh.timeoutR2 = r2
+}
+
+// SetProgress installs a callback that is informed about every reply the
+// client records. The callback is run with the protocol lock held and must
+// not block. Needs to be called before Start.
+func (rh *RandHound) SetProgress(f ProgressFunc) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	rh.progress = f
+}
+
 // Start initiates the RandHound protocol run. The client pseudo-randomly
 // chooses the server grouping, forms an I1 message for each group, and sends
 // it to all servers of that group.
@@ -96,7 +113,10 @@ func (rh *RandHound) Start() error {
 		return err
 	}
 
-	// Set some group parameters
+	// Set some group parameters; the number of groups can be smaller than
+	// requested if the sharding asks for a minimum group size
+	rh.group = make([][]int, len(rh.server))
+	rh.threshold = make([]int, len(rh.server))
 	for i, group := range rh.server {
 		rh.threshold[i] = 2 * len(group) / 3
 		rh.polyCommit[i] = make([]abstract.Point, len(group))
@@ -111,11 +131,28 @@ func (rh *RandHound) Start() error {
 	}
 
 	// Compute session id
-	rh.sid, err = rh.sessionID(rh.nodes, rh.faulty, rh.purpose, rh.time, rh.cliRand, rh.threshold, rh.Public(), rh.key)
+	rh.sid, err = sessionID(rh.Suite(), rh.version, rh.nodes, rh.faulty, rh.purpose, rh.time, rh.cliRand, rh.threshold, rh.Public(), rh.key, rh.groups, rh.sharding)
 	if err != nil {
 		return err
 	}
 
+	// Start the deadline for the first phase
+	rh.mutex.Lock()
+	rh.setTimer(rh.timeoutR1, rh.expireR1)
+	rh.mutex.Unlock()
+
+	// Flatten the session grouping to avoid protobuf errors
+	thresholds := make([]uint32, len(rh.group))
+	groupSize := make([]uint32, len(rh.group))
+	var allGroup []uint32
+	for i, group := range rh.group {
+		thresholds[i] = uint32(rh.threshold[i])
+		groupSize[i] = uint32(len(group))
+		for _, s := range group {
+			allGroup = append(allGroup, uint32(s))
+		}
+	}
+
 	// Multicast first message to grouped servers
 	for i, group := range rh.server {
 
@@ -125,16 +162,26 @@ func (rh *RandHound) Start() error {
 		}
 
 		i1 := &I1{
-			SID:       rh.sid,
-			Threshold: rh.threshold[i],
-			Group:     index,
-			Key:       rh.key[i],
+			SID:        rh.sid,
+			Threshold:  rh.threshold[i],
+			Group:      index,
+			Key:        rh.key[i],
+			Nodes:      rh.nodes,
+			Faulty:     rh.faulty,
+			Purpose:    rh.purpose,
+			Time:       rh.time.UnixNano(),
+			CliRand:    rh.cliRand,
+			Thresholds: thresholds,
+			GroupSize:  groupSize,
+			AllGroup:   allGroup,
+			Groups:     rh.groups,
+			Sharding:   rh.sharding,
 		}
 
 		rh.mutex.Lock()
 
 		// Sign I1 and store signature in i1.Sig
-		if err := signSchnorr(rh.Suite(), rh.Private(), i1); err != nil {
+		if err := signSchnorr(rh.Suite(), rh.Private(), i1, rh.version); err != nil {
 			rh.mutex.Unlock()
 			return err
 		}
@@ -151,34 +198,45 @@ func (rh *RandHound) Start() error {
 }
 
 // Shard produces a pseudorandom sharding of the network entity list
-// based on a seed and a number of requested shards.
+// based on a seed and a number of requested shards. The grouping is computed
+// with the package-level Shard function and the configured ShardConfig.
 func (rh *RandHound) Shard(seed []byte, shards int) ([][]*onet.TreeNode, [][]abstract.Point, error) {
 
-	if shards == 0 || rh.nodes < shards {
-		return nil, nil, fmt.Errorf("Number of requested shards not supported")
+	if rh.nodes != len(rh.Roster().List) {
+		return nil, nil, errors.New("Number of nodes does not match the roster")
 	}
-
-	// Compute a random permutation of [1,n-1]
-	prng := rh.Suite().Cipher(seed)
-	m := make([]int, rh.nodes-1)
-	for i := range m {
-		j := int(random.Uint64(prng) % uint64(i+1))
-		m[i] = m[j]
-		m[j] = i + 1
+	group, keys, err := Shard(rh.Suite(), seed, rh.Roster(), shards, rh.sharding)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Create sharding of the current roster according to the above permutation
-	el := rh.List()
-	sharding := make([][]*onet.TreeNode, shards)
-	keys := make([][]abstract.Point, shards)
-	for i, j := range m {
-		sharding[i%shards] = append(sharding[i%shards], el[j])
-		keys[i%shards] = append(keys[i%shards], el[j].ServerIdentity.Public)
+	// Map the roster indices to the tree nodes
+	node := make(map[int]*onet.TreeNode)
+	for _, tn := range rh.List() {
+		node[tn.RosterIndex] = tn
+	}
+	sharding := make([][]*onet.TreeNode, len(group))
+	for i, g := range group {
+		for _, idx := range g {
+			tn, ok := node[idx]
+			if !ok {
+				return nil, nil, fmt.Errorf("Server %v is not part of the tree", idx)
+			}
+			sharding[i] = append(sharding[i], tn)
+		}
 	}
 
 	return sharding, keys, nil
 }
 
+// SetSharding configures how the servers are split into groups. Needs to be
+// called before Start.
+func (rh *RandHound) SetSharding(config ShardConfig) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	rh.sharding = config
+}
+
 // Random creates the collective randomness from the shares and the protocol
 // transcript.
 func (rh *RandHound) Random() ([]byte, *Transcript, error) {
@@ -226,6 +284,7 @@ func (rh *RandHound) Random() ([]byte, *Transcript, error) {
 		SID:          rh.sid,
 		Nodes:        rh.nodes,
 		Groups:       rh.groups,
+		Sharding:     rh.sharding,
 		Faulty:       rh.faulty,
 		Purpose:      rh.purpose,
 		Time:         rh.time,
@@ -239,7 +298,9 @@ func (rh *RandHound) Random() ([]byte, *Transcript, error) {
 		I2s:          rh.i2s,
 		R1s:          rh.r1s,
 		R2s:          rh.r2s,
+		Blame:        &BlameReport{},
 	}
+	transcript.Blame.merge(rh.blames)
 
 	return rb, transcript, nil
 }
@@ -250,20 +311,70 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 	rh.mutex.Lock()
 	defer rh.mutex.Unlock()
 
+	report, err := AuditTranscript(suite, random, t)
+	if bytes.Equal(t.SID, rh.sid) {
+		rh.blames.merge(report)
+	}
+	return err
+}
+
+// VerifyTranscript checks a given collective random string against a protocol
+// transcript. In contrast to RandHound.Verify it does not need a protocol
+// instance and can therefore be used to audit published randomness offline.
+func VerifyTranscript(suite abstract.Suite, random []byte, t *Transcript) error {
+	_, err := AuditTranscript(suite, random, t)
+	return err
+}
+
+// AuditTranscript works like VerifyTranscript but additionally returns the
+// servers whose shares of the chosen secrets failed their consistency proofs.
+func AuditTranscript(suite abstract.Suite, random []byte, t *Transcript) (*BlameReport, error) {
+
+	report := &BlameReport{}
+	blame := func(i int, pos int, phase int, kind FailureKind) {
+		if i < 0 || i >= len(t.Group) || pos < 0 || pos >= len(t.Group[i]) {
+			return
+		}
+		report.add(Blame{Server: t.Group[i][pos], Public: t.Key[i][pos], Phase: phase, Kind: kind})
+	}
+
+	// Reject malformed transcripts before indexing into them
+	if err := t.check(); err != nil {
+		return report, err
+	}
+
 	// Verify SID
-	sid, err := rh.sessionID(t.Nodes, t.Faulty, t.Purpose, t.Time, t.CliRand, t.Threshold, t.CliKey, t.Key)
+	version, err := SessionVersion(suite, t.SID)
 	if err != nil {
-		return err
+		return report, err
+	}
+	sid, err := sessionID(suite, version, t.Nodes, t.Faulty, t.Purpose, t.Time, t.CliRand, t.Threshold, t.CliKey, t.Key, t.Groups, t.Sharding)
+	if err != nil {
+		return report, err
 	}
 
 	if !bytes.Equal(t.SID, sid) {
-		return fmt.Errorf("Wrong session identifier")
+		return report, fmt.Errorf("Wrong session identifier")
+	}
+
+	// Verify that the grouping has been derived from the client randomness
+	group, err := ShardIndices(suite, t.CliRand, t.Nodes, t.Groups, t.Sharding)
+	if err != nil {
+		return report, err
+	}
+	if len(group) != len(t.Group) || len(group) != len(t.Key) {
+		return report, errors.New("Wrong number of groups")
+	}
+	for i := range group {
+		if !reflect.DeepEqual(group[i], t.Group[i]) || len(group[i]) != len(t.Key[i]) {
+			return report, errors.New("Grouping does not match the client randomness")
+		}
 	}
 
 	// Verify I1 signatures
 	for _, i1 := range t.I1s {
-		if err := verifySchnorr(suite, t.CliKey, i1); err != nil {
-			return err
+		if err := verifySchnorr(suite, t.CliKey, i1, version); err != nil {
+			return report, err
 		}
 	}
 
@@ -277,15 +388,18 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 				}
 			}
 		}
-		if err := verifySchnorr(suite, key, r1); err != nil {
-			return err
+		if key == nil {
+			return report, fmt.Errorf("R1 message of unknown server %v", src)
+		}
+		if err := verifySchnorr(suite, key, r1, version); err != nil {
+			return report, err
 		}
 	}
 
 	// Verify I2 signatures
 	for _, i2 := range t.I2s {
-		if err := verifySchnorr(suite, t.CliKey, i2); err != nil {
-			return err
+		if err := verifySchnorr(suite, t.CliKey, i2, version); err != nil {
+			return report, err
 		}
 	}
 
@@ -299,8 +413,11 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 				}
 			}
 		}
-		if err := verifySchnorr(suite, key, r2); err != nil {
-			return err
+		if key == nil {
+			return report, fmt.Errorf("R2 message of unknown server %v", src)
+		}
+		if err := verifySchnorr(suite, key, r2, version); err != nil {
+			return report, err
 		}
 	}
 
@@ -309,8 +426,8 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 	for i, msg := range t.I1s {
 		for _, j := range t.Group[i] {
 			if _, ok := t.R1s[j]; ok {
-				if err := verifyMessage(suite, msg, t.R1s[j].HI1); err != nil {
-					return err
+				if err := verifyMessage(suite, msg, t.R1s[j].HI1, version); err != nil {
+					return report, err
 				}
 			} else {
 				log.Lvlf2("Couldn't find R1 message of server %v", j)
@@ -320,8 +437,8 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 
 	for i, msg := range t.I2s {
 		if _, ok := t.R2s[i]; ok {
-			if err := verifyMessage(suite, msg, t.R2s[i].HI2); err != nil {
-				return err
+			if err := verifyMessage(suite, msg, t.R2s[i].HI2, version); err != nil {
+				return report, err
 			}
 		} else {
 			log.Lvlf2("Couldn't find R2 message of server %v", i)
@@ -334,8 +451,8 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 		// Deterministically iterate over map[int][]int
 		for i := 0; i < len(t.ChosenSecret); i++ {
 			for _, cs := range t.ChosenSecret[i] {
-				if int(msg.ChosenSecret[c]) != cs {
-					return fmt.Errorf("Server %v received wrong client commitment", server)
+				if c >= len(msg.ChosenSecret) || int(msg.ChosenSecret[c]) != cs {
+					return report, fmt.Errorf("Server %v received wrong client commitment", server)
 				}
 				c++
 			}
@@ -360,7 +477,7 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 
 			// All R1 messages of the chosen secrets should be there
 			if _, ok := t.R1s[src]; !ok {
-				return errors.New("R1 message not found")
+				return report, errors.New("R1 message not found")
 			}
 			r1 := t.R1s[src]
 
@@ -368,6 +485,10 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 
 				// Check availability of corresponding R2 messages, skip if not there
 				target := r1.EncShare[j].Target
+				pos := r1.EncShare[j].Pos
+				if pos < 0 || pos >= len(t.Key[i]) {
+					return report, fmt.Errorf("Encrypted share of server %v out of range", src)
+				}
 				if _, ok := t.R2s[target]; !ok {
 					continue
 				}
@@ -377,12 +498,15 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 				encPos = append(encPos, r1.EncShare[j].Pos)
 				encShare = append(encShare, r1.EncShare[j].Val)
 				encProof = append(encProof, r1.EncShare[j].Proof)
-				X = append(X, t.Key[i][r1.EncShare[j].Pos])
+				X = append(X, t.Key[i][pos])
 
 				// Gather data on decrypted shares
 				r2 := t.R2s[target]
 				for k := 0; k < len(r2.DecShare); k++ {
 					if r2.DecShare[k].Source == src {
+						if p := r2.DecShare[k].Pos; p < 0 || p >= len(t.Group[i]) {
+							return report, fmt.Errorf("Decrypted share of server %v out of range", target)
+						}
 						decPos = append(decPos, r2.DecShare[k].Pos)
 						decShare = append(decShare, r2.DecShare[k].Val)
 						decProof = append(decProof, r2.DecShare[k].Proof)
@@ -393,6 +517,9 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 			// Remove encrypted shares that do not have a corresponding decrypted share
 			j := 0
 			for j < len(decPos) {
+				if j >= len(encPos) {
+					return report, fmt.Errorf("Decrypted shares of secret %v without encrypted share", src)
+				}
 				if encPos[j] != decPos[j] {
 					poly = append(poly[:j], poly[j+1:]...)
 					encPos = append(encPos[:j], encPos[j+1:]...)
@@ -418,33 +545,44 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 			// Recover polynomial commits
 			polyCommit, err := pvss.Commits(poly, encPos)
 			if err != nil {
-				return err
+				return report, err
 			}
 
 			// Check encryption consistency proofs
-			goodEnc, badEnc, err := pvss.Verify(H, X, polyCommit, encShare, encProof)
+			_, badEnc, err := pvss.Verify(H, X, polyCommit, encShare, encProof)
 			if err != nil {
-				return err
+				return report, err
+			}
+
+			// Blame the source server for bad encrypted shares
+			if len(badEnc) > 0 {
+				for pos, server := range t.Group[i] {
+					if server == src {
+						blame(i, pos, 1, BadEncryptionProof)
+					}
+				}
 			}
-			_ = goodEnc
-			_ = badEnc
 
 			// Remove bad values
 			for j := len(badEnc) - 1; j >= 0; j-- {
 				k := badEnc[j]
 				X = append(X[:k], X[k+1:]...)
 				encShare = append(encShare[:k], encShare[k+1:]...)
+				decPos = append(decPos[:k], decPos[k+1:]...)
 				decShare = append(decShare[:k], decShare[k+1:]...)
 				decProof = append(decProof[:k], decProof[k+1:]...)
 			}
 
 			// Check decryption consistency proofs
-			goodDec, badDec, err := pvss.Verify(suite.Point().Base(), decShare, X, encShare, decProof)
+			_, badDec, err := pvss.Verify(suite.Point().Base(), decShare, X, encShare, decProof)
 			if err != nil {
-				return err
+				return report, err
+			}
+
+			// Blame the target servers for bad decrypted shares
+			for _, k := range badDec {
+				blame(i, decPos[k], 2, BadDecryptionProof)
 			}
-			_ = goodDec
-			_ = badDec
 
 			// Remove bad shares
 			for j := len(badDec) - 1; j >= 0; j-- {
@@ -456,39 +594,56 @@ func (rh *RandHound) Verify(suite abstract.Suite, random []byte, t *Transcript)
 			// Recover secret and add it to the collective random point
 			ps, err := pvss.Recover(decPos, decShare, len(t.Group[i]))
 			if err != nil {
-				return err
+				return report, err
 			}
-			rnd = rh.Suite().Point().Add(rnd, ps)
+			rnd = suite.Point().Add(rnd, ps)
 		}
 	}
 
 	rb, err := rnd.MarshalBinary()
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	if !bytes.Equal(random, rb) {
-		return errors.New("Bad randomness")
+		return report, errors.New("Bad randomness")
 	}
 
-	return nil
+	return report, nil
 }
 
 func (rh *RandHound) handleI1(i1 WI1) error {
 
 	msg := &i1.I1
 
-	// Compute hash of the client's message
-	msg.Sig = crypto.SchnorrSig{} // XXX: hack
-	i1b, err := network.Marshal(msg)
+	// Determine the encoding version from the session identifier
+	version, err := SessionVersion(rh.Suite(), msg.SID)
 	if err != nil {
 		return err
 	}
 
-	hi1, err := crypto.HashBytes(rh.Suite().Hash(), i1b)
+	// Check authorization and session identifier, if the server is configured
+	// to do so
+	if state := rh.serverState(); state != nil {
+		if err := state.checkI1(rh.Suite(), rh.Roster(), msg, version); err != nil {
+			return err
+		}
+	}
+
+	// Injected faults, only with the byzantine build tag
+	byz := faultsFor(rh.ServerIdentity())
+	if byz.silent() {
+		return nil
+	}
+	msg = byz.i1(msg)
+	sid := msg.SID
+
+	// Compute hash of the client's message
+	hi1, err := hashMessage(rh.Suite(), msg, version)
 	if err != nil {
 		return err
 	}
+	hi1 = byz.hash(hi1)
 
 	// Find out the server's index (we assume servers are stateless)
 	idx := 0
@@ -500,7 +655,7 @@ func (rh *RandHound) handleI1(i1 WI1) error {
 	}
 
 	// Init PVSS and create shares
-	H, _ := rh.Suite().Point().Pick(nil, rh.Suite().Cipher(msg.SID))
+	H, _ := rh.Suite().Point().Pick(nil, rh.Suite().Cipher(sid))
 	pvss := NewPVSS(rh.Suite(), H, msg.Threshold)
 	idxShare, encShare, encProof, pb, err := pvss.Split(msg.Key, nil)
 	if err != nil {
@@ -517,6 +672,7 @@ func (rh *RandHound) handleI1(i1 WI1) error {
 			Proof:  encProof[i],
 		}
 	}
+	byz.shares(rh.Suite(), share)
 
 	r1 := &R1{
 		HI1:        hi1,
@@ -525,7 +681,7 @@ func (rh *RandHound) handleI1(i1 WI1) error {
 	}
 
 	// Sign R1 and store signature in R1.Sig
-	if err := signSchnorr(rh.Suite(), rh.Private(), r1); err != nil {
+	if err := signSchnorr(rh.Suite(), rh.Private(), r1, version); err != nil {
 		return err
 	}
 
@@ -543,18 +699,28 @@ func (rh *RandHound) handleR1(r1 WR1) error {
 	rh.mutex.Lock()
 	defer rh.mutex.Unlock()
 
+	// Ignore late messages if the protocol run has already finished
+	if rh.finished {
+		return nil
+	}
+
 	// Verify R1 message signature
-	if err := verifySchnorr(rh.Suite(), rh.key[grp][pos], msg); err != nil {
+	if err := verifySchnorr(rh.Suite(), rh.key[grp][pos], msg, rh.version); err != nil {
+		rh.blame(idx, 1, BadSignature)
 		return err
 	}
 
 	// Verify that server replied to the correct I1 message
-	if err := verifyMessage(rh.Suite(), rh.i1s[grp], msg.HI1); err != nil {
+	if err := verifyMessage(rh.Suite(), rh.i1s[grp], msg.HI1, rh.version); err != nil {
+		rh.blame(idx, 1, BadReplyHash)
 		return err
 	}
 
 	// Record R1 message
 	rh.r1s[idx] = msg
+	if rh.progress != nil {
+		rh.progress(1, len(rh.r1s), rh.nodes-1)
+	}
 
 	// Prepare data for recovery of polynomial commits and verification of shares
 	n := len(msg.EncShare)
@@ -586,10 +752,13 @@ func (rh *RandHound) handleR1(r1 WR1) error {
 	}
 
 	// Verify encrypted shares
-	good, _, err := pvss.Verify(H, rh.key[grp], polyCommit, encShare, encProof)
+	good, bad, err := pvss.Verify(H, rh.key[grp], polyCommit, encShare, encProof)
 	if err != nil {
 		return err
 	}
+	if len(bad) > 0 {
+		rh.blame(idx, 1, BadEncryptionProof)
+	}
 
 	// Record valid encrypted shares per secret/server
 	for _, g := range good {
@@ -599,11 +768,20 @@ func (rh *RandHound) handleR1(r1 WR1) error {
 		rh.secret[idx] = append(rh.secret[idx], msg.EncShare[g].Target)
 	}
 
-	// Check if there is at least a threshold number of reconstructable secrets
-	// in each group. If yes proceed to the next phase. Note the double-usage
-	// of the threshold which is used to determine if enough valid shares for a
-	// single secret are available and if enough secrets for a given group are
-	// available
+	// Proceed to the next phase if possible
+	_, err = rh.sendI2()
+	return err
+}
+
+// sendI2 checks if there is at least a threshold number of reconstructable
+// secrets in each group. If yes it chooses the secrets that contribute to the
+// collective randomness, sends the I2 messages and returns true. Needs to be
+// called with the mutex held.
+func (rh *RandHound) sendI2() (bool, error) {
+
+	// Note the double-usage of the threshold which is used to determine if
+	// enough valid shares for a single secret are available and if enough
+	// secrets for a given group are available
 	goodSecret := make(map[int][]int)
 	for i, group := range rh.server {
 		var secret []int
@@ -619,91 +797,112 @@ func (rh *RandHound) handleR1(r1 WR1) error {
 	}
 
 	// Proceed, if there are enough good secrets
-	if len(goodSecret) == rh.groups {
+	if len(goodSecret) != len(rh.server) {
+		return false, nil
+	}
 
-		// Reset secret for the next phase (see handleR2)
-		rh.secret = make(map[int][]int)
+	// Reset secret for the next phase (see handleR2)
+	rh.secret = make(map[int][]int)
 
-		// Choose secrets that contribute to collective randomness
-		for i := range rh.server {
+	// Choose secrets that contribute to collective randomness
+	for i := range rh.server {
 
-			// Randomly remove some secrets so that a threshold of secrets remain
-			rand := random.Bytes(rh.Suite().Hash().Size(), random.Stream)
-			prng := rh.Suite().Cipher(rand)
-			secret := goodSecret[i]
-			for j := 0; j < len(secret)-rh.threshold[i]; j++ {
-				k := int(random.Uint32(prng) % uint32(len(secret)))
-				secret = append(secret[:k], secret[k+1:]...)
-			}
-			rh.chosenSecret[i] = secret
+		// Randomly remove some secrets so that a threshold of secrets remain
+		rand := random.Bytes(rh.Suite().Hash().Size(), random.Stream)
+		prng := rh.Suite().Cipher(rand)
+		secret := goodSecret[i]
+		for j := 0; j < len(secret)-rh.threshold[i]; j++ {
+			k := int(random.Uint32(prng) % uint32(len(secret)))
+			secret = append(secret[:k], secret[k+1:]...)
 		}
+		rh.chosenSecret[i] = secret
+	}
 
-		log.Lvlf3("Grouping: %v", rh.group)
-		log.Lvlf3("ChosenSecret: %v", rh.chosenSecret)
+	log.Lvlf3("Grouping: %v", rh.group)
+	log.Lvlf3("ChosenSecret: %v", rh.chosenSecret)
 
-		// Transformation of commitments from map[int][]int to []uint32 to avoid protobuf errors
-		var chosenSecret = make([]uint32, 0)
-		for i := 0; i < len(rh.chosenSecret); i++ {
-			for _, cs := range rh.chosenSecret[i] {
-				chosenSecret = append(chosenSecret, uint32(cs))
-			}
+	// Transformation of commitments from map[int][]int to []uint32 to avoid protobuf errors
+	var chosenSecret = make([]uint32, 0)
+	for i := 0; i < len(rh.chosenSecret); i++ {
+		for _, cs := range rh.chosenSecret[i] {
+			chosenSecret = append(chosenSecret, uint32(cs))
 		}
+	}
 
-		// Prepare a message for each server of a group and send it
-		for i, group := range rh.server {
-			for j, server := range group {
-
-				// Among the good secrets chosen previously collect all valid
-				// shares, proofs, and polynomial commits intended for the
-				// target server
-				var encShare []Share
-				var polyCommit []abstract.Point
-				for _, k := range rh.chosenSecret[i] {
-					r1 := rh.r1s[k]
-					pc := rh.polyCommit[k]
-					encShare = append(encShare, r1.EncShare[j])
-					polyCommit = append(polyCommit, pc[j])
-				}
+	// Prepare a message for each server of a group and send it
+	for i, group := range rh.server {
+		for j, server := range group {
 
-				i2 := &I2{
-					Sig:          crypto.SchnorrSig{},
-					SID:          rh.sid,
-					ChosenSecret: chosenSecret,
-					EncShare:     encShare,
-					PolyCommit:   polyCommit,
-				}
+			// Among the good secrets chosen previously collect all valid
+			// shares, proofs, and polynomial commits intended for the
+			// target server
+			var encShare []Share
+			var polyCommit []abstract.Point
+			for _, k := range rh.chosenSecret[i] {
+				r1 := rh.r1s[k]
+				pc := rh.polyCommit[k]
+				encShare = append(encShare, r1.EncShare[j])
+				polyCommit = append(polyCommit, pc[j])
+			}
 
-				if err := signSchnorr(rh.Suite(), rh.Private(), i2); err != nil {
-					return err
-				}
+			i2 := &I2{
+				Sig:          crypto.SchnorrSig{},
+				SID:          rh.sid,
+				ChosenSecret: chosenSecret,
+				EncShare:     encShare,
+				PolyCommit:   polyCommit,
+			}
 
-				rh.i2s[server.RosterIndex] = i2
+			if err := signSchnorr(rh.Suite(), rh.Private(), i2, rh.version); err != nil {
+				return false, err
+			}
 
-				if err := rh.SendTo(server, i2); err != nil {
-					return err
-				}
+			rh.i2s[server.RosterIndex] = i2
+
+			if err := rh.SendTo(server, i2); err != nil {
+				return false, err
 			}
 		}
 	}
 
-	return nil
+	// Start the deadline for the second phase
+	rh.setTimer(rh.timeoutR2, rh.expireR2)
+
+	return true, nil
 }
 
 func (rh *RandHound) handleI2(i2 WI2) error {
 
 	msg := &i2.I2
 
-	// Compute hash of the client's message
-	msg.Sig = crypto.SchnorrSig{} // XXX: hack
-	i2b, err := network.Marshal(msg)
+	// Determine the encoding version from the session identifier
+	version, err := SessionVersion(rh.Suite(), msg.SID)
 	if err != nil {
 		return err
 	}
 
-	hi2, err := crypto.HashBytes(rh.Suite().Hash(), i2b)
+	// Check that the message belongs to a session answered before, if the
+	// server is configured to do so
+	if state := rh.serverState(); state != nil {
+		if err := state.checkI2(rh.Suite(), msg, version); err != nil {
+			return err
+		}
+	}
+
+	// Injected faults, only with the byzantine build tag
+	byz := faultsFor(rh.ServerIdentity())
+	if byz.silent() {
+		return nil
+	}
+	msg = byz.i2(msg)
+	sid := msg.SID
+
+	// Compute hash of the client's message
+	hi2, err := hashMessage(rh.Suite(), msg, version)
 	if err != nil {
 		return err
 	}
+	hi2 = byz.hash(hi2)
 
 	// Prepare data
 	n := len(msg.EncShare)
@@ -717,7 +916,7 @@ func (rh *RandHound) handleI2(i2 WI2) error {
 	}
 
 	// Init PVSS and verify encryption consistency proof
-	H, _ := rh.Suite().Point().Pick(nil, rh.Suite().Cipher(msg.SID))
+	H, _ := rh.Suite().Point().Pick(nil, rh.Suite().Cipher(sid))
 	pvss := NewPVSS(rh.Suite(), H, 0)
 
 	good, bad, err := pvss.Verify(H, X, msg.PolyCommit, encShare, encProof)
@@ -749,6 +948,7 @@ func (rh *RandHound) handleI2(i2 WI2) error {
 			Proof:  decProof[i],
 		}
 	}
+	byz.shares(rh.Suite(), share)
 
 	r2 := &R2{
 		HI2:      hi2,
@@ -756,7 +956,7 @@ func (rh *RandHound) handleI2(i2 WI2) error {
 	}
 
 	// Sign R2 and store signature in R2.Sig
-	if err := signSchnorr(rh.Suite(), rh.Private(), r2); err != nil {
+	if err := signSchnorr(rh.Suite(), rh.Private(), r2, version); err != nil {
 		return err
 	}
 
@@ -774,23 +974,29 @@ func (rh *RandHound) handleR2(r2 WR2) error {
 	rh.mutex.Lock()
 	defer rh.mutex.Unlock()
 
-	// If the collective secret is already available, ignore all further incoming messages
-	if rh.SecretReady {
+	// If the collective secret is already available or the protocol run has
+	// failed, ignore all further incoming messages
+	if rh.SecretReady || rh.finished {
 		return nil
 	}
 
 	// Verify R2 message signature
-	if err := verifySchnorr(rh.Suite(), rh.key[grp][pos], msg); err != nil {
+	if err := verifySchnorr(rh.Suite(), rh.key[grp][pos], msg, rh.version); err != nil {
+		rh.blame(idx, 2, BadSignature)
 		return err
 	}
 
 	// Verify that server replied to the correct I2 message
-	if err := verifyMessage(rh.Suite(), rh.i2s[idx], msg.HI2); err != nil {
+	if err := verifyMessage(rh.Suite(), rh.i2s[idx], msg.HI2, rh.version); err != nil {
+		rh.blame(idx, 2, BadReplyHash)
 		return err
 	}
 
 	// Record R2 message
 	rh.r2s[idx] = msg
+	if rh.progress != nil {
+		rh.progress(2, len(rh.r2s), len(rh.i2s))
+	}
 
 	// Get all valid encrypted shares corresponding to the received decrypted
 	// shares and intended for the target server (=idx)
@@ -816,8 +1022,9 @@ func (rh *RandHound) handleR2(r2 WR2) error {
 	if err != nil {
 		return err
 	}
-	_ = bad
-	_ = good
+	if len(bad) > 0 {
+		rh.blame(idx, 2, BadDecryptionProof)
+	}
 
 	// Record valid decrypted shares per secret/server
 	for i := 0; i < len(good); i++ {
@@ -829,28 +1036,145 @@ func (rh *RandHound) handleR2(r2 WR2) error {
 		rh.secret[src] = append(rh.secret[src], msg.DecShare[j].Target)
 	}
 
-	proceed := true
+	proceed := rh.recoverable()
+
+	if len(rh.r2s) == rh.nodes-1 && !proceed {
+		rh.finish(ErrNotRecoverable)
+		return ErrNotRecoverable
+	}
+
+	if proceed {
+		rh.SecretReady = true
+		rh.finish(nil)
+	}
+	return nil
+}
+
+// recoverable returns true if enough valid decrypted shares are available to
+// recover all chosen secrets. Needs to be called with the mutex held.
+func (rh *RandHound) recoverable() bool {
 	for i, group := range rh.chosenSecret {
 		for _, server := range group {
 			if len(rh.secret[server]) < rh.threshold[i] {
-				proceed = false
+				return false
 			}
 		}
 	}
+	return true
+}
 
-	if len(rh.r2s) == rh.nodes-1 && !proceed {
-		rh.Done <- true
-		return errors.New("Some chosen secrets are not reconstructable")
+// setTimer (re)starts the phase deadline which calls f once d has passed. A
+// zero duration disables the deadline. Needs to be called with the mutex held.
+func (rh *RandHound) setTimer(d time.Duration, f func()) {
+	if rh.timer != nil {
+		rh.timer.Stop()
+		rh.timer = nil
+	}
+	if d > 0 {
+		rh.timer = time.AfterFunc(d, f)
+	}
+}
+
+// finish ends the protocol run and signals the result on the Done channel.
+// Only the first call has an effect. Needs to be called with the mutex held.
+func (rh *RandHound) finish(err error) {
+	if rh.finished {
+		return
 	}
+	rh.finished = true
+	rh.setTimer(0, nil)
+	rh.Done <- err
+}
+
+// expireR1 is called when the deadline of the first phase has passed. The
+// client proceeds with the R1 messages received so far if they are enough to
+// satisfy the group thresholds and fails otherwise.
+func (rh *RandHound) expireR1() {
 
-	if proceed && !rh.SecretReady {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	if rh.finished || len(rh.chosenSecret) > 0 {
+		return
+	}
+
+	ok, err := rh.sendI2()
+	if err != nil {
+		rh.finish(err)
+		return
+	}
+	if !ok {
+		var missing []int
+		for _, group := range rh.group {
+			for _, server := range group {
+				if _, ok := rh.r1s[server]; !ok {
+					missing = append(missing, server)
+					rh.blame(server, 1, MissingReply)
+				}
+			}
+		}
+		rh.finish(&TimeoutError{Phase: 1, Missing: missing})
+	}
+}
+
+// expireR2 is called when the deadline of the second phase has passed. The
+// client finishes if the R2 messages received so far allow to recover all
+// chosen secrets and fails otherwise.
+func (rh *RandHound) expireR2() {
+
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+
+	if rh.finished {
+		return
+	}
+
+	var missing []int
+	for server := range rh.i2s {
+		if _, ok := rh.r2s[server]; !ok {
+			missing = append(missing, server)
+			rh.blame(server, 2, MissingReply)
+		}
+	}
+	sort.Ints(missing)
+
+	if rh.recoverable() {
 		rh.SecretReady = true
-		rh.Done <- true
+		rh.finish(nil)
+		return
 	}
-	return nil
+	rh.finish(&TimeoutError{Phase: 2, Missing: missing})
 }
 
-func (rh *RandHound) sessionID(nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point) ([]byte, error) {
+// serverState returns the state of the server running this instance, or nil
+// if the server has not been configured, see Service.SetServerConfig.
+func (rh *RandHound) serverState() *serverState {
+	if rh.Host() == nil {
+		return nil
+	}
+	s, ok := rh.Host().Service(ServiceName).(*Service)
+	if !ok {
+		return nil
+	}
+	return s.serverState()
+}
+
+// sessionID computes the session identifier for the given encoding version.
+// Only version 2 session identifiers cover the number of groups and the
+// sharding configuration.
+func sessionID(suite abstract.Suite, version int, nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point, groups int, sharding ShardConfig) ([]byte, error) {
+	switch version {
+	case VersionLegacy:
+		return sessionIDLegacy(suite, nodes, faulty, purpose, time, rand, threshold, clientKey, serverKey)
+	case Version1:
+		return sessionIDv1(suite, nodes, faulty, purpose, time, rand, threshold, clientKey, serverKey)
+	case Version2:
+		return sessionIDv2(suite, nodes, faulty, purpose, time, rand, threshold, clientKey, serverKey, groups, sharding)
+	}
+	return nil, fmt.Errorf("Unknown encoding version %v", version)
+}
+
+func sessionIDLegacy(suite abstract.Suite, nodes int, faulty int, purpose string, time time.Time, rand []byte, threshold []int, clientKey abstract.Point, serverKey [][]abstract.Point) ([]byte, error) {
 
 	buf := new(bytes.Buffer)
 
@@ -909,16 +1233,16 @@ func (rh *RandHound) sessionID(nodes int, faulty int, purpose string, time time.
 		}
 	}
 
-	return crypto.HashBytes(rh.Suite().Hash(), buf.Bytes())
+	return crypto.HashBytes(suite.Hash(), buf.Bytes())
 }
 
-func signSchnorr(suite abstract.Suite, key abstract.Scalar, m interface{}) error {
+func signSchnorr(suite abstract.Suite, key abstract.Scalar, m interface{}, version int) error {
 
 	// Reset signature field
 	reflect.ValueOf(m).Elem().FieldByName("Sig").Set(reflect.ValueOf(crypto.SchnorrSig{})) // XXX: hack
 
-	// Marshal message
-	mb, err := network.Marshal(m) // TODO: change m to interface with hash to make it compatible to other languages (network.Marshal() adds struct-identifiers)
+	// Encode message
+	mb, err := encodeMessage(m, version)
 	if err != nil {
 		return err
 	}
@@ -935,53 +1259,28 @@ func signSchnorr(suite abstract.Suite, key abstract.Scalar, m interface{}) error
 	return nil
 }
 
-func verifySchnorr(suite abstract.Suite, key abstract.Point, m interface{}) error {
-
-	// Make a copy of the signature
-	x := reflect.ValueOf(m).Elem().FieldByName("Sig")
-	sig := reflect.New(x.Type()).Elem()
-	sig.Set(x)
+func verifySchnorr(suite abstract.Suite, key abstract.Point, m interface{}, version int) error {
 
-	// Reset signature field
-	reflect.ValueOf(m).Elem().FieldByName("Sig").Set(reflect.ValueOf(crypto.SchnorrSig{})) // XXX: hack
+	// Get the signature
+	sig := reflect.ValueOf(m).Elem().FieldByName("Sig").Interface().(crypto.SchnorrSig)
 
-	// Marshal message
-	mb, err := network.Marshal(m) // TODO: change m to interface with hash to make it compatible to other languages (network.Marshal() adds struct-identifiers)
+	// Encode message
+	mb, err := encodeMessage(m, version)
 	if err != nil {
 		return err
 	}
 
-	// Copy back original signature
-	reflect.ValueOf(m).Elem().FieldByName("Sig").Set(sig) // XXX: hack
-
-	return crypto.VerifySchnorr(suite, key, mb, sig.Interface().(crypto.SchnorrSig))
+	return crypto.VerifySchnorr(suite, key, mb, sig)
 }
 
-func verifyMessage(suite abstract.Suite, m interface{}, hash1 []byte) error {
-
-	// Make a copy of the signature
-	x := reflect.ValueOf(m).Elem().FieldByName("Sig")
-	sig := reflect.New(x.Type()).Elem()
-	sig.Set(x)
-
-	// Reset signature field
-	reflect.ValueOf(m).Elem().FieldByName("Sig").Set(reflect.ValueOf(crypto.SchnorrSig{})) // XXX: hack
-
-	// Marshal ...
-	mb, err := network.Marshal(m) // TODO: change m to interface with hash to make it compatible to other languages (network.Marshal() adds struct-identifiers)
-	if err != nil {
-		return err
-	}
+func verifyMessage(suite abstract.Suite, m interface{}, hash1 []byte, version int) error {
 
-	// ... and hash message
-	hash2, err := crypto.HashBytes(suite.Hash(), mb)
+	// Encode and hash message
+	hash2, err := hashMessage(suite, m, version)
 	if err != nil {
 		return err
 	}
 
-	// Copy back original signature
-	reflect.ValueOf(m).Elem().FieldByName("Sig").Set(sig) // XXX: hack
-
 	// Compare hashes
 	if !bytes.Equal(hash1, hash2) {
 		return errors.New("Message has a different hash than the given one")
@@ -1,44 +1,72 @@
 package randhound_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 
-	"github.com/dedis/cothority/randhound"
-	"mobilehound"
 	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/randhound"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-config"
 )
 
+// newLocal starts nodes servers for a test and returns them together with
+// their roster and a tree rooted at the first one, which acts as the client.
+// With tcp the servers talk over TCP instead of local channels and answer
+// service requests. The caller has to close the LocalTest.
+func newLocal(nodes int, tcp bool) (*onet.LocalTest, []*onet.Server, *onet.Roster, *onet.Tree) {
+	var local *onet.LocalTest
+	if tcp {
+		local = onet.NewTCPTest()
+	} else {
+		local = onet.NewLocalTest()
+	}
+	servers, roster, tree := local.GenTree(nodes, true)
+	return local, servers, roster, tree
+}
+
+// newRandHound creates a RandHound instance on tree with the given
+// parameters, without starting it.
+func newRandHound(t *testing.T, local *onet.LocalTest, tree *onet.Tree, faulty, groups int, purpose string) *randhound.RandHound {
+	protocol, err := local.CreateProtocol("RandHound", tree)
+	if err != nil {
+		t.Fatal("Couldn't initialise RandHound protocol:", err)
+	}
+	rh := protocol.(*randhound.RandHound)
+	if err := rh.Setup(len(tree.Roster.List), faulty, groups, purpose); err != nil {
+		t.Fatal("Couldn't initialise RandHound protocol:", err)
+	}
+	return rh
+}
+
 func TestRandHound(t *testing.T) {
 
-	var name = "RandHound"
 	var nodes int = 28
 	var faulty int = 2
 	var groups int = 4
 	var purpose string = "RandHound test run"
 
-	local := onet.NewLocalTest()
-	_, _, tree := local.GenTree(int(nodes), true)
+	local, _, _, tree := newLocal(nodes, false)
 	defer local.CloseAll()
 
 	// Setup and start RandHound
 
 	log.Lvlf1("RandHound - starting")
-	protocol, err := local.CreateProtocol(name, tree)
-	if err != nil {
-		t.Fatal("Couldn't initialise RandHound protocol:", err)
-	}
-	rh := protocol.(*randhound.RandHound)
-	err = rh.Setup(nodes, faulty, groups, purpose)
-	if err != nil {
-		t.Fatal("Couldn't initialise RandHound protocol:", err)
-	}
-	if err := protocol.Start(); err != nil {
+	rh := newRandHound(t, local, tree, faulty, groups, purpose)
+	if err := rh.Start(); err != nil {
 		t.Fatal(err)
 	}
 
 	select {
-	case <-rh.Done:
+	case err := <-rh.Done:
+		if err != nil {
+			t.Fatal(err)
+		}
 		log.Lvlf1("RandHound - done")
 
 		random, transcript, err := rh.Random()
@@ -60,3 +88,326 @@ func TestRandHound(t *testing.T) {
 	}
 
 }
+
+func TestTranscriptEncoding(t *testing.T) {
+
+	var nodes int = 10
+	var faulty int = 1
+	var groups int = 2
+	var purpose string = "RandHound transcript test"
+
+	local, _, _, tree := newLocal(nodes, false)
+	defer local.CloseAll()
+
+	rh := newRandHound(t, local, tree, faulty, groups, purpose)
+	if err := rh.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-rh.Done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * time.Duration(nodes) * 2):
+		t.Fatal("RandHound – time out")
+	}
+
+	random, transcript, err := rh.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JSON export must be canonical and verifiable after import
+	js, err := json.Marshal(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js2, err := json.Marshal(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(js, js2) {
+		t.Fatal("JSON encoding of transcript is not deterministic")
+	}
+	tj, err := randhound.TranscriptFromJSON(rh.Suite(), js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := randhound.VerifyTranscript(rh.Suite(), random, tj); err != nil {
+		t.Fatal("Imported JSON transcript does not verify:", err)
+	}
+
+	// Same for the binary encoding
+	bin, err := transcript.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := randhound.TranscriptFromBinary(rh.Suite(), bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := randhound.VerifyTranscript(rh.Suite(), random, tb); err != nil {
+		t.Fatal("Imported binary transcript does not verify:", err)
+	}
+	bin2, err := tb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bin, bin2) {
+		t.Fatal("Binary transcript changed after round-trip")
+	}
+
+	// Truncated input must be rejected
+	if _, err := randhound.TranscriptFromBinary(rh.Suite(), bin[:len(bin)/2]); err == nil {
+		t.Fatal("Truncated transcript should not decode")
+	}
+
+	// Malformed transcripts must be rejected without panicking
+	malformed := map[string]func(*randhound.Transcript){
+		"share position": func(tr *randhound.Transcript) {
+			for _, r1 := range tr.R1s {
+				r1.EncShare[0].Pos = 1000
+			}
+		},
+		"missing key": func(tr *randhound.Transcript) {
+			tr.Key[0] = tr.Key[0][:1]
+		},
+		"unknown server": func(tr *randhound.Transcript) {
+			for _, r1 := range tr.R1s {
+				tr.R1s[1000] = r1
+				break
+			}
+		},
+		"nil message": func(tr *randhound.Transcript) {
+			tr.I2s[1000] = nil
+		},
+		"chosen secrets": func(tr *randhound.Transcript) {
+			for _, i2 := range tr.I2s {
+				i2.ChosenSecret = nil
+			}
+		},
+		"chosen group": func(tr *randhound.Transcript) {
+			tr.ChosenSecret[1000] = []int{1}
+		},
+	}
+	for name, modify := range malformed {
+		tm, err := randhound.TranscriptFromBinary(rh.Suite(), bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modify(tm)
+		if err := randhound.VerifyTranscript(rh.Suite(), random, tm); err == nil {
+			t.Fatal("Malformed transcript should not verify:", name)
+		}
+	}
+	var jm map[string]interface{}
+	if err := json.Unmarshal(js, &jm); err != nil {
+		t.Fatal(err)
+	}
+	jm["R1s"].(map[string]interface{})["1"] = nil
+	jn, err := json.Marshal(jm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := randhound.TranscriptFromJSON(rh.Suite(), jn); err == nil {
+		t.Fatal("Transcript with a null message should not decode")
+	}
+
+	// Tampering with the output must be detected
+	random[0] ^= 0xff
+	if err := randhound.VerifyTranscript(rh.Suite(), random, tj); err == nil {
+		t.Fatal("Modified randomness should not verify")
+	}
+}
+
+func TestRandHoundTimeout(t *testing.T) {
+
+	var nodes int = 10
+	var faulty int = 1
+	var groups int = 2
+	var purpose string = "RandHound timeout test"
+
+	local, _, _, tree := newLocal(nodes, false)
+	defer local.CloseAll()
+
+	rh := newRandHound(t, local, tree, faulty, groups, purpose)
+
+	// No server can reply before such a short deadline
+	rh.SetTimeout(time.Nanosecond, 0)
+	if err := rh.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-rh.Done:
+		terr, ok := err.(*randhound.TimeoutError)
+		if !ok {
+			t.Fatal("Expected a timeout error, got", err)
+		}
+		if terr.Phase != 1 {
+			t.Fatal("Expected a timeout in phase 1, got", terr.Phase)
+		}
+	case <-time.After(time.Second * time.Duration(nodes) * 2):
+		t.Fatal("RandHound – hangs despite deadline")
+	}
+
+	if _, _, err := rh.Random(); err == nil {
+		t.Fatal("Random should fail after a timeout")
+	}
+}
+
+func TestRandHoundServerConfig(t *testing.T) {
+
+	var nodes int = 10
+	var faulty int = 1
+	var groups int = 2
+	var purpose string = "RandHound server config test"
+
+	local, servers, _, tree := newLocal(nodes, false)
+	defer local.CloseAll()
+
+	client := tree.Root.ServerIdentity.Public
+	stranger := config.NewKeyPair(network.Suite).Public
+
+	for _, authorized := range []bool{true, false} {
+
+		key := client
+		if !authorized {
+			key = stranger
+		}
+		for _, s := range servers {
+			service := s.Service(randhound.ServiceName).(*randhound.Service)
+			service.SetServerConfig(&randhound.ServerConfig{
+				ClientKeys: []abstract.Point{key},
+			})
+		}
+
+		rh := newRandHound(t, local, tree, faulty, groups, purpose)
+		rh.SetTimeout(2*time.Second, 2*time.Second)
+		if err := rh.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		err := <-rh.Done
+		if authorized && err != nil {
+			t.Fatal("Authorized client failed:", err)
+		}
+		if !authorized {
+			if _, ok := err.(*randhound.TimeoutError); !ok {
+				t.Fatal("Servers should not answer an unauthorized client, got", err)
+			}
+		}
+	}
+}
+
+func TestShard(t *testing.T) {
+	suite := network.Suite
+	seed := []byte("RandHound sharding test")
+
+	a, err := randhound.ShardIndices(suite, seed, 11, 3, randhound.ShardConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := randhound.ShardIndices(suite, seed, 11, 3, randhound.ShardConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("Sharding is not reproducible")
+	}
+
+	seen := make(map[int]bool)
+	for _, g := range a {
+		for _, idx := range g {
+			if idx == 0 || seen[idx] {
+				t.Fatal("Invalid grouping:", a)
+			}
+			seen[idx] = true
+		}
+	}
+	if len(seen) != 10 {
+		t.Fatal("Not all servers are grouped:", a)
+	}
+
+	balanced, err := randhound.ShardIndices(suite, seed, 11, 3, randhound.ShardConfig{Strategy: randhound.ShardBalanced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balanced[0]) != 4 || len(balanced[1]) != 3 || len(balanced[2]) != 3 {
+		t.Fatal("Wrong balanced group sizes:", balanced)
+	}
+
+	min, err := randhound.ShardIndices(suite, seed, 11, 4, randhound.ShardConfig{MinGroupSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(min) != 2 {
+		t.Fatal("Minimum group size not respected:", min)
+	}
+
+	if _, err := randhound.ShardIndices(suite, seed, 11, 11, randhound.ShardConfig{}); err == nil {
+		t.Fatal("Too many shards should be rejected")
+	}
+	if _, err := randhound.ShardIndices(suite, seed, 3, 1, randhound.ShardConfig{MinGroupSize: 3}); err == nil {
+		t.Fatal("Unreachable minimum group size should be rejected")
+	}
+}
+
+func TestRandHoundSharding(t *testing.T) {
+
+	var nodes int = 10
+	var faulty int = 1
+	var groups int = 3
+	var purpose string = "RandHound sharding test"
+
+	local, _, _, tree := newLocal(nodes, false)
+	defer local.CloseAll()
+
+	rh := newRandHound(t, local, tree, faulty, groups, purpose)
+	rh.SetSharding(randhound.ShardConfig{Strategy: randhound.ShardBalanced, MinGroupSize: 4})
+	if err := rh.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-rh.Done; err != nil {
+		t.Fatal(err)
+	}
+
+	random, transcript, err := rh.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transcript.Group) != 2 {
+		t.Fatal("Wrong number of groups:", transcript.Group)
+	}
+	data, err := json.Marshal(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := randhound.TranscriptFromJSON(rh.Suite(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := randhound.VerifyTranscript(rh.Suite(), random, decoded); err != nil {
+		t.Fatal("Verification failed:", err)
+	}
+
+	// The sharding parameters are bound to the session identifier, even if
+	// other ones lead to the same grouping
+	decoded.Sharding.MinGroupSize = 3
+	if err := randhound.VerifyTranscript(rh.Suite(), random, decoded); err == nil {
+		t.Fatal("Modified sharding configuration should not verify")
+	}
+	decoded.Sharding.MinGroupSize = 4
+	decoded.Groups++
+	if err := randhound.VerifyTranscript(rh.Suite(), random, decoded); err == nil {
+		t.Fatal("Modified number of groups should not verify")
+	}
+	decoded.Groups--
+
+	// A hand-picked grouping with the same keys must be rejected
+	decoded.Group[0][0], decoded.Group[1][0] = decoded.Group[1][0], decoded.Group[0][0]
+	if err := randhound.VerifyTranscript(rh.Suite(), random, decoded); err == nil {
+		t.Fatal("Modified grouping should not verify")
+	}
+}
@@ -0,0 +1,216 @@
+// Rhclient asks a running roster for collective randomness with RandHound
+// and verifies the transcripts of earlier runs.
+//
+// It has two subcommands:
+//
+//	rhclient generate -group group.toml [-groups 2] [-faulty 1] [-purpose text] [-format hex|base64] [-transcript transcript.json]
+//	rhclient verify -group group.toml -random <random> -transcript transcript.json [-format hex|base64]
+//
+// generate contacts the first server of the group file, which runs RandHound
+// on the whole roster as the client. It prints the random output and writes
+// the transcript of the run to disk, as JSON if the file ends in .json and in
+// the binary encoding otherwise.
+// verify checks offline that a transcript of a run on the roster of the group
+// file proves the random output, and lists the servers the client of the run
+// blamed.
+// The transcript has to use the public keys of the roster, and its client has
+// to be the first server of the group file, as for generate.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/onet/app"
+	"mobilehound/randhound"
+)
+
+// out receives the random output.
+var out io.Writer = os.Stdout
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+	var err error
+	switch os.Args[1] {
+	case "generate":
+		err = generate(args)
+	case "verify":
+		err = verify(args)
+	default:
+		usage()
+	}
+	log.ErrFatal(err)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: rhclient generate|verify [options]")
+	fmt.Fprintln(os.Stderr, "Use 'rhclient <command> -h' for the options of a command.")
+	os.Exit(1)
+}
+
+// newFlagSet returns the flags of a subcommand, with the debug-level and the
+// format of the random output.
+func newFlagSet(name string) (*flag.FlagSet, *int, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	debug := fs.Int("debug", 0, "debug-level")
+	format := fs.String("format", "hex", "encoding of the random output: hex or base64")
+	return fs, debug, format
+}
+
+func generate(args []string) error {
+	fs, debug, format := newFlagSet("generate")
+	group := fs.String("group", "", "group file of the roster")
+	groups := fs.Int("groups", 1, "number of groups the servers are split into")
+	faulty := fs.Int("faulty", 0, "number of faulty servers to tolerate")
+	purpose := fs.String("purpose", "", "purpose of the randomness")
+	transcript := fs.String("transcript", "transcript.json", "file for the transcript")
+	fs.Parse(args)
+	log.SetDebugVisible(*debug)
+	if *group == "" {
+		return errors.New("Please give a group file with -group")
+	}
+
+	roster, err := app.ReadGroupToml(*group)
+	if err != nil {
+		return err
+	}
+	reply, cerr := randhound.NewClient().GenerateRandom(roster, *groups, *faulty, *purpose)
+	if cerr != nil {
+		return cerr
+	}
+	random, err := encodeRandom(reply.Random, *format)
+	if err != nil {
+		return err
+	}
+
+	data := reply.Transcript
+	if strings.HasSuffix(*transcript, ".json") {
+		t, err := randhound.TranscriptFromBinary(network.Suite, reply.Transcript)
+		if err != nil {
+			return err
+		}
+		if data, err = t.MarshalJSON(); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(*transcript, data, 0644); err != nil {
+		return err
+	}
+	log.Info("Wrote transcript to", *transcript)
+	fmt.Fprintln(out, random)
+	return nil
+}
+
+func verify(args []string) error {
+	fs, debug, format := newFlagSet("verify")
+	group := fs.String("group", "", "group file of the roster")
+	random := fs.String("random", "", "random output to verify")
+	transcript := fs.String("transcript", "transcript.json", "file of the transcript")
+	fs.Parse(args)
+	log.SetDebugVisible(*debug)
+	if *group == "" {
+		return errors.New("Please give a group file with -group")
+	}
+
+	roster, err := app.ReadGroupToml(*group)
+	if err != nil {
+		return err
+	}
+	r, err := decodeRandom(*random, *format)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(*transcript)
+	if err != nil {
+		return err
+	}
+	var t *randhound.Transcript
+	if len(data) > 0 && data[0] == '{' {
+		t, err = randhound.TranscriptFromJSON(network.Suite, data)
+	} else {
+		t, err = randhound.TranscriptFromBinary(network.Suite, data)
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkKeys(roster, t); err != nil {
+		return err
+	}
+
+	if err := randhound.VerifyTranscript(network.Suite, r, t); err != nil {
+		return err
+	}
+	if t.Blame != nil {
+		for _, b := range t.Blame.Blames {
+			log.Info(fmt.Sprintf("Server %d (%s) blamed by the client in phase %d: %s",
+				b.Server, b.Address, b.Phase, b.Kind))
+		}
+	}
+	log.Info("Transcript verifies the random output")
+	return nil
+}
+
+// checkKeys makes sure that the transcript t has been created by the first
+// server of roster as the client and only uses the public keys of roster, so
+// that a transcript signed with keys of its own choosing is rejected.
+func checkKeys(roster *onet.Roster, t *randhound.Transcript) error {
+	if len(roster.List) == 0 {
+		return errors.New("Empty roster")
+	}
+	if t.CliKey == nil || !t.CliKey.Equal(roster.List[0].Public) {
+		return errors.New("Transcript has not been created by the first server of the group")
+	}
+	if t.Nodes != len(roster.List) {
+		return fmt.Errorf("Transcript has %d servers instead of %d", t.Nodes, len(roster.List))
+	}
+	for i := range t.Group {
+		if i >= len(t.Key) || len(t.Group[i]) != len(t.Key[i]) {
+			return errors.New("Transcript has malformed groups")
+		}
+		for j, idx := range t.Group[i] {
+			if idx < 0 || idx >= len(roster.List) || t.Key[i][j] == nil ||
+				!t.Key[i][j].Equal(roster.List[idx].Public) {
+				return fmt.Errorf("Key of server %d is not the one of the group file", idx)
+			}
+		}
+	}
+	return nil
+}
+
+// encodeRandom returns random in the given format.
+func encodeRandom(random []byte, format string) (string, error) {
+	switch format {
+	case "hex":
+		return hex.EncodeToString(random), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(random), nil
+	}
+	return "", errors.New("Unknown format " + format)
+}
+
+// decodeRandom is the inverse of encodeRandom.
+func decodeRandom(random string, format string) ([]byte, error) {
+	if random == "" {
+		return nil, errors.New("Please give the random output with -random")
+	}
+	switch format {
+	case "hex":
+		return hex.DecodeString(random)
+	case "base64":
+		return base64.StdEncoding.DecodeString(random)
+	}
+	return nil, errors.New("Unknown format " + format)
+}
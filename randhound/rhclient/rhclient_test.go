@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+	"mobilehound/network"
+	"mobilehound/onet"
+)
+
+func TestGenerateVerify(t *testing.T) {
+	local := onet.NewTCPTest()
+	_, roster, _ := local.GenTree(8, true)
+	defer local.CloseAll()
+
+	dir, err := ioutil.TempDir("", "rhclient")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	group := filepath.Join(dir, "group.toml")
+	f, err := os.Create(group)
+	require.Nil(t, err)
+	require.Nil(t, toml.NewEncoder(f).Encode(roster.Toml(network.Suite)))
+	require.Nil(t, f.Close())
+
+	for _, format := range []string{"hex", "base64"} {
+		for _, file := range []string{"transcript.json", "transcript.bin"} {
+			transcript := filepath.Join(dir, file)
+			var buf bytes.Buffer
+			out = &buf
+			require.Nil(t, generate([]string{"-group", group, "-groups", "2", "-faulty", "1",
+				"-purpose", "rhclient test", "-format", format, "-transcript", transcript}))
+			random := strings.TrimSpace(buf.String())
+
+			require.Nil(t, verify([]string{"-group", group, "-random", random, "-format", format,
+				"-transcript", transcript}))
+			require.NotNil(t, verify([]string{"-random", random, "-format", format,
+				"-transcript", transcript}))
+			r, err := decodeRandom(random, format)
+			require.Nil(t, err)
+			r[0] ^= 0xff
+			bad, err := encodeRandom(r, format)
+			require.Nil(t, err)
+			require.NotNil(t, verify([]string{"-group", group, "-random", bad, "-format", format,
+				"-transcript", transcript}))
+		}
+	}
+
+	// A transcript of another roster doesn't verify against the group file
+	_, other, _ := local.GenTree(8, true)
+	otherGroup := filepath.Join(dir, "other.toml")
+	f, err = os.Create(otherGroup)
+	require.Nil(t, err)
+	require.Nil(t, toml.NewEncoder(f).Encode(other.Toml(network.Suite)))
+	require.Nil(t, f.Close())
+	transcript := filepath.Join(dir, "other.json")
+	var buf bytes.Buffer
+	out = &buf
+	require.Nil(t, generate([]string{"-group", otherGroup, "-groups", "2", "-faulty", "1",
+		"-transcript", transcript}))
+	random := strings.TrimSpace(buf.String())
+	require.Nil(t, verify([]string{"-group", otherGroup, "-random", random, "-transcript", transcript}))
+	require.NotNil(t, verify([]string{"-group", group, "-random", random, "-transcript", transcript}))
+}
+
+func TestEncodeRandom(t *testing.T) {
+	_, err := encodeRandom([]byte{1}, "binary")
+	require.NotNil(t, err)
+	_, err = decodeRandom("", "hex")
+	require.NotNil(t, err)
+	_, err = decodeRandom("zz", "hex")
+	require.NotNil(t, err)
+}
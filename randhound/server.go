@@ -0,0 +1,186 @@
+package randhound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+)
+
+// DefaultCacheSize is the number of sessions a server remembers if no cache
+// size is given in its ServerConfig.
+const DefaultCacheSize = 128
+
+// ServerConfig holds the checks a RandHound server performs on the messages
+// of a client. Without a ServerConfig a server answers every I1 and I2 message
+// it receives.
+type ServerConfig struct {
+	ClientKeys []abstract.Point // Public keys of authorized clients
+	CacheSize  int              // Maximum number of remembered sessions
+}
+
+// serverState is the per-server state that outlives a single protocol run. It
+// is kept by the RandHound service of the server.
+type serverState struct {
+	sync.Mutex
+	config   ServerConfig
+	sessions map[string]abstract.Point // Client key per answered SID
+	order    []string                  // SIDs in insertion order for eviction
+}
+
+// SetServerConfig configures the server of the service to only answer
+// authorized clients. The server checks the I1 and I2 signatures against the
+// configured client keys, recomputes the session identifier of an I1 message
+// and rejects I2 messages whose session it has not answered before. Passing a
+// nil config removes all checks.
+func (s *Service) SetServerConfig(config *ServerConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if config == nil {
+		s.state = nil
+		return
+	}
+	state := &serverState{
+		config:   *config,
+		sessions: make(map[string]abstract.Point),
+	}
+	if state.config.CacheSize <= 0 {
+		state.config.CacheSize = DefaultCacheSize
+	}
+	s.state = state
+}
+
+// serverState returns the state of the server or nil if the server has not
+// been configured.
+func (s *Service) serverState() *serverState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// checkI1 verifies that an I1 message has been signed by an authorized client
+// and that its session identifier matches the session parameters. On success
+// the session is remembered for the later I2 message.
+func (s *serverState) checkI1(suite abstract.Suite, roster *onet.Roster, msg *I1, version int) error {
+
+	// Legacy messages don't carry enough information to recompute the SID
+	if version == VersionLegacy {
+		return errors.New("Legacy sessions are not accepted")
+	}
+
+	// Find the client who signed the message
+	var client abstract.Point
+	for _, key := range s.config.ClientKeys {
+		if verifySchnorr(suite, key, msg, version) == nil {
+			client = key
+			break
+		}
+	}
+	if client == nil {
+		return errors.New("I1 message not signed by an authorized client")
+	}
+
+	// Recompute the session identifier
+	sid, err := sessionIDFromI1(suite, roster, client, msg, version)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sid, msg.SID) {
+		return errors.New("Wrong session identifier")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	id := string(msg.SID)
+	if _, ok := s.sessions[id]; !ok {
+		s.order = append(s.order, id)
+		for len(s.order) > s.config.CacheSize {
+			delete(s.sessions, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+	s.sessions[id] = client
+	return nil
+}
+
+// checkI2 verifies that an I2 message belongs to a session the server answered
+// and has been signed by the same client.
+func (s *serverState) checkI2(suite abstract.Suite, msg *I2, version int) error {
+	s.Lock()
+	client, ok := s.sessions[string(msg.SID)]
+	s.Unlock()
+	if !ok {
+		return errors.New("I2 message of an unknown session")
+	}
+	return verifySchnorr(suite, client, msg, version)
+}
+
+// sessionIDFromI1 recomputes the session identifier of the given version from
+// the session parameters of an I1 message, taking the server keys from the
+// roster. It also checks that the group of the message is one of the groups of
+// the session.
+func sessionIDFromI1(suite abstract.Suite, roster *onet.Roster, client abstract.Point, msg *I1, version int) ([]byte, error) {
+
+	if len(msg.Thresholds) != len(msg.GroupSize) {
+		return nil, errors.New("Non-matching number of group thresholds and sizes")
+	}
+
+	threshold := make([]int, len(msg.Thresholds))
+	key := make([][]abstract.Point, len(msg.GroupSize))
+	member := false
+	k := 0
+	for i, size := range msg.GroupSize {
+		if k+int(size) > len(msg.AllGroup) {
+			return nil, errors.New("Group sizes exceed group indices")
+		}
+		group := msg.AllGroup[k : k+int(size)]
+		k += int(size)
+
+		threshold[i] = int(msg.Thresholds[i])
+		key[i] = make([]abstract.Point, len(group))
+		for j, idx := range group {
+			if int(idx) >= len(roster.List) {
+				return nil, fmt.Errorf("Server index %v not in roster", idx)
+			}
+			key[i][j] = roster.List[idx].Public
+		}
+
+		if threshold[i] == msg.Threshold && sameGroup(group, msg.Group) {
+			member = true
+		}
+	}
+	if k != len(msg.AllGroup) {
+		return nil, errors.New("Group sizes do not match group indices")
+	}
+	if !member {
+		return nil, errors.New("I1 group is not part of the session")
+	}
+
+	// The keys of the message have to belong to the indexed servers
+	if len(msg.Key) != len(msg.Group) {
+		return nil, errors.New("Non-matching number of group indices and keys")
+	}
+	for i, idx := range msg.Group {
+		if !msg.Key[i].Equal(roster.List[idx].Public) {
+			return nil, fmt.Errorf("Wrong key for server %v", idx)
+		}
+	}
+
+	return sessionID(suite, version, msg.Nodes, msg.Faulty, msg.Purpose, time.Unix(0, msg.Time), msg.CliRand, threshold, client, key, msg.Groups, msg.Sharding)
+}
+
+func sameGroup(a []uint32, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,531 @@
+package randhound
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"mobilehound/crypto"
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+)
+
+// ServiceName is the name under which the RandHound service is registered.
+const ServiceName = "RandHoundService"
+
+// DefaultTimeout is the deadline of each phase of a run started by the
+// service.
+const DefaultTimeout = 20 * time.Second
+
+// Error codes returned by the RandHound service.
+const (
+	// ErrorParameter indicates an invalid request parameter.
+	ErrorParameter = 4100 + iota
+	// ErrorProtocol indicates that the protocol run failed.
+	ErrorProtocol
+	// ErrorUnknownSession indicates that no run with the given SID is stored.
+	ErrorUnknownSession
+	// ErrorTranscript indicates that a transcript could not be decoded.
+	ErrorTranscript
+	// ErrorBeacon indicates that a beacon request could not be served.
+	ErrorBeacon
+	// ErrorUnauthorized indicates that a request is not signed by an
+	// operator of the server.
+	ErrorUnauthorized
+	// ErrorBusy indicates that a GenerateRandom request came too soon after
+	// the previous one.
+	ErrorBusy
+)
+
+// DefaultGenerateInterval is the default minimum time between the starts of
+// two runs requested with GenerateRandom, see Service.SetGenerateInterval.
+const DefaultGenerateInterval = time.Second
+
+// subscriberBuffer is how many messages a subscriber can lag behind before
+// further messages are dropped.
+const subscriberBuffer = 16
+
+// beaconKey is the identifier under which the beacon history is saved.
+const beaconKey = "beacon"
+
+func init() {
+	_, err := onet.RegisterNewService(ServiceName, newService)
+	log.ErrFatal(err)
+	network.RegisterMessage(&storedRun{})
+	network.RegisterMessage(&storedBeacon{})
+}
+
+// Service runs RandHound on behalf of external clients and stores the
+// transcript of every run. It can also act as the leader of a randomness
+// beacon that starts a new run at a fixed interval.
+type Service struct {
+	*onet.ServiceProcessor
+	timeout time.Duration
+
+	mutex       sync.Mutex
+	operators   []abstract.Point             // Keys allowed to start and stop the beacon
+	lastOp      int64                        // Time of the latest accepted operator request
+	genInterval time.Duration                // Minimum time between two GenerateRandom runs
+	lastGen     time.Time                    // Start of the latest GenerateRandom run
+	generating  bool                         // Whether a GenerateRandom run is in progress
+	state       *serverState                 // Checks on the messages of clients; nil if none
+	beacon      *storedBeacon                // History of the beacon led by this server
+	stopped     chan bool                    // Closed to stop the running beacon; nil if none
+	rounds      map[chan *GetRoundReply]bool // Subscribers of new beacon rounds
+	progress    map[chan *ProgressEvent]bool // Subscribers of progress events
+}
+
+// storedRun is the result of a run as persisted with Context.Save.
+type storedRun struct {
+	Random     []byte
+	Transcript []byte
+}
+
+// storedBeacon is the beacon history as persisted with Context.Save.
+type storedBeacon struct {
+	Rounds []*BeaconRound
+}
+
+func newService(c *onet.Context) onet.Service {
+	s := &Service{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+		timeout:          DefaultTimeout,
+		genInterval:      DefaultGenerateInterval,
+		beacon:           &storedBeacon{},
+		rounds:           make(map[chan *GetRoundReply]bool),
+		progress:         make(map[chan *ProgressEvent]bool),
+	}
+	if err := s.RegisterHandlers(s.GenerateRandom, s.GetTranscript, s.VerifyRandom,
+		s.StartBeacon, s.StopBeacon, s.GetRound, s.GetChain); err != nil {
+		log.ErrFatal(err, "Couldn't register messages")
+	}
+	if err := s.RegisterStreamingHandler(s.SubscribeBeacon); err != nil {
+		log.ErrFatal(err, "Couldn't register messages")
+	}
+	if err := s.RegisterStreamingHandler(s.SubscribeProgress); err != nil {
+		log.ErrFatal(err, "Couldn't register messages")
+	}
+	if s.DataAvailable(beaconKey) {
+		msg, err := s.Load(beaconKey)
+		if err != nil {
+			log.Error("Couldn't load beacon history:", err)
+		} else if b, ok := msg.(*storedBeacon); ok {
+			s.beacon = b
+		}
+	}
+	return s
+}
+
+// GenerateRandom runs RandHound with this server as the client and stores the
+// resulting transcript under its session identifier. As anybody can send the
+// request, the server only runs one at a time and waits for the interval set
+// with SetGenerateInterval between two of them; other requests fail with
+// ErrorBusy.
+func (s *Service) GenerateRandom(req *GenerateRandom) (*GenerateRandomReply, onet.ClientError) {
+	if req.Roster == nil || len(req.Roster.List) < 2 {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Need at least one server besides the client")
+	}
+	if req.Groups <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Need at least one group")
+	}
+	if req.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity()) == nil {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Server is not part of the roster")
+	}
+
+	s.mutex.Lock()
+	if s.generating || time.Since(s.lastGen) < s.genInterval {
+		s.mutex.Unlock()
+		return nil, onet.NewClientErrorCode(ErrorBusy, "Too many requests, try again later")
+	}
+	s.generating = true
+	s.lastGen = time.Now()
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.generating = false
+		s.mutex.Unlock()
+	}()
+
+	rh, random, tb, err := s.run(req.Roster, req.Groups, req.Faulty, req.Purpose, nil)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorProtocol, err.Error())
+	}
+
+	return &GenerateRandomReply{
+		SID:        rh.sid,
+		Random:     random,
+		Transcript: tb,
+	}, nil
+}
+
+// Generate runs RandHound on the roster with this server as the client, like
+// a GenerateRandom request, and reports the progress of the run to progress,
+// which may be nil. It returns the randomness and the binary transcript.
+func (s *Service) Generate(roster *onet.Roster, groups int, faulty int, purpose string, progress ProgressFunc) ([]byte, []byte, error) {
+	if roster == nil || len(roster.List) < 2 || groups <= 0 {
+		return nil, nil, errors.New("Need a roster with servers and at least one group")
+	}
+	_, random, tb, err := s.run(roster, groups, faulty, purpose, progress)
+	return random, tb, err
+}
+
+// run executes RandHound with this server as the client and saves the result
+// under the session identifier. It returns the protocol instance, the
+// randomness and the binary transcript.
+func (s *Service) run(roster *onet.Roster, groups int, faulty int, purpose string, progress ProgressFunc) (*RandHound, []byte, []byte, error) {
+	tree := roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
+	if tree == nil {
+		return nil, nil, nil, errors.New("Server is not part of the roster")
+	}
+
+	pi, err := s.CreateProtocol("RandHound", tree)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rh := pi.(*RandHound)
+	if err := rh.Setup(len(tree.Roster.List), faulty, groups, purpose); err != nil {
+		return nil, nil, nil, err
+	}
+	rh.SetTimeout(s.timeout, s.timeout)
+	rh.SetProgress(s.publishProgress(rh, purpose, progress))
+	if err := rh.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := <-rh.Done; err != nil {
+		return nil, nil, nil, err
+	}
+
+	random, transcript, err := rh.Random()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tb, err := transcript.MarshalBinary()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := s.Save(hex.EncodeToString(transcript.SID), &storedRun{Random: random, Transcript: tb}); err != nil {
+		log.Error("Couldn't save transcript:", err)
+	}
+	return rh, random, tb, nil
+}
+
+// GetTranscript returns the stored result of an earlier run.
+func (s *Service) GetTranscript(req *GetTranscript) (*GetTranscriptReply, onet.ClientError) {
+	id := hex.EncodeToString(req.SID)
+	if !s.DataAvailable(id) {
+		return nil, onet.NewClientErrorCode(ErrorUnknownSession, "No run with this session identifier")
+	}
+	msg, err := s.Load(id)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorUnknownSession, err.Error())
+	}
+	stored, ok := msg.(*storedRun)
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorUnknownSession, "Stored data is not a RandHound run")
+	}
+	return &GetTranscriptReply{Random: stored.Random, Transcript: stored.Transcript}, nil
+}
+
+// VerifyRandom checks a random string against a transcript. Invalid proofs are
+// reported in the reply; only undecodable transcripts return an error.
+func (s *Service) VerifyRandom(req *VerifyRandom) (*VerifyRandomReply, onet.ClientError) {
+	t, err := TranscriptFromBinary(network.Suite, req.Transcript)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorTranscript, err.Error())
+	}
+	reply := &VerifyRandomReply{Valid: true}
+	report, err := AuditTranscript(network.Suite, req.Random, t)
+	if err != nil {
+		reply.Valid = false
+		reply.Reason = err.Error()
+	}
+	if report != nil {
+		for _, idx := range report.Servers() {
+			reply.Blamed = append(reply.Blamed, uint32(idx))
+		}
+	}
+	return reply, nil
+}
+
+// SetGenerateInterval sets the minimum time between the starts of two runs
+// requested with GenerateRandom. It defaults to DefaultGenerateInterval.
+func (s *Service) SetGenerateInterval(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.genInterval = d
+}
+
+// SetOperators sets the keys whose signatures are accepted on StartBeacon
+// and StopBeacon requests. By default only the key of the server itself is
+// accepted.
+func (s *Service) SetOperators(keys []abstract.Point) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.operators = keys
+}
+
+// checkOperator verifies that a beacon request has been signed by an operator
+// and is newer than all accepted requests, so that it cannot be replayed.
+// It has to be called with s.mutex held.
+func (s *Service) checkOperator(req encoder, t int64, sig crypto.SchnorrSig) onet.ClientError {
+	now := time.Now()
+	if d := now.Sub(time.Unix(0, t)); d > BeaconRequestWindow || d < -BeaconRequestWindow {
+		return onet.NewClientErrorCode(ErrorUnauthorized, "Request time too far from the server time")
+	}
+	if t <= s.lastOp {
+		return onet.NewClientErrorCode(ErrorUnauthorized, "Request has been replayed")
+	}
+	b, err := req.Encode()
+	if err != nil {
+		return onet.NewClientErrorCode(ErrorParameter, err.Error())
+	}
+	keys := s.operators
+	if keys == nil {
+		keys = []abstract.Point{s.ServerIdentity().Public}
+	}
+	for _, key := range keys {
+		if crypto.VerifySchnorr(network.Suite, key, b, sig) == nil {
+			s.lastOp = t
+			return nil
+		}
+	}
+	return onet.NewClientErrorCode(ErrorUnauthorized, "Request not signed by an operator")
+}
+
+// StartBeacon makes this server the leader of a randomness beacon on the given
+// roster. The first round starts immediately and every following round starts
+// one interval after its predecessor was scheduled. An existing history is
+// continued if the purpose matches. The request has to be signed by an
+// operator.
+func (s *Service) StartBeacon(req *StartBeacon) (*StartBeaconReply, onet.ClientError) {
+	if time.Duration(req.Interval)*time.Millisecond < MinBeaconInterval {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Interval shorter than "+MinBeaconInterval.String())
+	}
+	if req.Roster == nil || len(req.Roster.List) < 2 || req.Groups <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Need a roster with servers and at least one group")
+	}
+	if req.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity()) == nil {
+		return nil, onet.NewClientErrorCode(ErrorParameter, "Server is not part of the roster")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cerr := s.checkOperator(req, req.Time, req.Sig); cerr != nil {
+		return nil, cerr
+	}
+	if s.stopped != nil {
+		return nil, onet.NewClientErrorCode(ErrorBeacon, "Beacon is already running")
+	}
+	if len(s.beacon.Rounds) > 0 && s.beacon.Rounds[0].Purpose != req.Purpose {
+		return nil, onet.NewClientErrorCode(ErrorBeacon, "Beacon history has a different purpose")
+	}
+	s.stopped = make(chan bool)
+	go s.runBeacon(req, s.stopped)
+	return &StartBeaconReply{Round: len(s.beacon.Rounds)}, nil
+}
+
+// StopBeacon stops the beacon after the current round. The history is kept.
+// The request has to be signed by an operator.
+func (s *Service) StopBeacon(req *StopBeacon) (*StopBeaconReply, onet.ClientError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cerr := s.checkOperator(req, req.Time, req.Sig); cerr != nil {
+		return nil, cerr
+	}
+	if s.stopped == nil {
+		return nil, onet.NewClientErrorCode(ErrorBeacon, "Beacon is not running")
+	}
+	close(s.stopped)
+	s.stopped = nil
+	return &StopBeaconReply{Rounds: len(s.beacon.Rounds)}, nil
+}
+
+// GetRound returns a round of the beacon together with its binary transcript.
+// A negative round number returns the latest round.
+func (s *Service) GetRound(req *GetRound) (*GetRoundReply, onet.ClientError) {
+	s.mutex.Lock()
+	n := len(s.beacon.Rounds)
+	idx := req.Round
+	if idx < 0 {
+		idx = n - 1
+	}
+	if idx < 0 || idx >= n {
+		s.mutex.Unlock()
+		return nil, onet.NewClientErrorCode(ErrorBeacon, "Unknown round")
+	}
+	round := s.beacon.Rounds[idx]
+	s.mutex.Unlock()
+
+	msg, err := s.Load(hex.EncodeToString(round.SID))
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorUnknownSession, err.Error())
+	}
+	stored, ok := msg.(*storedRun)
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorUnknownSession, "Stored data is not a RandHound run")
+	}
+	return &GetRoundReply{Round: round, Transcript: stored.Transcript}, nil
+}
+
+// GetChain returns all rounds from the genesis round up to and including the
+// requested round. A negative round number returns the whole history.
+func (s *Service) GetChain(req *GetChain) (*GetChainReply, onet.ClientError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := len(s.beacon.Rounds)
+	if req.Round >= n {
+		return nil, onet.NewClientErrorCode(ErrorBeacon, "Unknown round")
+	}
+	if req.Round >= 0 {
+		n = req.Round + 1
+	}
+	rounds := make([]*BeaconRound, n)
+	copy(rounds, s.beacon.Rounds)
+	return &GetChainReply{Rounds: rounds}, nil
+}
+
+// runBeacon starts a beacon round at every interval until stopped is closed.
+func (s *Service) runBeacon(req *StartBeacon, stopped chan bool) {
+	interval := time.Duration(req.Interval) * time.Millisecond
+	next := time.Now()
+	for {
+		if err := s.beaconRound(req, next); err != nil {
+			log.Error("Beacon round failed:", err)
+		}
+
+		// Skip the slots that passed during a slow round
+		next = next.Add(interval)
+		for next.Before(time.Now()) {
+			next = next.Add(interval)
+		}
+		select {
+		case <-stopped:
+			return
+		case <-time.After(next.Sub(time.Now())):
+		}
+	}
+}
+
+// beaconRound runs RandHound for the next round of the beacon, chained to the
+// previous round, and appends the signed round to the history.
+func (s *Service) beaconRound(req *StartBeacon, scheduled time.Time) error {
+	s.mutex.Lock()
+	n := len(s.beacon.Rounds)
+	var prev *BeaconRound
+	if n > 0 {
+		prev = s.beacon.Rounds[n-1]
+	}
+	s.mutex.Unlock()
+
+	round := &BeaconRound{
+		Round:   n,
+		Purpose: req.Purpose,
+		Time:    scheduled.UnixNano(),
+	}
+	var prevRandom []byte
+	if prev != nil {
+		prevRandom = prev.Random
+		var err error
+		if round.Prev, err = prev.Hash(network.Suite); err != nil {
+			return err
+		}
+	}
+
+	rh, random, tb, err := s.run(req.Roster, req.Groups, req.Faulty, beaconPurpose(req.Purpose, n, prevRandom), nil)
+	if err != nil {
+		return err
+	}
+	round.SID = rh.sid
+	round.Random = random
+	if round.TranscriptHash, err = crypto.HashBytes(network.Suite.Hash(), tb); err != nil {
+		return err
+	}
+	if err := round.sign(network.Suite, rh.Private()); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.beacon.Rounds) != n {
+		return errors.New("Beacon history changed during the round")
+	}
+	s.beacon.Rounds = append(s.beacon.Rounds, round)
+	log.Lvlf2("%v: beacon round %v: %x", s.ServerIdentity(), n, random)
+	reply := &GetRoundReply{Round: round, Transcript: tb}
+	for sub := range s.rounds {
+		select {
+		case sub <- reply:
+		default:
+			log.Lvl2("Dropping beacon round for slow subscriber")
+		}
+	}
+	// The round has been published, so the beacon goes on even if the
+	// history can't be persisted
+	if err := s.Save(beaconKey, s.beacon); err != nil {
+		log.Error("Couldn't save beacon history:", err)
+	}
+	return nil
+}
+
+// SubscribeBeacon pushes every new round of the beacon led by this server to
+// the client until it unsubscribes.
+func (s *Service) SubscribeBeacon(req *SubscribeBeacon, stop chan bool) (chan *GetRoundReply, onet.ClientError) {
+	rounds := make(chan *GetRoundReply, subscriberBuffer)
+	s.mutex.Lock()
+	s.rounds[rounds] = true
+	s.mutex.Unlock()
+	go func() {
+		<-stop
+		s.mutex.Lock()
+		delete(s.rounds, rounds)
+		s.mutex.Unlock()
+	}()
+	return rounds, nil
+}
+
+// SubscribeProgress pushes the progress of all runs this server executes as
+// the client until the client unsubscribes.
+func (s *Service) SubscribeProgress(req *SubscribeProgress, stop chan bool) (chan *ProgressEvent, onet.ClientError) {
+	events := make(chan *ProgressEvent, subscriberBuffer)
+	s.mutex.Lock()
+	s.progress[events] = true
+	s.mutex.Unlock()
+	go func() {
+		<-stop
+		s.mutex.Lock()
+		delete(s.progress, events)
+		s.mutex.Unlock()
+	}()
+	return events, nil
+}
+
+// publishProgress returns a ProgressFunc that calls progress, which may be
+// nil, and pushes the event of the run of rh to all progress subscribers.
+func (s *Service) publishProgress(rh *RandHound, purpose string, progress ProgressFunc) ProgressFunc {
+	return func(phase int, received int, expected int) {
+		if progress != nil {
+			progress(phase, received, expected)
+		}
+		// The session identifier is only known once Start ran; progress
+		// callbacks are run with rh.mutex held, so it is read directly.
+		ev := &ProgressEvent{
+			SID:      rh.sid,
+			Purpose:  purpose,
+			Phase:    phase,
+			Received: received,
+			Expected: expected,
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for sub := range s.progress {
+			select {
+			case sub <- ev:
+			default:
+				log.Lvl2("Dropping progress event for slow subscriber")
+			}
+		}
+	}
+}
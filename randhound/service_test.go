@@ -0,0 +1,231 @@
+package randhound_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"mobilehound/log"
+	"mobilehound/network"
+	"mobilehound/randhound"
+)
+
+func TestService(t *testing.T) {
+
+	var nodes int = 8
+	var groups int = 2
+	var faulty int = 1
+	var purpose string = "RandHound service test"
+
+	local, servers, roster, _ := newLocal(nodes, true)
+	defer local.CloseAll()
+	service := servers[0].Service(randhound.ServiceName).(*randhound.Service)
+	service.SetGenerateInterval(time.Hour)
+
+	client := randhound.NewClient()
+	defer client.Close()
+
+	reply, cerr := client.GenerateRandom(roster, groups, faulty, purpose)
+	if cerr != nil {
+		t.Fatal("Couldn't generate randomness:", cerr)
+	}
+	if _, cerr := client.GenerateRandom(roster, groups, faulty, purpose); cerr == nil ||
+		cerr.ErrorCode() != randhound.ErrorBusy {
+		t.Fatal("Second run within the interval should be rejected:", cerr)
+	}
+	log.Lvlf1("RandHound - service randomness: %x", reply.Random)
+
+	stored, cerr := client.GetTranscript(roster.List[0], reply.SID)
+	if cerr != nil {
+		t.Fatal("Couldn't fetch transcript:", cerr)
+	}
+	if !bytes.Equal(stored.Random, reply.Random) || !bytes.Equal(stored.Transcript, reply.Transcript) {
+		t.Fatal("Stored run differs from the generated one")
+	}
+
+	verify, cerr := client.VerifyRandom(roster.List[1], reply.Random, reply.Transcript)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if !verify.Valid {
+		t.Fatal("Verification failed:", verify.Reason)
+	}
+
+	random := append([]byte{}, reply.Random...)
+	random[0] ^= 0xff
+	verify, cerr = client.VerifyRandom(roster.List[1], random, reply.Transcript)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if verify.Valid {
+		t.Fatal("Modified randomness should not verify")
+	}
+
+	if _, cerr := client.GetTranscript(roster.List[0], []byte{1, 2, 3}); cerr == nil {
+		t.Fatal("Unknown session should be rejected")
+	} else if cerr.ErrorCode() != randhound.ErrorUnknownSession {
+		t.Fatal("Wrong error code:", cerr.ErrorCode())
+	}
+}
+
+func TestServiceBeacon(t *testing.T) {
+
+	var nodes int = 5
+	var groups int = 1
+	var faulty int = 1
+	var purpose string = "RandHound beacon test"
+
+	local, servers, roster, _ := newLocal(nodes, true)
+	defer local.CloseAll()
+	leader := roster.List[0]
+	operator := local.GetPrivate(servers[0])
+
+	client := randhound.NewClient()
+	defer client.Close()
+
+	// Only operators may start or stop the beacon
+	if _, cerr := client.StartBeacon(roster, groups, faulty, purpose, time.Second,
+		local.GetPrivate(servers[1])); cerr == nil || cerr.ErrorCode() != randhound.ErrorUnauthorized {
+		t.Fatal("Beacon started by a non-operator:", cerr)
+	}
+	if _, cerr := client.StartBeacon(roster, groups, faulty, purpose, time.Millisecond, operator); cerr == nil {
+		t.Fatal("Interval below the minimum should be rejected")
+	}
+	stop := &randhound.StopBeacon{}
+	if err := stop.Sign(network.Suite, operator); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, cerr := client.StartBeacon(roster, groups, faulty, purpose, time.Second, operator); cerr != nil {
+		t.Fatal("Couldn't start beacon:", cerr)
+	}
+	if _, cerr := client.StartBeacon(roster, groups, faulty, purpose, time.Second, operator); cerr == nil {
+		t.Fatal("Second beacon should be rejected")
+	}
+	// A request older than the latest accepted one is a replay
+	if cerr := client.SendProtobuf(leader, stop, nil); cerr == nil {
+		t.Fatal("Replayed request should be rejected")
+	}
+
+	// Wait for the first three rounds
+	var round *randhound.BeaconRound
+	for i := 0; i < 300 && round == nil; i++ {
+		round, _, _ = client.GetRound(leader, 2)
+		if round == nil {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if round == nil {
+		t.Fatal("Beacon didn't reach round 2")
+	}
+	if _, cerr := client.StopBeacon(leader, local.GetPrivate(servers[1])); cerr == nil {
+		t.Fatal("Beacon stopped by a non-operator")
+	}
+	if _, cerr := client.StopBeacon(leader, operator); cerr != nil {
+		t.Fatal(cerr)
+	}
+
+	chain := &randhound.GetChainReply{}
+	if cerr := client.SendProtobuf(leader, &randhound.GetChain{Round: 2}, chain); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err := randhound.VerifyBeacon(network.Suite, leader.Public, chain.Rounds); err != nil {
+		t.Fatal("Beacon history doesn't verify:", err)
+	}
+	if !bytes.Equal(chain.Rounds[2].Random, round.Random) {
+		t.Fatal("Wrong randomness in round 2")
+	}
+
+	// The transcript is verified against the bytes the leader sent
+	reply := &randhound.GetRoundReply{}
+	if cerr := client.SendProtobuf(leader, &randhound.GetRound{Round: 2}, reply); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if _, err := randhound.VerifyBeaconRound(network.Suite, round, chain.Rounds[1], reply.Transcript); err != nil {
+		t.Fatal("Round 2 doesn't verify:", err)
+	}
+	reply.Transcript = append(reply.Transcript, 0)
+	if _, err := randhound.VerifyBeaconRound(network.Suite, round, chain.Rounds[1], reply.Transcript); err == nil {
+		t.Fatal("Modified transcript should not verify")
+	}
+
+	// A modified round breaks the chain
+	chain.Rounds[1].Random[0] ^= 0xff
+	if err := randhound.VerifyBeacon(network.Suite, leader.Public, chain.Rounds); err == nil {
+		t.Fatal("Modified history should not verify")
+	}
+	if err := randhound.VerifyBeacon(network.Suite, roster.List[1].Public, chain.Rounds[:1]); err == nil {
+		t.Fatal("History signed by another leader should not verify")
+	}
+}
+
+func TestServiceSubscribe(t *testing.T) {
+
+	var nodes int = 5
+	var groups int = 1
+	var faulty int = 1
+	var purpose string = "RandHound subscription test"
+
+	local, servers, roster, _ := newLocal(nodes, true)
+	defer local.CloseAll()
+	leader := roster.List[0]
+
+	client := randhound.NewClient()
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, cerr := client.SubscribeProgress(ctx, leader)
+	if cerr != nil {
+		t.Fatal("Couldn't subscribe to progress:", cerr)
+	}
+	reply, cerr := client.GenerateRandom(roster, groups, faulty, purpose)
+	if cerr != nil {
+		t.Fatal("Couldn't generate randomness:", cerr)
+	}
+	ev, ok := <-events
+	if !ok {
+		t.Fatal("Progress subscription closed without an event")
+	}
+	if !bytes.Equal(ev.SID, reply.SID) || ev.Purpose != purpose {
+		t.Fatal("Progress event of another run")
+	}
+	if ev.Phase < 1 || ev.Phase > 2 || ev.Received > ev.Expected {
+		t.Fatal("Wrong progress event:", ev)
+	}
+
+	rounds, cerr := client.SubscribeBeacon(ctx, leader)
+	if cerr != nil {
+		t.Fatal("Couldn't subscribe to the beacon:", cerr)
+	}
+	operator := local.GetPrivate(servers[0])
+	if _, cerr := client.StartBeacon(roster, groups, faulty, purpose, time.Second, operator); cerr != nil {
+		t.Fatal("Couldn't start beacon:", cerr)
+	}
+	defer client.StopBeacon(leader, operator)
+	for i := 0; i < 2; i++ {
+		select {
+		case r, ok := <-rounds:
+			if !ok {
+				t.Fatal("Beacon subscription closed before round", i)
+			}
+			if r.Round.Round != i {
+				t.Fatal("Got round", r.Round.Round, "instead of", i)
+			}
+			tr, err := randhound.TranscriptFromBinary(network.Suite, r.Transcript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := randhound.VerifyTranscript(network.Suite, r.Round.Random, tr); err != nil {
+				t.Fatal("Pushed round doesn't verify:", err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("Didn't get beacon round", i)
+		}
+	}
+
+	cancel()
+	for range rounds {
+	}
+}
@@ -0,0 +1,120 @@
+package randhound
+
+import (
+	"errors"
+	"fmt"
+
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+	"mobilehound/v0-random"
+)
+
+// ShardStrategy selects how the permuted servers are assigned to groups.
+type ShardStrategy int
+
+// Supported sharding strategies.
+const (
+	// ShardRoundRobin deals the permuted servers to the groups in turn. It
+	// is the default and matches the grouping of earlier versions.
+	ShardRoundRobin ShardStrategy = iota
+	// ShardBalanced cuts the permutation into consecutive groups whose sizes
+	// differ by at most one, with the larger groups first.
+	ShardBalanced
+)
+
+var strategyNames = map[ShardStrategy]string{
+	ShardRoundRobin: "roundrobin",
+	ShardBalanced:   "balanced",
+}
+
+func (s ShardStrategy) String() string {
+	if name, ok := strategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ShardStrategy(%d)", int(s))
+}
+
+// ParseShardStrategy is the inverse of ShardStrategy.String.
+func ParseShardStrategy(s string) (ShardStrategy, error) {
+	for k, name := range strategyNames {
+		if name == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown sharding strategy %q", s)
+}
+
+// ShardConfig describes how the servers of a roster are split into groups.
+// The zero value is the round-robin strategy without a minimum group size.
+type ShardConfig struct {
+	Strategy     ShardStrategy
+	MinGroupSize int // Use fewer groups if they would be smaller (0 = no minimum)
+}
+
+// ShardIndices computes the grouping of the servers of a roster with the given
+// number of nodes. The client at index 0 is never part of a group. The result
+// only depends on its arguments, so anybody who knows the seed can recompute
+// the grouping of a protocol run. The number of groups can be smaller than
+// shards if the configuration asks for a minimum group size.
+func ShardIndices(suite abstract.Suite, seed []byte, nodes int, shards int, config ShardConfig) ([][]int, error) {
+
+	servers := nodes - 1
+	if shards <= 0 || servers < shards {
+		return nil, errors.New("Number of requested shards not supported")
+	}
+	if config.MinGroupSize > 0 {
+		if servers < config.MinGroupSize {
+			return nil, errors.New("Not enough servers for the minimum group size")
+		}
+		if max := servers / config.MinGroupSize; shards > max {
+			shards = max
+		}
+	}
+
+	// Compute a random permutation of [1,n-1]
+	prng := suite.Cipher(seed)
+	m := make([]int, servers)
+	for i := range m {
+		j := int(random.Uint64(prng) % uint64(i+1))
+		m[i] = m[j]
+		m[j] = i + 1
+	}
+
+	group := make([][]int, shards)
+	switch config.Strategy {
+	case ShardRoundRobin:
+		for i, j := range m {
+			group[i%shards] = append(group[i%shards], j)
+		}
+	case ShardBalanced:
+		k := 0
+		for i := range group {
+			size := servers / shards
+			if i < servers%shards {
+				size++
+			}
+			group[i] = m[k : k+size]
+			k += size
+		}
+	default:
+		return nil, fmt.Errorf("Unknown sharding strategy %v", config.Strategy)
+	}
+	return group, nil
+}
+
+// Shard computes the grouping of the servers of the roster like ShardIndices
+// and additionally returns the public keys of the grouped servers.
+func Shard(suite abstract.Suite, seed []byte, roster *onet.Roster, shards int, config ShardConfig) ([][]int, [][]abstract.Point, error) {
+	group, err := ShardIndices(suite, seed, len(roster.List), shards, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := make([][]abstract.Point, len(group))
+	for i, g := range group {
+		key[i] = make([]abstract.Point, len(g))
+		for j, idx := range g {
+			key[i][j] = roster.List[idx].Public
+		}
+	}
+	return group, key, nil
+}
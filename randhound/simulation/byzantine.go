@@ -0,0 +1,23 @@
+// +build byzantine
+
+package main
+
+import (
+	"mobilehound/onet"
+	"mobilehound/randhound"
+)
+
+// setByzantine configures the Byzantine behaviour of the local server
+// according to spec, e.g. "badshare:3,silent:2"
+func setByzantine(spec string, config *onet.SimulationConfig) error {
+	byzantine, err := randhound.ParseByzantine(spec, len(config.Roster.List))
+	if err != nil {
+		return err
+	}
+	for i, si := range config.Roster.List {
+		if si.ID.Equal(config.Server.ServerIdentity.ID) {
+			randhound.SetBehaviour(si, byzantine[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// +build !byzantine
+
+package main
+
+import (
+	"errors"
+
+	"mobilehound/onet"
+)
+
+// setByzantine fails for any Byzantine servers, which need the byzantine
+// build tag
+func setByzantine(spec string, config *onet.SimulationConfig) error {
+	if spec != "" {
+		return errors.New("Byzantine servers need the byzantine build tag")
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 //gopkg.in/dedis/onet.v1
 import (
+	"time"
+
 	"github.com/BurntSushi/toml"
 	"mobilehound/randhound"
 	"mobilehound/onet"
@@ -16,10 +18,14 @@ func init() {
 // RHSimulation implements a RandHound simulation
 type RHSimulation struct {
 	onet.SimulationBFTree
-	Groups    int
-	GroupSize int
-	Faulty    int
-	Purpose   string
+	Groups       int
+	GroupSize    int
+	Faulty       int
+	Purpose      string
+	Timeout      int    // Per-phase deadline in seconds (0 = wait forever)
+	Byzantine    string // Misbehaving servers, e.g. "badshare:3,silent:2" (byzantine build tag)
+	Sharding     string // Sharding strategy ("roundrobin" or "balanced")
+	MinGroupSize int    // Minimum number of servers per group (0 = none)
 }
 
 // NewRHSimulation creates a new RandHound simulation
@@ -40,6 +46,14 @@ func (rhs *RHSimulation) Setup(dir string, hosts []string) (*onet.SimulationConf
 	return sim, err
 }
 
+// Node configures the Byzantine behaviour of the local server, if any
+func (rhs *RHSimulation) Node(config *onet.SimulationConfig) error {
+	if err := setByzantine(rhs.Byzantine, config); err != nil {
+		return err
+	}
+	return rhs.SimulationBFTree.Node(config)
+}
+
 // Run initiates a RandHound simulation
 func (rhs *RHSimulation) Run(config *onet.SimulationConfig) error {
 	randM := monitor.NewTimeMeasure("tgen-randhound")
@@ -59,12 +73,24 @@ func (rhs *RHSimulation) Run(config *onet.SimulationConfig) error {
 	if err != nil {
 		return err
 	}
+	sharding := randhound.ShardConfig{MinGroupSize: rhs.MinGroupSize}
+	if rhs.Sharding != "" {
+		if sharding.Strategy, err = randhound.ParseShardStrategy(rhs.Sharding); err != nil {
+			return err
+		}
+	}
+	rh.SetSharding(sharding)
+	timeout := time.Duration(rhs.Timeout) * time.Second
+	rh.SetTimeout(timeout, timeout)
 	if err := rh.Start(); err != nil {
 		log.Error("Error while starting protcol:", err)
 	}
 
 	select {
-	case <-rh.Done:
+	case err := <-rh.Done:
+		if err != nil {
+			return err
+		}
 		log.Lvlf1("RandHound - done")
 		random, transcript, err := rh.Random()
 		if err != nil {
@@ -81,9 +107,9 @@ func (rhs *RHSimulation) Run(config *onet.SimulationConfig) error {
 		}
 		verifyM.Record()
 		log.Lvlf1("RandHound - verification: ok")
-
-		//case <-time.After(time.Second * time.Duration(rhs.Hosts) * 5):
-		//log.Print("RandHound - time out")
+		if servers := rh.Blame().Servers(); len(servers) > 0 {
+			log.Lvlf1("RandHound - blamed servers: %v", servers)
+		}
 	}
 
 	return nil
@@ -1,12 +1,14 @@
 package randhound
 
 import (
-	"sync"
-	"time"
-	"mobilehound/onet"
-	"mobilehound/v0-abstract"
+	"errors"
+	"fmt"
 	"mobilehound/crypto"
 	"mobilehound/network"
+	"mobilehound/onet"
+	"mobilehound/v0-abstract"
+	"sync"
+	"time"
 )
 
 func init() {
@@ -31,6 +33,7 @@ type RandHound struct {
 	time    time.Time // Timestamp of initiation
 	cliRand []byte    // Client-chosen randomness (for initial sharding)
 	sid     []byte    // Session identifier
+	version int       // Encoding version of messages and session identifier
 
 	// Group information
 	server              [][]*onet.TreeNode // Grouped servers
@@ -49,11 +52,40 @@ type RandHound struct {
 	secret       map[int][]int            // Valid shares per secret/server (source server index -> list of target server indices)
 	chosenSecret map[int][]int            // Chosen secrets contributing to collective randomness
 
+	// Sharding
+	sharding ShardConfig // Strategy used to split the servers into groups
+
+	// Deadlines
+	timeoutR1 time.Duration // Deadline for collecting R1 messages (0 = none)
+	timeoutR2 time.Duration // Deadline for collecting R2 messages (0 = none)
+	timer     *time.Timer   // Timer of the currently running phase
+	finished  bool          // Boolean to indicate whether Done has been signalled
+
 	// Misc
-	Done        chan bool // Channel to signal the end of a protocol run
-	SecretReady bool      // Boolean to indicate whether the collect randomness is ready or not
+	blames      *BlameReport // Failures observed by the client
+	progress    ProgressFunc // Callback for recorded replies (may be nil)
+	Done        chan error   // Channel to signal the end of a protocol run (nil on success)
+	SecretReady bool         // Boolean to indicate whether the collect randomness is ready or not
+}
+
+// ProgressFunc is called by the client whenever it has recorded a reply.
+// Phase is 1 for R1 and 2 for R2 messages, received is the number of replies
+// of that phase recorded so far and expected the number of servers asked.
+type ProgressFunc func(phase int, received int, expected int)
 
-	//Byzantine map[int]int // for simulating byzantine servers (= key)
+// ErrNotRecoverable is sent on Done if all servers replied but some chosen
+// secrets still cannot be reconstructed.
+var ErrNotRecoverable = errors.New("Some chosen secrets are not reconstructable")
+
+// TimeoutError is sent on Done if a protocol phase did not gather enough
+// replies before its deadline.
+type TimeoutError struct {
+	Phase   int   // Protocol phase that timed out (1 or 2)
+	Missing []int // Roster indices of servers that did not reply
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout in phase %v, missing replies from servers %v", e.Phase, e.Missing)
 }
 
 // Share encapsulates all information for encrypted or decrypted shares and the
@@ -71,6 +103,7 @@ type Transcript struct {
 	SID          []byte             // Session identifier
 	Nodes        int                // Total number of nodes (client + server)
 	Groups       int                // Number of groups
+	Sharding     ShardConfig        // Strategy used to derive the grouping from CliRand
 	Faulty       int                // Maximum number of Byzantine servers
 	Purpose      string             // Purpose of protocol run
 	Time         time.Time          // Timestamp of initiation
@@ -84,6 +117,7 @@ type Transcript struct {
 	I2s          map[int]*I2        // I2 messages sent to servers
 	R1s          map[int]*R1        // R1 messages received from servers
 	R2s          map[int]*R2        // R2 messages received from servers
+	Blame        *BlameReport       // Failures observed by the client (not signed)
 }
 
 // I1 is the message sent by the client to the servers in step 1.
@@ -93,6 +127,18 @@ type I1 struct {
 	Threshold int               // Secret sharing threshold
 	Group     []uint32          // Group indices
 	Key       []abstract.Point  // Public keys of trustees
+
+	// Session parameters which allow servers to recompute the SID
+	Nodes      int         // Total number of nodes (client + servers)
+	Faulty     int         // Maximum number of Byzantine servers
+	Purpose    string      // Purpose of protocol run
+	Time       int64       // Timestamp of initiation (Unix nanoseconds)
+	CliRand    []byte      `protobuf:"opt"` // Client-chosen randomness; unset in legacy sessions
+	Thresholds []uint32    // Thresholds of all groups
+	GroupSize  []uint32    // Sizes of all groups
+	AllGroup   []uint32    // Server indices of all groups (flattened)
+	Groups     int         // Number of requested groups
+	Sharding   ShardConfig // Sharding configuration
 }
 
 // R1 is the reply sent by the servers to the client in step 2.
@@ -0,0 +1,1053 @@
+package randhound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"mobilehound/crypto"
+	"mobilehound/network"
+	"mobilehound/v0-abstract"
+)
+
+// transcriptVersion is the version of the binary transcript encoding and is
+// written as the first field of every binary transcript. Version 2 adds the
+// session parameters to the I1 messages and appends the blame report and the
+// sharding configuration; version 1 transcripts can still be read.
+const transcriptVersion = 2
+
+// jsonTranscript is the canonical JSON representation of a Transcript. Points,
+// scalars and byte strings are hex-encoded. The timestamp is stored as the hex
+// encoding of time.MarshalBinary so that the session identifier can be
+// recomputed byte for byte from an imported transcript.
+type jsonTranscript struct {
+	SID          string
+	Nodes        int
+	Groups       int
+	Strategy     string
+	MinGroupSize int
+	Faulty       int
+	Purpose      string
+	Time         string
+	CliRand      string
+	CliKey       string
+	Group        [][]int
+	Key          [][]string
+	Threshold    []int
+	ChosenSecret map[int][]int
+	I1s          map[int]*jsonI1
+	I2s          map[int]*jsonI2
+	R1s          map[int]*jsonR1
+	R2s          map[int]*jsonR2
+	Blame        []jsonBlame
+}
+
+type jsonBlame struct {
+	Server  int
+	Public  string
+	Address string
+	Phase   int
+	Kind    string
+}
+
+type jsonProofCore struct {
+	C  string
+	R  string
+	VG string
+	VH string
+}
+
+type jsonShare struct {
+	Source int
+	Target int
+	Pos    int
+	Val    string
+	Proof  jsonProofCore
+}
+
+type jsonI1 struct {
+	Sig          string
+	SID          string
+	Threshold    int
+	Group        []uint32
+	Key          []string
+	Nodes        int
+	Faulty       int
+	Purpose      string
+	Time         int64
+	CliRand      string
+	Thresholds   []uint32
+	GroupSize    []uint32
+	AllGroup     []uint32
+	Groups       int
+	Strategy     string
+	MinGroupSize int
+}
+
+type jsonR1 struct {
+	Sig        string
+	HI1        string
+	EncShare   []jsonShare
+	CommitPoly string
+}
+
+type jsonI2 struct {
+	Sig          string
+	SID          string
+	ChosenSecret []uint32
+	EncShare     []jsonShare
+	PolyCommit   []string
+}
+
+type jsonR2 struct {
+	Sig      string
+	HI2      string
+	DecShare []jsonShare
+}
+
+// MarshalJSON returns the canonical JSON encoding of the transcript. Map keys
+// are emitted in sorted order, so encoding the same transcript twice yields
+// identical bytes.
+func (t *Transcript) MarshalJSON() ([]byte, error) {
+	var err error
+	jt := &jsonTranscript{
+		SID:          hex.EncodeToString(t.SID),
+		Nodes:        t.Nodes,
+		Groups:       t.Groups,
+		Strategy:     t.Sharding.Strategy.String(),
+		MinGroupSize: t.Sharding.MinGroupSize,
+		Faulty:       t.Faulty,
+		Purpose:      t.Purpose,
+		CliRand:      hex.EncodeToString(t.CliRand),
+		Group:        t.Group,
+		Threshold:    t.Threshold,
+		ChosenSecret: t.ChosenSecret,
+		I1s:          make(map[int]*jsonI1),
+		I2s:          make(map[int]*jsonI2),
+		R1s:          make(map[int]*jsonR1),
+		R2s:          make(map[int]*jsonR2),
+	}
+
+	tb, err := t.Time.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	jt.Time = hex.EncodeToString(tb)
+
+	if jt.CliKey, err = pointToHex(t.CliKey); err != nil {
+		return nil, err
+	}
+	jt.Key = make([][]string, len(t.Key))
+	for i, gk := range t.Key {
+		if jt.Key[i], err = pointsToHex(gk); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, i1 := range t.I1s {
+		key, err := pointsToHex(i1.Key)
+		if err != nil {
+			return nil, err
+		}
+		jt.I1s[i] = &jsonI1{
+			Sig:          hex.EncodeToString(i1.Sig),
+			SID:          hex.EncodeToString(i1.SID),
+			Threshold:    i1.Threshold,
+			Group:        i1.Group,
+			Key:          key,
+			Nodes:        i1.Nodes,
+			Faulty:       i1.Faulty,
+			Purpose:      i1.Purpose,
+			Time:         i1.Time,
+			CliRand:      hex.EncodeToString(i1.CliRand),
+			Thresholds:   i1.Thresholds,
+			GroupSize:    i1.GroupSize,
+			AllGroup:     i1.AllGroup,
+			Groups:       i1.Groups,
+			Strategy:     i1.Sharding.Strategy.String(),
+			MinGroupSize: i1.Sharding.MinGroupSize,
+		}
+	}
+
+	for i, r1 := range t.R1s {
+		share, err := sharesToJSON(r1.EncShare)
+		if err != nil {
+			return nil, err
+		}
+		jt.R1s[i] = &jsonR1{
+			Sig:        hex.EncodeToString(r1.Sig),
+			HI1:        hex.EncodeToString(r1.HI1),
+			EncShare:   share,
+			CommitPoly: hex.EncodeToString(r1.CommitPoly),
+		}
+	}
+
+	for i, i2 := range t.I2s {
+		share, err := sharesToJSON(i2.EncShare)
+		if err != nil {
+			return nil, err
+		}
+		commit, err := pointsToHex(i2.PolyCommit)
+		if err != nil {
+			return nil, err
+		}
+		jt.I2s[i] = &jsonI2{
+			Sig:          hex.EncodeToString(i2.Sig),
+			SID:          hex.EncodeToString(i2.SID),
+			ChosenSecret: i2.ChosenSecret,
+			EncShare:     share,
+			PolyCommit:   commit,
+		}
+	}
+
+	for i, r2 := range t.R2s {
+		share, err := sharesToJSON(r2.DecShare)
+		if err != nil {
+			return nil, err
+		}
+		jt.R2s[i] = &jsonR2{
+			Sig:      hex.EncodeToString(r2.Sig),
+			HI2:      hex.EncodeToString(r2.HI2),
+			DecShare: share,
+		}
+	}
+
+	if t.Blame != nil {
+		for _, b := range t.Blame.Blames {
+			jb := jsonBlame{
+				Server:  b.Server,
+				Address: string(b.Address),
+				Phase:   b.Phase,
+				Kind:    b.Kind.String(),
+			}
+			if b.Public != nil {
+				if jb.Public, err = pointToHex(b.Public); err != nil {
+					return nil, err
+				}
+			}
+			jt.Blame = append(jt.Blame, jb)
+		}
+	}
+
+	return json.Marshal(jt)
+}
+
+// TranscriptFromJSON parses a transcript created by Transcript.MarshalJSON.
+// The suite is needed to decode the points and scalars.
+func TranscriptFromJSON(suite abstract.Suite, data []byte) (*Transcript, error) {
+	jt := &jsonTranscript{}
+	if err := json.Unmarshal(data, jt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	t := &Transcript{
+		Nodes:        jt.Nodes,
+		Groups:       jt.Groups,
+		Faulty:       jt.Faulty,
+		Purpose:      jt.Purpose,
+		Group:        jt.Group,
+		Threshold:    jt.Threshold,
+		ChosenSecret: jt.ChosenSecret,
+		I1s:          make(map[int]*I1),
+		I2s:          make(map[int]*I2),
+		R1s:          make(map[int]*R1),
+		R2s:          make(map[int]*R2),
+	}
+	if t.ChosenSecret == nil {
+		t.ChosenSecret = make(map[int][]int)
+	}
+
+	t.Sharding.MinGroupSize = jt.MinGroupSize
+	if jt.Strategy != "" {
+		if t.Sharding.Strategy, err = ParseShardStrategy(jt.Strategy); err != nil {
+			return nil, err
+		}
+	}
+	if t.SID, err = hex.DecodeString(jt.SID); err != nil {
+		return nil, err
+	}
+	if t.CliRand, err = hex.DecodeString(jt.CliRand); err != nil {
+		return nil, err
+	}
+	tb, err := hex.DecodeString(jt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Time.UnmarshalBinary(tb); err != nil {
+		return nil, err
+	}
+	if t.CliKey, err = crypto.StringHexToPoint(suite, jt.CliKey); err != nil {
+		return nil, err
+	}
+	t.Key = make([][]abstract.Point, len(jt.Key))
+	for i, gk := range jt.Key {
+		if t.Key[i], err = hexToPoints(suite, gk); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, ji1 := range jt.I1s {
+		if ji1 == nil {
+			return nil, fmt.Errorf("Missing I1 message of %v", i)
+		}
+		i1 := &I1{
+			Threshold:  ji1.Threshold,
+			Group:      ji1.Group,
+			Nodes:      ji1.Nodes,
+			Faulty:     ji1.Faulty,
+			Purpose:    ji1.Purpose,
+			Time:       ji1.Time,
+			Thresholds: ji1.Thresholds,
+			GroupSize:  ji1.GroupSize,
+			AllGroup:   ji1.AllGroup,
+			Groups:     ji1.Groups,
+		}
+		i1.Sharding.MinGroupSize = ji1.MinGroupSize
+		if ji1.Strategy != "" {
+			if i1.Sharding.Strategy, err = ParseShardStrategy(ji1.Strategy); err != nil {
+				return nil, err
+			}
+		}
+		if i1.Sig, err = hex.DecodeString(ji1.Sig); err != nil {
+			return nil, err
+		}
+		if i1.SID, err = hex.DecodeString(ji1.SID); err != nil {
+			return nil, err
+		}
+		if i1.Key, err = hexToPoints(suite, ji1.Key); err != nil {
+			return nil, err
+		}
+		if i1.CliRand, err = hex.DecodeString(ji1.CliRand); err != nil {
+			return nil, err
+		}
+		t.I1s[i] = i1
+	}
+
+	for i, jr1 := range jt.R1s {
+		if jr1 == nil {
+			return nil, fmt.Errorf("Missing R1 message of %v", i)
+		}
+		r1 := &R1{}
+		if r1.Sig, err = hex.DecodeString(jr1.Sig); err != nil {
+			return nil, err
+		}
+		if r1.HI1, err = hex.DecodeString(jr1.HI1); err != nil {
+			return nil, err
+		}
+		if r1.EncShare, err = sharesFromJSON(suite, jr1.EncShare); err != nil {
+			return nil, err
+		}
+		if r1.CommitPoly, err = hex.DecodeString(jr1.CommitPoly); err != nil {
+			return nil, err
+		}
+		t.R1s[i] = r1
+	}
+
+	for i, ji2 := range jt.I2s {
+		if ji2 == nil {
+			return nil, fmt.Errorf("Missing I2 message of %v", i)
+		}
+		i2 := &I2{ChosenSecret: ji2.ChosenSecret}
+		if i2.Sig, err = hex.DecodeString(ji2.Sig); err != nil {
+			return nil, err
+		}
+		if i2.SID, err = hex.DecodeString(ji2.SID); err != nil {
+			return nil, err
+		}
+		if i2.EncShare, err = sharesFromJSON(suite, ji2.EncShare); err != nil {
+			return nil, err
+		}
+		if i2.PolyCommit, err = hexToPoints(suite, ji2.PolyCommit); err != nil {
+			return nil, err
+		}
+		t.I2s[i] = i2
+	}
+
+	for i, jr2 := range jt.R2s {
+		if jr2 == nil {
+			return nil, fmt.Errorf("Missing R2 message of %v", i)
+		}
+		r2 := &R2{}
+		if r2.Sig, err = hex.DecodeString(jr2.Sig); err != nil {
+			return nil, err
+		}
+		if r2.HI2, err = hex.DecodeString(jr2.HI2); err != nil {
+			return nil, err
+		}
+		if r2.DecShare, err = sharesFromJSON(suite, jr2.DecShare); err != nil {
+			return nil, err
+		}
+		t.R2s[i] = r2
+	}
+
+	t.Blame = &BlameReport{}
+	for _, jb := range jt.Blame {
+		b := Blame{
+			Server:  jb.Server,
+			Address: network.Address(jb.Address),
+			Phase:   jb.Phase,
+		}
+		if b.Kind, err = parseFailureKind(jb.Kind); err != nil {
+			return nil, err
+		}
+		if jb.Public != "" {
+			if b.Public, err = crypto.StringHexToPoint(suite, jb.Public); err != nil {
+				return nil, err
+			}
+		}
+		t.Blame.Blames = append(t.Blame.Blames, b)
+	}
+
+	return t, nil
+}
+
+func pointToHex(p abstract.Point) (string, error) {
+	if p == nil {
+		return "", errors.New("Missing point")
+	}
+	return crypto.PointToStringHex(nil, p)
+}
+
+func pointsToHex(ps []abstract.Point) ([]string, error) {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		var err error
+		if s[i], err = pointToHex(p); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func hexToPoints(suite abstract.Suite, s []string) ([]abstract.Point, error) {
+	ps := make([]abstract.Point, len(s))
+	for i, h := range s {
+		var err error
+		if ps[i], err = crypto.StringHexToPoint(suite, h); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+func sharesToJSON(share []Share) ([]jsonShare, error) {
+	js := make([]jsonShare, len(share))
+	for i, s := range share {
+		val, err := pointToHex(s.Val)
+		if err != nil {
+			return nil, err
+		}
+		c, err := crypto.ScalarToStringHex(nil, s.Proof.C)
+		if err != nil {
+			return nil, err
+		}
+		r, err := crypto.ScalarToStringHex(nil, s.Proof.R)
+		if err != nil {
+			return nil, err
+		}
+		vg, err := pointToHex(s.Proof.VG)
+		if err != nil {
+			return nil, err
+		}
+		vh, err := pointToHex(s.Proof.VH)
+		if err != nil {
+			return nil, err
+		}
+		js[i] = jsonShare{
+			Source: s.Source,
+			Target: s.Target,
+			Pos:    s.Pos,
+			Val:    val,
+			Proof:  jsonProofCore{C: c, R: r, VG: vg, VH: vh},
+		}
+	}
+	return js, nil
+}
+
+func sharesFromJSON(suite abstract.Suite, js []jsonShare) ([]Share, error) {
+	share := make([]Share, len(js))
+	for i, s := range js {
+		val, err := crypto.StringHexToPoint(suite, s.Val)
+		if err != nil {
+			return nil, err
+		}
+		c, err := crypto.StringHexToScalar(suite, s.Proof.C)
+		if err != nil {
+			return nil, err
+		}
+		r, err := crypto.StringHexToScalar(suite, s.Proof.R)
+		if err != nil {
+			return nil, err
+		}
+		vg, err := crypto.StringHexToPoint(suite, s.Proof.VG)
+		if err != nil {
+			return nil, err
+		}
+		vh, err := crypto.StringHexToPoint(suite, s.Proof.VH)
+		if err != nil {
+			return nil, err
+		}
+		share[i] = Share{
+			Source: s.Source,
+			Target: s.Target,
+			Pos:    s.Pos,
+			Val:    val,
+			Proof:  ProofCore{C: c, R: r, VG: vg, VH: vh},
+		}
+	}
+	return share, nil
+}
+
+// MarshalBinary returns a compact binary encoding of the transcript. All
+// integers are little-endian uint32, byte strings are length-prefixed, points
+// and scalars use their fixed-size binary encoding, and maps are written in
+// ascending key order.
+func (t *Transcript) MarshalBinary() ([]byte, error) {
+	w := &binaryWriter{buf: new(bytes.Buffer)}
+
+	w.uint32(transcriptVersion)
+	w.bytes(t.SID)
+	w.uint32(t.Nodes)
+	w.uint32(t.Groups)
+	w.uint32(t.Faulty)
+	w.bytes([]byte(t.Purpose))
+	tb, err := t.Time.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	w.bytes(tb)
+	w.bytes(t.CliRand)
+	w.marshal(t.CliKey)
+
+	w.uint32(len(t.Group))
+	for _, g := range t.Group {
+		w.ints(g)
+	}
+	w.uint32(len(t.Key))
+	for _, gk := range t.Key {
+		w.points(gk)
+	}
+	w.ints(t.Threshold)
+
+	w.uint32(len(t.ChosenSecret))
+	for _, k := range sortedKeys(t.ChosenSecret) {
+		w.uint32(k)
+		w.ints(t.ChosenSecret[k])
+	}
+
+	w.uint32(len(t.I1s))
+	for _, k := range sortedKeys(t.I1s) {
+		i1 := t.I1s[k]
+		w.uint32(k)
+		w.bytes(i1.Sig)
+		w.bytes(i1.SID)
+		w.uint32(i1.Threshold)
+		w.uint32s(i1.Group)
+		w.points(i1.Key)
+		w.uint32(i1.Nodes)
+		w.uint32(i1.Faulty)
+		w.bytes([]byte(i1.Purpose))
+		w.uint64(uint64(i1.Time))
+		w.bytes(i1.CliRand)
+		w.uint32s(i1.Thresholds)
+		w.uint32s(i1.GroupSize)
+		w.uint32s(i1.AllGroup)
+		w.uint32(i1.Groups)
+		w.uint32(int(i1.Sharding.Strategy))
+		w.uint32(i1.Sharding.MinGroupSize)
+	}
+
+	w.uint32(len(t.R1s))
+	for _, k := range sortedKeys(t.R1s) {
+		r1 := t.R1s[k]
+		w.uint32(k)
+		w.bytes(r1.Sig)
+		w.bytes(r1.HI1)
+		w.shares(r1.EncShare)
+		w.bytes(r1.CommitPoly)
+	}
+
+	w.uint32(len(t.I2s))
+	for _, k := range sortedKeys(t.I2s) {
+		i2 := t.I2s[k]
+		w.uint32(k)
+		w.bytes(i2.Sig)
+		w.bytes(i2.SID)
+		w.uint32s(i2.ChosenSecret)
+		w.shares(i2.EncShare)
+		w.points(i2.PolyCommit)
+	}
+
+	w.uint32(len(t.R2s))
+	for _, k := range sortedKeys(t.R2s) {
+		r2 := t.R2s[k]
+		w.uint32(k)
+		w.bytes(r2.Sig)
+		w.bytes(r2.HI2)
+		w.shares(r2.DecShare)
+	}
+
+	var blames []Blame
+	if t.Blame != nil {
+		blames = t.Blame.Blames
+	}
+	w.uint32(len(blames))
+	for _, b := range blames {
+		w.uint32(b.Server)
+		var pub []byte
+		if b.Public != nil {
+			if pub, err = b.Public.MarshalBinary(); err != nil {
+				return nil, err
+			}
+		}
+		w.bytes(pub)
+		w.bytes([]byte(b.Address))
+		w.uint32(b.Phase)
+		w.uint32(int(b.Kind))
+	}
+
+	w.uint32(int(t.Sharding.Strategy))
+	w.uint32(t.Sharding.MinGroupSize)
+
+	return w.result()
+}
+
+// TranscriptFromBinary parses a transcript created by Transcript.MarshalBinary.
+// The suite is needed to decode the points and scalars.
+func TranscriptFromBinary(suite abstract.Suite, data []byte) (*Transcript, error) {
+	r := &binaryReader{suite: suite, buf: bytes.NewReader(data)}
+
+	version := r.uint32()
+	if r.err == nil && (version < 1 || version > transcriptVersion) {
+		return nil, fmt.Errorf("Unsupported transcript version %v", version)
+	}
+
+	t := &Transcript{
+		ChosenSecret: make(map[int][]int),
+		I1s:          make(map[int]*I1),
+		I2s:          make(map[int]*I2),
+		R1s:          make(map[int]*R1),
+		R2s:          make(map[int]*R2),
+	}
+	t.SID = r.bytes()
+	t.Nodes = r.uint32()
+	t.Groups = r.uint32()
+	t.Faulty = r.uint32()
+	t.Purpose = string(r.bytes())
+	tb := r.bytes()
+	t.CliRand = r.bytes()
+	t.CliKey = r.point()
+
+	t.Group = make([][]int, r.length())
+	for i := range t.Group {
+		t.Group[i] = r.ints()
+	}
+	t.Key = make([][]abstract.Point, r.length())
+	for i := range t.Key {
+		t.Key[i] = r.points()
+	}
+	t.Threshold = r.ints()
+
+	for n := r.length(); n > 0; n-- {
+		k := r.uint32()
+		t.ChosenSecret[k] = r.ints()
+	}
+
+	for n := r.length(); n > 0; n-- {
+		k := r.uint32()
+		i1 := &I1{
+			Sig:       r.bytes(),
+			SID:       r.bytes(),
+			Threshold: r.uint32(),
+			Group:     r.uint32s(),
+			Key:       r.points(),
+		}
+		if version >= 2 {
+			i1.Nodes = r.uint32()
+			i1.Faulty = r.uint32()
+			i1.Purpose = string(r.bytes())
+			i1.Time = int64(r.uint64())
+			i1.CliRand = r.bytes()
+			i1.Thresholds = r.uint32s()
+			i1.GroupSize = r.uint32s()
+			i1.AllGroup = r.uint32s()
+			i1.Groups = r.uint32()
+			i1.Sharding.Strategy = ShardStrategy(r.uint32())
+			i1.Sharding.MinGroupSize = r.uint32()
+		}
+		t.I1s[k] = i1
+	}
+
+	for n := r.length(); n > 0; n-- {
+		k := r.uint32()
+		t.R1s[k] = &R1{
+			Sig:        r.bytes(),
+			HI1:        r.bytes(),
+			EncShare:   r.shares(),
+			CommitPoly: r.bytes(),
+		}
+	}
+
+	for n := r.length(); n > 0; n-- {
+		k := r.uint32()
+		t.I2s[k] = &I2{
+			Sig:          r.bytes(),
+			SID:          r.bytes(),
+			ChosenSecret: r.uint32s(),
+			EncShare:     r.shares(),
+			PolyCommit:   r.points(),
+		}
+	}
+
+	for n := r.length(); n > 0; n-- {
+		k := r.uint32()
+		t.R2s[k] = &R2{
+			Sig:      r.bytes(),
+			HI2:      r.bytes(),
+			DecShare: r.shares(),
+		}
+	}
+
+	t.Blame = &BlameReport{}
+	if version >= 2 {
+		for n := r.length(); n > 0; n-- {
+			b := Blame{Server: r.uint32()}
+			if pub := r.bytes(); len(pub) > 0 && r.err == nil {
+				b.Public = suite.Point()
+				r.err = b.Public.UnmarshalBinary(pub)
+			}
+			b.Address = network.Address(r.bytes())
+			b.Phase = r.uint32()
+			b.Kind = FailureKind(r.uint32())
+			t.Blame.Blames = append(t.Blame.Blames, b)
+		}
+		t.Sharding.Strategy = ShardStrategy(r.uint32())
+		t.Sharding.MinGroupSize = r.uint32()
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.buf.Len() != 0 {
+		return nil, errors.New("Trailing data after transcript")
+	}
+	if err := t.Time.UnmarshalBinary(tb); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// check validates the structure of a transcript that may come from an
+// untrusted source, so that it can be audited without indexing out of range
+// or dereferencing missing points.
+func (t *Transcript) check() error {
+	if t.CliKey == nil {
+		return errors.New("Missing client key")
+	}
+	if len(t.Group) != len(t.Key) || len(t.Group) != len(t.Threshold) {
+		return errors.New("Non-matching number of groups, keys and thresholds")
+	}
+	for i := range t.Group {
+		if len(t.Group[i]) != len(t.Key[i]) {
+			return fmt.Errorf("Non-matching number of servers and keys in group %v", i)
+		}
+		// Groups of a single server have threshold 0
+		if t.Threshold[i] < 0 || t.Threshold[i] > len(t.Group[i]) {
+			return fmt.Errorf("Invalid threshold of group %v", i)
+		}
+		if err := checkPoints(t.Key[i]); err != nil {
+			return err
+		}
+	}
+	for i := range t.ChosenSecret {
+		if i < 0 || i >= len(t.Group) {
+			return fmt.Errorf("Chosen secrets of unknown group %v", i)
+		}
+	}
+	for i, i1 := range t.I1s {
+		if i1 == nil {
+			return fmt.Errorf("Missing I1 message of group %v", i)
+		}
+		if i < 0 || i >= len(t.Group) {
+			return fmt.Errorf("I1 message of unknown group %v", i)
+		}
+		if err := checkPoints(i1.Key); err != nil {
+			return err
+		}
+	}
+	for i, r1 := range t.R1s {
+		if r1 == nil {
+			return fmt.Errorf("Missing R1 message of server %v", i)
+		}
+		if err := checkShares(r1.EncShare); err != nil {
+			return err
+		}
+	}
+	for i, i2 := range t.I2s {
+		if i2 == nil {
+			return fmt.Errorf("Missing I2 message of server %v", i)
+		}
+		if err := checkShares(i2.EncShare); err != nil {
+			return err
+		}
+		if err := checkPoints(i2.PolyCommit); err != nil {
+			return err
+		}
+	}
+	for i, r2 := range t.R2s {
+		if r2 == nil {
+			return fmt.Errorf("Missing R2 message of server %v", i)
+		}
+		if err := checkShares(r2.DecShare); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPoints returns an error if one of the points is missing.
+func checkPoints(ps []abstract.Point) error {
+	for _, p := range ps {
+		if p == nil {
+			return errors.New("Missing point")
+		}
+	}
+	return nil
+}
+
+// checkShares returns an error if a value or proof of a share is missing.
+func checkShares(share []Share) error {
+	for _, s := range share {
+		if s.Val == nil || s.Proof.C == nil || s.Proof.R == nil ||
+			s.Proof.VG == nil || s.Proof.VH == nil {
+			return errors.New("Incomplete share")
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map indexed by int in ascending order.
+func sortedKeys(m interface{}) []int {
+	var keys []int
+	switch v := m.(type) {
+	case map[int][]int:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[int]*I1:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[int]*R1:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[int]*I2:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[int]*R2:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// binaryWriter accumulates the binary encoding of a transcript or message and
+// keeps the first error that occurred.
+type binaryWriter struct {
+	buf *bytes.Buffer
+	err error
+}
+
+func (w *binaryWriter) result() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func (w *binaryWriter) uint32(v int) {
+	if w.err != nil {
+		return
+	}
+	w.err = binary.Write(w.buf, binary.LittleEndian, uint32(v))
+}
+
+func (w *binaryWriter) uint64(v uint64) {
+	if w.err != nil {
+		return
+	}
+	w.err = binary.Write(w.buf, binary.LittleEndian, v)
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uint32(len(b))
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.buf.Write(b)
+}
+
+func (w *binaryWriter) marshal(m abstract.Marshaling) {
+	if w.err != nil {
+		return
+	}
+	if m == nil {
+		w.err = errors.New("Missing point or scalar")
+		return
+	}
+	_, w.err = m.MarshalTo(w.buf)
+}
+
+func (w *binaryWriter) ints(v []int) {
+	w.uint32(len(v))
+	for _, i := range v {
+		w.uint32(i)
+	}
+}
+
+func (w *binaryWriter) uint32s(v []uint32) {
+	w.uint32(len(v))
+	for _, i := range v {
+		w.uint32(int(i))
+	}
+}
+
+func (w *binaryWriter) points(ps []abstract.Point) {
+	w.uint32(len(ps))
+	for _, p := range ps {
+		w.marshal(p)
+	}
+}
+
+func (w *binaryWriter) shares(share []Share) {
+	w.uint32(len(share))
+	for _, s := range share {
+		w.uint32(s.Source)
+		w.uint32(s.Target)
+		w.uint32(s.Pos)
+		w.marshal(s.Val)
+		w.marshal(s.Proof.C)
+		w.marshal(s.Proof.R)
+		w.marshal(s.Proof.VG)
+		w.marshal(s.Proof.VH)
+	}
+}
+
+// binaryReader decodes a binary transcript and keeps the first error that
+// occurred; once an error is set all reads return zero values.
+type binaryReader struct {
+	suite abstract.Suite
+	buf   *bytes.Reader
+	err   error
+}
+
+func (r *binaryReader) uint32() int {
+	if r.err != nil {
+		return 0
+	}
+	var v uint32
+	r.err = binary.Read(r.buf, binary.LittleEndian, &v)
+	return int(v)
+}
+
+// length reads a count and makes sure it is not larger than the remaining
+// input, which protects against huge allocations from corrupt data.
+func (r *binaryReader) length() int {
+	n := r.uint32()
+	if r.err == nil && n > r.buf.Len() {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
+
+func (r *binaryReader) uint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	r.err = binary.Read(r.buf, binary.LittleEndian, &v)
+	return v
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.length()
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.buf, b)
+	return b
+}
+
+func (r *binaryReader) unmarshal(m abstract.Marshaling) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = m.UnmarshalFrom(r.buf)
+}
+
+func (r *binaryReader) point() abstract.Point {
+	p := r.suite.Point()
+	r.unmarshal(p)
+	return p
+}
+
+func (r *binaryReader) scalar() abstract.Scalar {
+	s := r.suite.Scalar()
+	r.unmarshal(s)
+	return s
+}
+
+func (r *binaryReader) ints() []int {
+	v := make([]int, r.length())
+	for i := range v {
+		v[i] = r.uint32()
+	}
+	return v
+}
+
+func (r *binaryReader) uint32s() []uint32 {
+	v := make([]uint32, r.length())
+	for i := range v {
+		v[i] = uint32(r.uint32())
+	}
+	return v
+}
+
+func (r *binaryReader) points() []abstract.Point {
+	ps := make([]abstract.Point, r.length())
+	for i := range ps {
+		ps[i] = r.point()
+	}
+	return ps
+}
+
+func (r *binaryReader) shares() []Share {
+	share := make([]Share, r.length())
+	for i := range share {
+		share[i] = Share{
+			Source: r.uint32(),
+			Target: r.uint32(),
+			Pos:    r.uint32(),
+			Val:    r.point(),
+			Proof: ProofCore{
+				C:  r.scalar(),
+				R:  r.scalar(),
+				VG: r.point(),
+				VH: r.point(),
+			},
+		}
+	}
+	return share
+}